@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gorilla/mux"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
 	"github.com/lmzuccarelli/golang-redis-publisher/pkg/connectors"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/grpcapi"
 	"github.com/lmzuccarelli/golang-redis-publisher/pkg/handlers"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/metrics"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/tracing"
 	"github.com/lmzuccarelli/golang-redis-publisher/pkg/validator"
 	"github.com/microlib/simple"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -20,44 +27,107 @@ const (
 )
 
 var (
-	logger       *simple.Logger
-	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
-		Name: "redis_publisher_http_duration_seconds",
-		Help: "Duration of HTTP requests.",
-	}, []string{"path"})
+	logger *simple.Logger
 )
 
 // prometheusMiddleware implements mux.MiddlewareFunc.
 func prometheusMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set(CONTENTTYPE, APPLICATIONJSON)
-		// use this for cors
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, Accept-Language")
 		route := mux.CurrentRoute(r)
 		path, _ := route.GetPathTemplate()
-		timer := prometheus.NewTimer(httpDuration.WithLabelValues(path))
-		next.ServeHTTP(w, r)
+		timer := prometheus.NewTimer(metrics.HttpDuration.WithLabelValues(path))
+		// continue the caller's trace (w3c traceparent) or start a new one
+		ctx, span := tracing.StartServer(r, path)
+		sw := tracing.NewStatusWriter(w)
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		tracing.EndServer(span, sw.Status)
 		timer.ObserveDuration()
 	})
 }
 
 func startHttpServer(con connectors.Clients) *http.Server {
-	srv := &http.Server{Addr: ":" + os.Getenv("SERVER_PORT")}
-	logger.Info("Starting server on port " + srv.Addr)
+	srv := &http.Server{Addr: ":" + config.Get().ServerPort}
+	con.Info("Starting server on port %s", srv.Addr)
 
 	r := mux.NewRouter()
+	r.Use(handlers.RequestIdMiddleware)
 	r.Use(prometheusMiddleware)
+	r.Use(handlers.BodyMiddleware)
+	// api key or jwt subject of the client rate limits
+	r.Use(handlers.IdentityMiddleware)
 	r.Path("/metrics").Handler(promhttp.Handler())
 
-	r.HandleFunc("/api/v1/publish", func(w http.ResponseWriter, req *http.Request) {
+	r.Handle("/api/v1/publish", handlers.RateLimitMiddleware(con)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		handlers.SendPayloadHandler(w, req, con)
-	}).Methods("POST", "OPTIONS")
+	}))).Methods("POST")
 
 	r.HandleFunc("/api/v1/isalive", handlers.IsAlive).Methods("GET")
 
-	http.Handle("/", r)
+	// publish, subscribe and unsubscribe frames over one websocket, authorized as the server-sent events
+	// (client rate limit on the upgrade and every publish)
+	r.Handle("/api/v1/ws", handlers.StreamAuthMiddleware(handlers.RateLimitMiddleware(con)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		handlers.WebSocketHandler(w, req, con)
+	})))).Methods("GET")
+
+	// server-sent events, the subscribe token may be sent as ?access_token= by browsers
+	r.Handle("/api/v1/subscribe/{topic}", handlers.StreamAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		handlers.SubscribeHandler(w, req, con)
+	}))).Methods("GET")
+
+	// every admin route needs the ADMIN_TOKEN bearer token
+	admin := r.PathPrefix("/api/v1/admin").Subrouter()
+	admin.Use(handlers.AdminAuthMiddleware)
+
+	admin.HandleFunc("/schemas", func(w http.ResponseWriter, req *http.Request) {
+		handlers.ListSchemasHandler(w, req, con)
+	}).Methods("GET")
+
+	admin.HandleFunc("/schemas/{topic}/versions", func(w http.ResponseWriter, req *http.Request) {
+		handlers.ListSchemaVersionsHandler(w, req, con)
+	}).Methods("GET")
+
+	admin.HandleFunc("/schemas/{topic}/versions", func(w http.ResponseWriter, req *http.Request) {
+		handlers.RegisterSchemaHandler(w, req, con)
+	}).Methods("POST")
+
+	admin.HandleFunc("/schemas/{topic}/versions/{version}", func(w http.ResponseWriter, req *http.Request) {
+		handlers.GetSchemaVersionHandler(w, req, con)
+	}).Methods("GET")
+
+	admin.HandleFunc("/topics", func(w http.ResponseWriter, req *http.Request) {
+		handlers.ListTopicsHandler(w, req, con)
+	}).Methods("GET")
+
+	admin.HandleFunc("/topics/{topic}", func(w http.ResponseWriter, req *http.Request) {
+		handlers.GetTopicHandler(w, req, con)
+	}).Methods("GET")
+
+	admin.HandleFunc("/deadletters", func(w http.ResponseWriter, req *http.Request) {
+		handlers.ListDeadLettersHandler(w, req, con)
+	}).Methods("GET")
+
+	admin.HandleFunc("/deadletters/{id}", func(w http.ResponseWriter, req *http.Request) {
+		handlers.GetDeadLetterHandler(w, req, con)
+	}).Methods("GET")
+
+	admin.HandleFunc("/deadletters/{id}/redrive", func(w http.ResponseWriter, req *http.Request) {
+		handlers.RedriveDeadLetterHandler(w, req, con)
+	}).Methods("POST")
+
+	admin.HandleFunc("/config", func(w http.ResponseWriter, req *http.Request) {
+		handlers.GetDynamicConfigHandler(w, req, con)
+	}).Methods("GET")
+
+	admin.HandleFunc("/config/{kind}/{topic}", func(w http.ResponseWriter, req *http.Request) {
+		handlers.PutDynamicConfigHandler(w, req, con)
+	}).Methods("PUT", "DELETE")
+
+	admin.HandleFunc("/reload", func(w http.ResponseWriter, req *http.Request) {
+		handlers.ReloadHandler(w, req, con)
+	}).Methods("POST")
+
+	http.Handle("/", handlers.CorsMiddleware(r))
 
 	if err := srv.ListenAndServe(); err != nil {
 		con.Error("Httpserver: ListenAndServe() error: " + err.Error())
@@ -66,19 +136,86 @@ func startHttpServer(con connectors.Clients) *http.Server {
 	return srv
 }
 
+// startGrpcServer - the Publisher service (with health and reflection) on GRPC_PORT
+func startGrpcServer(con connectors.Clients, port string) {
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		con.Error("Grpcserver: Listen() error: " + err.Error())
+		return
+	}
+	con.Info("Starting grpc server on port :%s", port)
+	if err := grpcapi.NewServer(con).Serve(lis); err != nil {
+		con.Error("Grpcserver: Serve() error: " + err.Error())
+	}
+}
+
+// reloadOnSignal - reloads the configuration on every SIGHUP, keeping the previous one on failure
+func reloadOnSignal(con connectors.Clients) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		version, err := handlers.Reload(context.Background(), con)
+		if err != nil {
+			con.Error("SIGHUP reload failed, keeping version %s : %v", handlers.ConfigVersion(), err)
+			continue
+		}
+		con.Info("SIGHUP reloaded configuration version %s", version)
+	}
+}
+
 func main() {
 
-	if os.Getenv("LOG_LEVEL") == "" {
+	for _, arg := range os.Args[1:] {
+		if arg == "--help-env" || arg == "-help-env" {
+			validator.HelpEnv(os.Stdout)
+			os.Exit(0)
+		}
+	}
+
+	// defaults, config files, envars and flags (see config.Load)
+	cfg, err := config.Load(os.Args[1:])
+	if cfg == nil {
 		logger = &simple.Logger{Level: "info"}
-	} else {
-		logger = &simple.Logger{Level: os.Getenv("LOG_LEVEL")}
+		logger.Error("Config " + err.Error())
+		os.Exit(-1)
+	}
+	config.Set(cfg)
+	logger = &simple.Logger{Level: cfg.LogLevel}
+
+	// invalid values are reported here with every other problem
+	err = validator.ValidateEnvars(logger)
+	if err != nil {
+		os.Exit(-1)
 	}
 
-	err := validator.ValidateEnvars(logger)
+	shutdown, err := tracing.Init(context.Background())
 	if err != nil {
+		logger.Error("Tracing " + err.Error())
 		os.Exit(-1)
 	}
+	defer shutdown(context.Background())
 
 	conn := connectors.NewClientConnections(logger)
+	conn.Info("Effective configuration %s", cfg)
+	// templates, encodings, schemas (and the schema registry), encryption, enrichment and rate limits
+	version, err := handlers.ApplyConfig(context.Background(), conn, cfg)
+	if err != nil {
+		logger.Error("Config " + err.Error())
+		os.Exit(-1)
+	}
+	conn.Info("Configuration version %s", version)
+
+	// hot reload on SIGHUP and, with RELOAD_INTERVAL, whenever a config file changes
+	go reloadOnSignal(conn)
+	if cfg.ReloadInterval > 0 {
+		go handlers.WatchConfig(context.Background(), conn, cfg.ReloadInterval)
+	}
+	// per topic templates and rate limits shared by all replicas through redis
+	if cfg.DynamicConfig != "" {
+		go handlers.WatchDynamicConfig(context.Background(), conn)
+	}
+	if cfg.GrpcPort != "" {
+		go startGrpcServer(conn, cfg.GrpcPort)
+	}
 	startHttpServer(conn)
 }
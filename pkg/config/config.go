@@ -0,0 +1,338 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	MASK          string = "****"
+	DEFAULTCONFIG string = "config.json"
+)
+
+// Config - the typed service configuration, every field is named after its envar (env tag)
+// default is applied when no layer sets the value, secret values are masked when printed
+type Config struct {
+	Name                 string        `env:"NAME"`
+	Version              string        `env:"VERSION"`
+	ServerPort           string        `env:"SERVER_PORT"`
+	GrpcPort             string        `env:"GRPC_PORT"`
+	Topic                string        `env:"TOPIC"`
+	TopicNamespace       string        `env:"TOPIC_NAMESPACE"`
+	AdminToken           string        `env:"ADMIN_TOKEN" secret:"true"`
+	SubscribeToken       string        `env:"SUBSCRIBE_TOKEN" secret:"true"`
+	SseHeartbeat         time.Duration `env:"SSE_HEARTBEAT" default:"15s"`
+	WsSendBuffer         int           `env:"WS_SEND_BUFFER" default:"64"`
+	WsSlowConsumer       string        `env:"WS_SLOW_CONSUMER" default:"disconnect"`
+	LogLevel             string        `env:"LOG_LEVEL" default:"info"`
+	LogFormat            string        `env:"LOG_FORMAT" default:"text"`
+	RedisAddr            string        `env:"REDIS_ADDR" default:"localhost:6379"`
+	RedisPassword        string        `env:"REDIS_PASSWORD" secret:"true"`
+	JwtSecretKey         string        `env:"JWT_SECRETKEY" secret:"true"`
+	ApiKeys              string        `env:"API_KEYS" secret:"true"`
+	Envelope             bool          `env:"ENVELOPE"`
+	SchemaVersion        string        `env:"SCHEMA_VERSION"`
+	CETypeTopic          bool          `env:"CE_TYPE_TOPIC"`
+	DeadLetter           string        `env:"DEADLETTER"`
+	DeadLetterKey        string        `env:"DEADLETTER_KEY" default:"deadletter"`
+	DeadLetterFile       string        `env:"DEADLETTER_FILE" default:"deadletter.jsonl"`
+	DeadLetterMax        int           `env:"DEADLETTER_MAX" default:"10000"`
+	EncodingConfig       string        `env:"ENCODING_CONFIG"`
+	SchemaConfig         string        `env:"SCHEMA_CONFIG"`
+	EncryptionConfig     string        `env:"ENCRYPTION_CONFIG"`
+	RateLimitConfig      string        `env:"RATELIMIT_CONFIG"`
+	TemplateConfig       string        `env:"TEMPLATE_CONFIG"`
+	EnrichmentConfig     string        `env:"ENRICHMENT_CONFIG"`
+	ReloadInterval       time.Duration `env:"RELOAD_INTERVAL"`
+	DynamicConfig        string        `env:"DYNAMIC_CONFIG"`
+	DynamicConfigKey     string        `env:"DYNAMIC_CONFIG_KEY" default:"dynamicconfig"`
+	SchemaRegistry       string        `env:"SCHEMA_REGISTRY"`
+	SchemaRegistryKey    string        `env:"SCHEMA_REGISTRY_KEY" default:"schemaregistry"`
+	SchemaRegistryDir    string        `env:"SCHEMA_REGISTRY_DIR" default:"schemaregistry"`
+	SchemaCompatibility  string        `env:"SCHEMA_COMPATIBILITY" default:"backward"`
+	Redact               bool          `env:"REDACT" default:"true"`
+	RedactFields         string        `env:"REDACT_FIELDS"`
+	RedactDetectors      string        `env:"REDACT_DETECTORS"`
+	OtelExporter         string        `env:"OTEL_EXPORTER" default:"none"`
+	MaxBodySize          int64         `env:"MAX_BODY_SIZE" default:"1048576"`
+	MaxBodySizeRoutes    string        `env:"MAX_BODY_SIZE_ROUTES"`
+	CorsAllowedOrigins   string        `env:"CORS_ALLOWED_ORIGINS"`
+	CorsAllowedMethods   string        `env:"CORS_ALLOWED_METHODS"`
+	CorsAllowedHeaders   string        `env:"CORS_ALLOWED_HEADERS"`
+	CorsExposedHeaders   string        `env:"CORS_EXPOSED_HEADERS"`
+	CorsAllowCredentials bool          `env:"CORS_ALLOW_CREDENTIALS"`
+	CorsMaxAge           int           `env:"CORS_MAX_AGE"`
+
+	// the layered values as set (see Raw)
+	raw map[string]string
+}
+
+// file - the config file layout, the Env block holds envar names and values
+type file struct {
+	Env map[string]interface{} `json:"Env" yaml:"Env"`
+}
+
+var (
+	mu       sync.RWMutex
+	current  *Config
+	lastArgs []string
+)
+
+// Get - the active configuration
+// until Set is called the configuration follows the environment (defaults and envars only)
+func Get() *Config {
+	mu.RLock()
+	cfg := current
+	mu.RUnlock()
+	if cfg != nil {
+		return cfg
+	}
+	cfg, _ = FromEnv()
+	return cfg
+}
+
+// Set - installs the configuration returned by Load (nil reverts to the environment)
+func Set(cfg *Config) {
+	mu.Lock()
+	current = cfg
+	mu.Unlock()
+}
+
+// FromEnv - the defaults overlaid with the environment
+func FromEnv() (*Config, error) {
+	values := defaults()
+	overlayEnv(values)
+	return build(values)
+}
+
+// Load - layers the defaults, the config files, the environment and the command line flags (in that order)
+// the files are taken from --config (comma separated), the CONFIG_FILE envar or ./config.json when present
+// every setting has a flag named after its envar i.e. --server-port for SERVER_PORT
+// invalid values are returned with the config (without them) and an error listing all of them, the config is
+// nil when a file or the flags can not be read
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
+	files := fs.String("config", os.Getenv("CONFIG_FILE"), "comma separated json or yaml config files")
+	flags := map[string]*string{}
+	for _, f := range fields() {
+		flags[f.env] = fs.String(FlagName(f.env), "", "overrides "+f.env)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	lastArgs = args
+	mu.Unlock()
+
+	values := defaults()
+	names := *files
+	if names == "" {
+		if _, err := os.Stat(DEFAULTCONFIG); err == nil {
+			names = DEFAULTCONFIG
+		}
+	}
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if err := overlayFile(values, name); err != nil {
+			return nil, err
+		}
+	}
+	overlayEnv(values)
+	fs.Visit(func(f *flag.Flag) {
+		for env, v := range flags {
+			if FlagName(env) == f.Name {
+				values[env] = *v
+			}
+		}
+	})
+	return build(values)
+}
+
+// Reload - Load with the arguments of the previous Load (the files and envars are read again)
+func Reload() (*Config, error) {
+	mu.RLock()
+	args := lastArgs
+	mu.RUnlock()
+	return Load(args)
+}
+
+// FlagName - the command line flag for an envar (SERVER_PORT becomes server-port)
+func FlagName(env string) string {
+	return strings.ToLower(strings.ReplaceAll(env, "_", "-"))
+}
+
+// Value - the setting for envar name as a string (empty for unknown names)
+func (c *Config) Value(name string) string {
+	rv := reflect.ValueOf(c).Elem()
+	for _, f := range fields() {
+		if f.env == name {
+			return fmt.Sprint(rv.Field(f.index).Interface())
+		}
+	}
+	return ""
+}
+
+// Raw - the layered value of envar name before conversion (validator.ValidateEnvars checks these)
+func (c *Config) Raw(name string) string {
+	return c.raw[name]
+}
+
+// Effective - every setting by envar name, secrets masked
+func (c *Config) Effective() map[string]string {
+	rv := reflect.ValueOf(c).Elem()
+	out := map[string]string{}
+	for _, f := range fields() {
+		v := fmt.Sprint(rv.Field(f.index).Interface())
+		if f.secret && v != "" {
+			v = MASK
+		}
+		out[f.env] = v
+	}
+	return out
+}
+
+// String - the effective configuration as json (secrets masked)
+func (c *Config) String() string {
+	b, _ := json.Marshal(c.Effective())
+	return string(b)
+}
+
+type field struct {
+	index  int
+	env    string
+	def    string
+	secret bool
+}
+
+// fields - private function, the settings declared on Config
+func fields() []field {
+	t := reflect.TypeOf(Config{})
+	list := make([]field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Tag.Get("env") == "" {
+			continue
+		}
+		list = append(list, field{index: i, env: sf.Tag.Get("env"), def: sf.Tag.Get("default"), secret: sf.Tag.Get("secret") == "true"})
+	}
+	return list
+}
+
+// Default - the default value of the setting (empty when none)
+func Default(name string) string {
+	return defaults()[name]
+}
+
+// Names - the envar names of every setting (sorted)
+func Names() []string {
+	var names []string
+	for _, f := range fields() {
+		names = append(names, f.env)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func defaults() map[string]string {
+	values := map[string]string{}
+	for _, f := range fields() {
+		values[f.env] = f.def
+	}
+	return values
+}
+
+func overlayEnv(values map[string]string) {
+	for _, f := range fields() {
+		if v, ok := os.LookupEnv(f.env); ok && v != "" {
+			values[f.env] = v
+		}
+	}
+}
+
+// overlayFile - json or yaml (by extension), unknown names are rejected to catch typos
+func overlayFile(values map[string]string, name string) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	cfg := &file{}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	default:
+		err = json.Unmarshal(data, cfg)
+	}
+	if err != nil {
+		return fmt.Errorf("config %s %v", name, err)
+	}
+	for k, v := range cfg.Env {
+		if _, ok := values[k]; !ok {
+			return fmt.Errorf("config %s unknown setting %s", name, k)
+		}
+		values[k] = fmt.Sprint(v)
+	}
+	return nil
+}
+
+// build - converts the layered values to the typed config, reporting every invalid value
+func build(values map[string]string) (*Config, error) {
+	cfg := &Config{raw: values}
+	rv := reflect.ValueOf(cfg).Elem()
+	var errs []error
+	for _, f := range fields() {
+		v := values[f.env]
+		if v == "" {
+			continue
+		}
+		fv := rv.Field(f.index)
+		switch fv.Kind() {
+		case reflect.String:
+			fv.SetString(v)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be a boolean (got %q)", f.env, v))
+				continue
+			}
+			fv.SetBool(b)
+		case reflect.Int64:
+			if fv.Type() != reflect.TypeOf(time.Duration(0)) {
+				n, err := strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s must be an integer (got %q)", f.env, v))
+					continue
+				}
+				fv.SetInt(n)
+				continue
+			}
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be a duration (got %q)", f.env, v))
+				continue
+			}
+			fv.SetInt(int64(d))
+		case reflect.Int:
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be an integer (got %q)", f.env, v))
+				continue
+			}
+			fv.SetInt(n)
+		}
+	}
+	return cfg, errors.Join(errs...)
+}
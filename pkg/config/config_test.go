@@ -0,0 +1,60 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+
+	t.Run("Load : should pass (layered)", func(t *testing.T) {
+		os.Setenv("TOPIC", "env-topic")
+		defer os.Unsetenv("TOPIC")
+		cfg, err := Load([]string{"--config", "../../config.json,../../tests/config.yaml", "--log-level", "debug"})
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned error %v", "Load", err))
+		}
+		// config.json < config.yaml < envars < flags, defaults for the rest
+		if cfg.Name != "golang-redis-publisher" || cfg.ServerPort != "9100" || cfg.Topic != "env-topic" || cfg.LogLevel != "debug" || !cfg.Envelope || cfg.DeadLetterKey != "deadletter" {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect config - got (%s)", "Load", cfg))
+		}
+	})
+
+	t.Run("Load : should fail (unknown setting)", func(t *testing.T) {
+		f, _ := os.CreateTemp(t.TempDir(), "config*.json")
+		f.WriteString(`{ "Env": { "SERVER_PRT": "9000" } }`)
+		f.Close()
+		if _, err := Load([]string{"--config", f.Name()}); err == nil || !strings.Contains(err.Error(), "SERVER_PRT") {
+			t.Errorf(fmt.Sprintf("Function %s should fail for an unknown setting - got (%v)", "Load", err))
+		}
+	})
+
+	t.Run("Load : should fail (every invalid value reported)", func(t *testing.T) {
+		_, err := Load([]string{"--config", "", "--max-body-size", "big", "--envelope", "maybe"})
+		if err == nil || !strings.Contains(err.Error(), "MAX_BODY_SIZE") || !strings.Contains(err.Error(), "ENVELOPE") {
+			t.Errorf(fmt.Sprintf("Function %s should report both errors - got (%v)", "Load", err))
+		}
+	})
+
+	t.Run("String : should pass (secrets masked)", func(t *testing.T) {
+		cfg, _ := Load([]string{"--config", "../../tests/config.yaml"})
+		if s := cfg.String(); strings.Contains(s, "not-so-secret") || !strings.Contains(s, `"JWT_SECRETKEY":"****"`) {
+			t.Errorf(fmt.Sprintf("Function %s did not mask the secret - got (%s)", "String", s))
+		}
+	})
+
+	t.Run("Get : should pass (environment until set)", func(t *testing.T) {
+		os.Setenv("TOPIC", "from-env")
+		defer os.Unsetenv("TOPIC")
+		if Get().Topic != "from-env" {
+			t.Errorf(fmt.Sprintf("Function %s returned - got (%s)", "Get", Get().Topic))
+		}
+		Set(&Config{Topic: "set"})
+		defer Set(nil)
+		if Get().Topic != "set" {
+			t.Errorf(fmt.Sprintf("Function %s returned - got (%s)", "Get", Get().Topic))
+		}
+	})
+}
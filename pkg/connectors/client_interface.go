@@ -2,8 +2,11 @@ package connectors
 
 import (
 	"net/http"
+	"time"
 
 	"context"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
 )
 
 // Client Interface - used as a receiver and can be overridden for testing
@@ -12,6 +15,22 @@ type Clients interface {
 	Info(string, ...interface{})
 	Debug(string, ...interface{})
 	Trace(string, ...interface{})
+	WithContext(ctx context.Context) Clients
 	Publish(ctx context.Context, topic string, payload interface{}) error
 	Do(req *http.Request) (*http.Response, error)
+	PushDeadLetter(ctx context.Context, dl *schema.DeadLetter) error
+	ListDeadLetters(ctx context.Context) ([]*schema.DeadLetter, error)
+	GetDeadLetter(ctx context.Context, id string) (*schema.DeadLetter, error)
+	RemoveDeadLetter(ctx context.Context, id string) error
+	ListSchemaTopics(ctx context.Context) ([]string, error)
+	ListSchemaVersions(ctx context.Context, topic string) ([]*schema.SchemaVersion, error)
+	GetSchemaVersion(ctx context.Context, topic string, version int) (*schema.SchemaVersion, error)
+	PutSchemaVersion(ctx context.Context, sv *schema.SchemaVersion) error
+	IncrWindow(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error)
+	GetDynamicConfig(ctx context.Context) (*schema.DynamicConfig, error)
+	PutDynamicConfig(ctx context.Context, kind string, topic string, value string) (int64, error)
+	SubscribeDynamicConfig(ctx context.Context) (<-chan string, error)
+	ListChannels(ctx context.Context, pattern string, extra ...string) (map[string]int64, error)
+	CountPatterns(ctx context.Context) (int64, error)
+	Subscribe(ctx context.Context, topic string, pattern bool) (<-chan *schema.PubSubMessage, error)
 }
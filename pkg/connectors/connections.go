@@ -3,9 +3,13 @@ package connectors
 import (
 	"context"
 	"crypto/tls"
-	"fmt"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/metrics"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/tracing"
 	"github.com/microlib/simple"
 	"github.com/redis/go-redis/v9"
 )
@@ -15,6 +19,8 @@ type Connectors struct {
 	Http        *http.Client
 	RedisClient *redis.Client
 	Logger      *simple.Logger
+	Redactor    *Redactor
+	mu          sync.Mutex
 }
 
 func NewClientConnections(logger *simple.Logger) Clients {
@@ -23,26 +29,39 @@ func NewClientConnections(logger *simple.Logger) Clients {
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
 	httpClient := &http.Client{Transport: tr}
+	cfg := config.Get()
 	redis := redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
+		Addr:     cfg.RedisAddr,
+		Password: cfg.RedisPassword,
 	})
-	return &Connectors{Http: httpClient, Logger: logger, RedisClient: redis}
+	if err := metrics.RegisterPool(redis.PoolStats); err != nil {
+		logger.Error("redis pool metrics " + err.Error())
+	}
+	return &Connectors{Http: httpClient, Logger: logger, RedisClient: redis, Redactor: newConfigRedactor(logger)}
 }
 
 func (c *Connectors) Error(msg string, val ...interface{}) {
-	c.Logger.Error(fmt.Sprintf(msg, val...))
+	c.logEntry(simple.ERROR, nil, msg, val...)
 }
 
 func (c *Connectors) Info(msg string, val ...interface{}) {
-	c.Logger.Info(fmt.Sprintf(msg, val...))
+	c.logEntry(simple.INFO, nil, msg, val...)
 }
 
 func (c *Connectors) Debug(msg string, val ...interface{}) {
-	c.Logger.Debug(fmt.Sprintf(msg, val...))
+	c.logEntry(simple.DEBUG, nil, msg, val...)
 }
 
 func (c *Connectors) Trace(msg string, val ...interface{}) {
-	c.Logger.Trace(fmt.Sprintf(msg, val...))
+	c.logEntry(simple.TRACE, nil, msg, val...)
+}
+
+func (c *Connectors) WithContext(ctx context.Context) Clients {
+	return withContext(c, c, ctx)
+}
+
+func (c *Connectors) logEntry(level string, fields map[string]string, msg string, val ...interface{}) {
+	writeLog(c.Logger, c.Redactor, level, fields, msg, val...)
 }
 
 func (c *Connectors) Meta(info string) string {
@@ -54,5 +73,13 @@ func (c *Connectors) Do(req *http.Request) (*http.Response, error) {
 }
 
 func (c *Connectors) Publish(ctx context.Context, topic string, payload interface{}) error {
-	return c.RedisClient.Publish(ctx, topic, payload).Err()
+	ctx, span := tracing.StartProducer(ctx, "redis", topic)
+	start := time.Now()
+	receivers, err := c.RedisClient.Publish(ctx, topic, payload).Result()
+	tracing.End(span, err)
+	metrics.RedisDuration.WithLabelValues("publish", metrics.Outcome(err)).Observe(time.Since(start).Seconds())
+	if err == nil {
+		metrics.Receivers.WithLabelValues(metrics.Topic(topic)).Observe(float64(receivers))
+	}
+	return err
 }
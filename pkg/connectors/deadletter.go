@@ -0,0 +1,200 @@
+package connectors
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+)
+
+const (
+	DEADLETTERREDIS string = "redis"
+	DEADLETTERFILE  string = "file"
+)
+
+// ErrDeadLetterNotFound - returned when a dead letter id is not in the store
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// PushDeadLetter - stores the failed payload in the configured dead letter destination
+// (DEADLETTER envar set to redis or file), a no-op when no destination is configured
+// the store keeps the newest DEADLETTER_MAX entries
+func (c *Connectors) PushDeadLetter(ctx context.Context, dl *schema.DeadLetter) error {
+	b, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	max := config.Get().DeadLetterMax
+	switch config.Get().DeadLetter {
+	case DEADLETTERREDIS:
+		pipe := c.RedisClient.TxPipeline()
+		pipe.LPush(ctx, deadLetterKey(), b)
+		if max > 0 {
+			pipe.LTrim(ctx, deadLetterKey(), 0, int64(max-1))
+		}
+		_, err := pipe.Exec(ctx)
+		return err
+	case DEADLETTERFILE:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		raw, err := readDeadLetterFile()
+		if err != nil {
+			return err
+		}
+		raw = append(raw, string(b))
+		if max > 0 && len(raw) > max {
+			raw = raw[len(raw)-max:]
+		}
+		return writeDeadLetterFile(raw)
+	}
+	return nil
+}
+
+// ListDeadLetters - returns all dead letters currently held in the store
+func (c *Connectors) ListDeadLetters(ctx context.Context) ([]*schema.DeadLetter, error) {
+	raw, err := c.rawDeadLetters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dls := []*schema.DeadLetter{}
+	for _, s := range raw {
+		dl := &schema.DeadLetter{}
+		if err := json.Unmarshal([]byte(s), dl); err != nil {
+			c.Error("ListDeadLetters skipping corrupt entry %v", err)
+			continue
+		}
+		dls = append(dls, dl)
+	}
+	return dls, nil
+}
+
+// GetDeadLetter - looks up a single dead letter by id
+func (c *Connectors) GetDeadLetter(ctx context.Context, id string) (*schema.DeadLetter, error) {
+	dls, err := c.ListDeadLetters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, dl := range dls {
+		if dl.ID == id {
+			return dl, nil
+		}
+	}
+	return nil, ErrDeadLetterNotFound
+}
+
+// RemoveDeadLetter - deletes a dead letter from the store (i.e. after a successful re-drive)
+// the file store is read, filtered and rewritten under one lock so concurrent pushes are not lost
+func (c *Connectors) RemoveDeadLetter(ctx context.Context, id string) error {
+	switch config.Get().DeadLetter {
+	case DEADLETTERREDIS:
+		raw, err := c.RedisClient.LRange(ctx, deadLetterKey(), 0, -1).Result()
+		if err != nil {
+			return err
+		}
+		found, _ := removeDeadLetter(raw, id)
+		if found == "" {
+			return ErrDeadLetterNotFound
+		}
+		return c.RedisClient.LRem(ctx, deadLetterKey(), 1, found).Err()
+	case DEADLETTERFILE:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		raw, err := readDeadLetterFile()
+		if err != nil {
+			return err
+		}
+		found, keep := removeDeadLetter(raw, id)
+		if found == "" {
+			return ErrDeadLetterNotFound
+		}
+		return writeDeadLetterFile(keep)
+	}
+	return ErrDeadLetterNotFound
+}
+
+// rawDeadLetters - private function, reads the serialized entries from the store
+func (c *Connectors) rawDeadLetters(ctx context.Context) ([]string, error) {
+	switch config.Get().DeadLetter {
+	case DEADLETTERREDIS:
+		return c.RedisClient.LRange(ctx, deadLetterKey(), 0, -1).Result()
+	case DEADLETTERFILE:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return readDeadLetterFile()
+	}
+	return []string{}, nil
+}
+
+// removeDeadLetter - splits the entries into the one with id (empty when not found) and the others
+func removeDeadLetter(raw []string, id string) (string, []string) {
+	var keep []string
+	found := ""
+	for _, s := range raw {
+		dl := &schema.DeadLetter{}
+		if found == "" && json.Unmarshal([]byte(s), dl) == nil && dl.ID == id {
+			found = s
+			continue
+		}
+		keep = append(keep, s)
+	}
+	return found, keep
+}
+
+// readDeadLetterFile - the entries of the json lines file, the caller holds c.mu
+func readDeadLetterFile() ([]string, error) {
+	f, err := os.Open(deadLetterFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	raw := []string{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			raw = append(raw, scanner.Text())
+		}
+	}
+	return raw, scanner.Err()
+}
+
+// writeDeadLetterFile - replaces the json lines file through a temporary file and a rename, the caller holds c.mu
+func writeDeadLetterFile(raw []string) error {
+	file := deadLetterFile()
+	var buf bytes.Buffer
+	for _, s := range raw {
+		buf.WriteString(s + "\n")
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+func deadLetterKey() string {
+	return config.Get().DeadLetterKey
+}
+
+func deadLetterFile() string {
+	return config.Get().DeadLetterFile
+}
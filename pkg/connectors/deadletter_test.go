@@ -0,0 +1,66 @@
+package connectors
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+	"github.com/microlib/simple"
+)
+
+func TestDeadLetterFile(t *testing.T) {
+
+	logger := &simple.Logger{Level: "info"}
+	os.Setenv("DEADLETTER", DEADLETTERFILE)
+	os.Setenv("DEADLETTER_FILE", filepath.Join(t.TempDir(), "deadletter.jsonl"))
+	os.Setenv("DEADLETTER_MAX", "3")
+	defer func() {
+		os.Unsetenv("DEADLETTER")
+		os.Unsetenv("DEADLETTER_FILE")
+		os.Unsetenv("DEADLETTER_MAX")
+		config.Set(nil)
+	}()
+	config.Set(nil)
+	conn := &Connectors{Logger: logger}
+	ctx := context.Background()
+
+	t.Run("PushDeadLetter : should pass (oldest entries dropped)", func(t *testing.T) {
+		for i := 1; i <= 4; i++ {
+			if err := conn.PushDeadLetter(ctx, &schema.DeadLetter{ID: strconv.Itoa(i)}); err != nil {
+				t.Fatalf(fmt.Sprintf("Function %s returned error %v", "PushDeadLetter", err))
+			}
+		}
+		dls, err := conn.ListDeadLetters(ctx)
+		if err != nil || len(dls) != 3 || dls[0].ID != "2" || dls[2].ID != "4" {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect entries - got (%d %v)", "ListDeadLetters", len(dls), err))
+		}
+	})
+
+	t.Run("RemoveDeadLetter : should pass (concurrent pushes kept)", func(t *testing.T) {
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			conn.PushDeadLetter(ctx, &schema.DeadLetter{ID: "5"})
+		}()
+		go func() {
+			defer wg.Done()
+			if err := conn.RemoveDeadLetter(ctx, "3"); err != nil {
+				t.Errorf(fmt.Sprintf("Function %s returned error %v", "RemoveDeadLetter", err))
+			}
+		}()
+		wg.Wait()
+		if _, err := conn.GetDeadLetter(ctx, "5"); err != nil {
+			t.Errorf(fmt.Sprintf("Function %s lost the concurrent push %v", "RemoveDeadLetter", err))
+		}
+		if err := conn.RemoveDeadLetter(ctx, "3"); err != ErrDeadLetterNotFound {
+			t.Errorf(fmt.Sprintf("Function %s should fail - got (%v)", "RemoveDeadLetter", err))
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package connectors
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	DYNAMICREDIS     string = "redis"
+	DYNAMICTEMPLATES string = "templates"
+	DYNAMICLIMITS    string = "ratelimits"
+	DYNAMICROUTES    string = "routes"
+	DYNAMICPOLICIES  string = "policies"
+)
+
+// ErrDynamicConfigDisabled - returned when DYNAMIC_CONFIG is not set
+var ErrDynamicConfigDisabled = errors.New("dynamic config is not configured (DYNAMIC_CONFIG)")
+
+// GetDynamicConfig - reads the version and every per topic setting in one transaction
+// keys are <DYNAMIC_CONFIG_KEY>:version and the hashes <DYNAMIC_CONFIG_KEY>:templates, :ratelimits, :routes and :policies
+func (c *Connectors) GetDynamicConfig(ctx context.Context) (*schema.DynamicConfig, error) {
+	if config.Get().DynamicConfig != DYNAMICREDIS {
+		return nil, ErrDynamicConfigDisabled
+	}
+	key := dynamicKey()
+	pipe := c.RedisClient.TxPipeline()
+	version := pipe.Get(ctx, key+":version")
+	templates := pipe.HGetAll(ctx, key+":"+DYNAMICTEMPLATES)
+	limits := pipe.HGetAll(ctx, key+":"+DYNAMICLIMITS)
+	routes := pipe.HGetAll(ctx, key+":"+DYNAMICROUTES)
+	policies := pipe.HGetAll(ctx, key+":"+DYNAMICPOLICIES)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	dc := &schema.DynamicConfig{Templates: templates.Val(), RateLimits: limits.Val(), Routes: routes.Val(), Policies: policies.Val()}
+	if v := version.Val(); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		dc.Version = n
+	}
+	return dc, nil
+}
+
+// PutDynamicConfig - sets (or with an empty value deletes) a per topic setting, bumps the version
+// and notifies every replica on <DYNAMIC_CONFIG_KEY>:changes, returns the new version
+func (c *Connectors) PutDynamicConfig(ctx context.Context, kind string, topic string, value string) (int64, error) {
+	if config.Get().DynamicConfig != DYNAMICREDIS {
+		return 0, ErrDynamicConfigDisabled
+	}
+	key := dynamicKey()
+	pipe := c.RedisClient.TxPipeline()
+	if value == "" {
+		pipe.HDel(ctx, key+":"+kind, topic)
+	} else {
+		pipe.HSet(ctx, key+":"+kind, topic, value)
+	}
+	version := pipe.Incr(ctx, key+":version")
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	// a lost notification is picked up by the periodic version check
+	if err := c.RedisClient.Publish(ctx, key+":changes", version.Val()).Err(); err != nil {
+		c.Error("PutDynamicConfig notify %v", err)
+	}
+	return version.Val(), nil
+}
+
+// SubscribeDynamicConfig - delivers the version of every change until ctx is done
+// the subscription is re-established by the client after a connection failure
+func (c *Connectors) SubscribeDynamicConfig(ctx context.Context) (<-chan string, error) {
+	if config.Get().DynamicConfig != DYNAMICREDIS {
+		return nil, ErrDynamicConfigDisabled
+	}
+	sub := c.RedisClient.Subscribe(ctx, dynamicKey()+":changes")
+	changes := make(chan string)
+	go func() {
+		defer sub.Close()
+		defer close(changes)
+		msgs := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+				select {
+				case changes <- msg.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
+
+func dynamicKey() string {
+	return config.Get().DynamicConfigKey
+}
@@ -0,0 +1,118 @@
+package connectors
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+	"github.com/microlib/simple"
+)
+
+const (
+	REQUESTID string = "request_id"
+)
+
+type contextKey string
+
+var (
+	requestIdKey = contextKey(REQUESTID)
+	logMutex     sync.Mutex
+	logOutput    io.Writer = os.Stderr
+	levels                 = map[string]int{simple.ERROR: 0, simple.WARN: 1, simple.INFO: 2, simple.DEBUG: 3, simple.TRACE: 4}
+)
+
+// ContextWithRequestId - stores the request id for the request scoped clients (see Clients.WithContext)
+func ContextWithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey, id)
+}
+
+// RequestId - the request id held in the context (empty when not set)
+func RequestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey).(string)
+	return id
+}
+
+// entryLogger - implemented by the clients, writes a single log line with extra fields
+type entryLogger interface {
+	logEntry(level string, fields map[string]string, msg string, val ...interface{})
+}
+
+// requestClients - request scoped view of the clients, every log line carries the request id
+type requestClients struct {
+	Clients
+	base   entryLogger
+	fields map[string]string
+}
+
+// withContext - private function, shared by the WithContext implementations
+func withContext(c Clients, base entryLogger, ctx context.Context) Clients {
+	id := RequestId(ctx)
+	if id == "" {
+		return c
+	}
+	return &requestClients{Clients: c, base: base, fields: map[string]string{REQUESTID: id}}
+}
+
+func (c *requestClients) Error(msg string, val ...interface{}) {
+	c.base.logEntry(simple.ERROR, c.fields, msg, val...)
+}
+
+func (c *requestClients) Info(msg string, val ...interface{}) {
+	c.base.logEntry(simple.INFO, c.fields, msg, val...)
+}
+
+func (c *requestClients) Debug(msg string, val ...interface{}) {
+	c.base.logEntry(simple.DEBUG, c.fields, msg, val...)
+}
+
+func (c *requestClients) Trace(msg string, val ...interface{}) {
+	c.base.logEntry(simple.TRACE, c.fields, msg, val...)
+}
+
+func (c *requestClients) WithContext(ctx context.Context) Clients {
+	return withContext(c.Clients, c.base, ctx)
+}
+
+// writeLog - LOG_FORMAT=json writes one json object per line, otherwise the simple (text) logger is used
+func writeLog(logger *simple.Logger, r *Redactor, level string, fields map[string]string, msg string, val ...interface{}) {
+	if levels[level] > levels[logger.Level] && level != simple.ERROR {
+		return
+	}
+	text := r.Sprintf(msg, val...)
+	if cfg := config.Get(); cfg.LogFormat == "json" {
+		entry := map[string]interface{}{
+			"time":    time.Now().UTC().Format(time.RFC3339Nano),
+			"level":   level,
+			"msg":     text,
+			"service": cfg.Name,
+			"version": cfg.Version,
+		}
+		for k, v := range fields {
+			entry[k] = v
+		}
+		b, _ := json.Marshal(entry)
+		logMutex.Lock()
+		defer logMutex.Unlock()
+		_, _ = logOutput.Write(append(b, '\n'))
+		return
+	}
+	if id := fields[REQUESTID]; id != "" {
+		text = "[" + id + "] " + text
+	}
+	switch level {
+	case simple.ERROR:
+		logger.Error(text)
+	case simple.WARN:
+		logger.Warn(text)
+	case simple.INFO:
+		logger.Info(text)
+	case simple.DEBUG:
+		logger.Debug(text)
+	case simple.TRACE:
+		logger.Trace(text)
+	}
+}
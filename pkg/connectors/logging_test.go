@@ -0,0 +1,49 @@
+package connectors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/microlib/simple"
+)
+
+func TestLogging(t *testing.T) {
+
+	logger := &simple.Logger{Level: "info"}
+	conn := NewTestConnectors("", 200, logger)
+	var out bytes.Buffer
+	logOutput = &out
+	defer func() { logOutput = os.Stderr }()
+	os.Setenv("LOG_FORMAT", "json")
+	defer os.Unsetenv("LOG_FORMAT")
+
+	t.Run("WithContext : should pass (request id in json log)", func(t *testing.T) {
+		out.Reset()
+		conn.WithContext(ContextWithRequestId(context.Background(), "abc-123")).Info("publish %s", `{"email":"abc@xyz.com"}`)
+		entry := map[string]string{}
+		if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s wrote invalid json - got (%s)", "Info", out.String()))
+		}
+		if entry[REQUESTID] != "abc-123" || entry["level"] != simple.INFO || entry["msg"] != `publish {"email":"****"}` {
+			t.Errorf(fmt.Sprintf("Function %s wrote incorrect entry - got (%s)", "Info", out.String()))
+		}
+	})
+
+	t.Run("Debug : should pass (filtered by level)", func(t *testing.T) {
+		out.Reset()
+		conn.Debug("not logged")
+		if out.Len() != 0 {
+			t.Errorf(fmt.Sprintf("Function %s should be filtered - got (%s)", "Debug", out.String()))
+		}
+	})
+
+	t.Run("WithContext : should pass (no request id)", func(t *testing.T) {
+		if conn.WithContext(context.Background()) != conn {
+			t.Errorf(fmt.Sprintf("Function %s should return the clients unchanged", "WithContext"))
+		}
+	})
+}
@@ -8,31 +8,54 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"sort"
+	"time"
 
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/topics"
 	"github.com/microlib/simple"
 )
 
 // Mock all connections
 type MockConnectors struct {
-	Http   *http.Client
-	Logger *simple.Logger
-	Flag   string
+	Http        *http.Client
+	Logger      *simple.Logger
+	Redactor    *Redactor
+	Flag        string
+	DeadLetters []*schema.DeadLetter
+	Published   []interface{}
+	Channels    []string
+	Schemas     map[string][]*schema.SchemaVersion
+	Counters    map[string]int64
+	Dynamic     *schema.DynamicConfig
+	Changes     chan string
+	Subscribers map[string]int64
+	Stream      chan *schema.PubSubMessage
+	Subscribed  []string
 }
 
 func (c *MockConnectors) Error(msg string, val ...interface{}) {
-	c.Logger.Error(fmt.Sprintf(msg, val...))
+	c.logEntry(simple.ERROR, nil, msg, val...)
 }
 
 func (c *MockConnectors) Info(msg string, val ...interface{}) {
-	c.Logger.Info(fmt.Sprintf(msg, val...))
+	c.logEntry(simple.INFO, nil, msg, val...)
 }
 
 func (c *MockConnectors) Debug(msg string, val ...interface{}) {
-	c.Logger.Debug(fmt.Sprintf(msg, val...))
+	c.logEntry(simple.DEBUG, nil, msg, val...)
 }
 
 func (c *MockConnectors) Trace(msg string, val ...interface{}) {
-	c.Logger.Trace(fmt.Sprintf(msg, val...))
+	c.logEntry(simple.TRACE, nil, msg, val...)
+}
+
+func (c *MockConnectors) WithContext(ctx context.Context) Clients {
+	return withContext(c, c, ctx)
+}
+
+func (c *MockConnectors) logEntry(level string, fields map[string]string, msg string, val ...interface{}) {
+	writeLog(c.Logger, c.Redactor, level, fields, msg, val...)
 }
 
 func (c *MockConnectors) Meta(flag string) string {
@@ -48,9 +71,175 @@ func (c *MockConnectors) Do(req *http.Request) (*http.Response, error) {
 }
 
 func (c *MockConnectors) Publish(ctx context.Context, topic string, payload interface{}) error {
+	if c.Flag == "true" {
+		return errors.New("forced publish error")
+	}
+	c.Published = append(c.Published, payload)
+	c.Channels = append(c.Channels, topic)
+	return nil
+}
+
+func (c *MockConnectors) PushDeadLetter(ctx context.Context, dl *schema.DeadLetter) error {
+	c.DeadLetters = append(c.DeadLetters, dl)
 	return nil
 }
 
+func (c *MockConnectors) ListDeadLetters(ctx context.Context) ([]*schema.DeadLetter, error) {
+	return c.DeadLetters, nil
+}
+
+func (c *MockConnectors) GetDeadLetter(ctx context.Context, id string) (*schema.DeadLetter, error) {
+	for _, dl := range c.DeadLetters {
+		if dl.ID == id {
+			return dl, nil
+		}
+	}
+	return nil, ErrDeadLetterNotFound
+}
+
+func (c *MockConnectors) RemoveDeadLetter(ctx context.Context, id string) error {
+	for i, dl := range c.DeadLetters {
+		if dl.ID == id {
+			c.DeadLetters = append(c.DeadLetters[:i], c.DeadLetters[i+1:]...)
+			return nil
+		}
+	}
+	return ErrDeadLetterNotFound
+}
+
+func (c *MockConnectors) ListSchemaTopics(ctx context.Context) ([]string, error) {
+	topics := []string{}
+	for topic := range c.Schemas {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+func (c *MockConnectors) ListSchemaVersions(ctx context.Context, topic string) ([]*schema.SchemaVersion, error) {
+	return append([]*schema.SchemaVersion{}, c.Schemas[topic]...), nil
+}
+
+func (c *MockConnectors) GetSchemaVersion(ctx context.Context, topic string, version int) (*schema.SchemaVersion, error) {
+	versions := c.Schemas[topic]
+	if len(versions) == 0 {
+		return nil, ErrSchemaNotFound
+	}
+	if version == 0 {
+		return versions[len(versions)-1], nil
+	}
+	for _, sv := range versions {
+		if sv.Version == version {
+			return sv, nil
+		}
+	}
+	return nil, ErrSchemaNotFound
+}
+
+func (c *MockConnectors) PutSchemaVersion(ctx context.Context, sv *schema.SchemaVersion) error {
+	if c.Schemas == nil {
+		c.Schemas = map[string][]*schema.SchemaVersion{}
+	}
+	c.Schemas[sv.Topic] = append(c.Schemas[sv.Topic], sv)
+	return nil
+}
+
+// IncrWindow - the mock window never expires
+func (c *MockConnectors) IncrWindow(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+	if c.Counters == nil {
+		c.Counters = map[string]int64{}
+	}
+	c.Counters[key]++
+	return c.Counters[key], window, nil
+}
+
+func (c *MockConnectors) GetDynamicConfig(ctx context.Context) (*schema.DynamicConfig, error) {
+	if c.Flag == "true" {
+		return nil, errors.New("forced dynamic config error")
+	}
+	dc := newMockDynamic()
+	if c.Dynamic != nil {
+		dc.Version = c.Dynamic.Version
+		for _, kind := range []string{DYNAMICTEMPLATES, DYNAMICLIMITS, DYNAMICROUTES, DYNAMICPOLICIES} {
+			for k, v := range mockDynamicKind(c.Dynamic, kind) {
+				mockDynamicKind(dc, kind)[k] = v
+			}
+		}
+	}
+	return dc, nil
+}
+
+// PutDynamicConfig - the mock does not notify, tests send on Changes
+func (c *MockConnectors) PutDynamicConfig(ctx context.Context, kind string, topic string, value string) (int64, error) {
+	if c.Dynamic == nil {
+		c.Dynamic = newMockDynamic()
+	}
+	m := mockDynamicKind(c.Dynamic, kind)
+	if value == "" {
+		delete(m, topic)
+	} else {
+		m[topic] = value
+	}
+	c.Dynamic.Version++
+	return c.Dynamic.Version, nil
+}
+
+func newMockDynamic() *schema.DynamicConfig {
+	return &schema.DynamicConfig{Templates: map[string]string{}, RateLimits: map[string]string{}, Routes: map[string]string{}, Policies: map[string]string{}}
+}
+
+func mockDynamicKind(dc *schema.DynamicConfig, kind string) map[string]string {
+	switch kind {
+	case DYNAMICLIMITS:
+		return dc.RateLimits
+	case DYNAMICROUTES:
+		return dc.Routes
+	case DYNAMICPOLICIES:
+		return dc.Policies
+	}
+	return dc.Templates
+}
+
+func (c *MockConnectors) SubscribeDynamicConfig(ctx context.Context) (<-chan string, error) {
+	if c.Changes == nil {
+		c.Changes = make(chan string)
+	}
+	return c.Changes, nil
+}
+
+// ListChannels - channels with a Subscribers entry are active
+func (c *MockConnectors) ListChannels(ctx context.Context, pattern string, extra ...string) (map[string]int64, error) {
+	if c.Flag == "true" {
+		return nil, errors.New("forced pubsub error")
+	}
+	counts := map[string]int64{}
+	for channel, n := range c.Subscribers {
+		if topics.Match(pattern, channel) && n > 0 {
+			counts[channel] = n
+		}
+	}
+	for _, channel := range extra {
+		counts[channel] = c.Subscribers[channel]
+	}
+	return counts, nil
+}
+
+func (c *MockConnectors) CountPatterns(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+// Subscribe - delivers what the test sends on Stream, Subscribed records the topics
+func (c *MockConnectors) Subscribe(ctx context.Context, topic string, pattern bool) (<-chan *schema.PubSubMessage, error) {
+	if c.Flag == "true" {
+		return nil, errors.New("forced subscribe error")
+	}
+	if c.Stream == nil {
+		c.Stream = make(chan *schema.PubSubMessage)
+	}
+	c.Subscribed = append(c.Subscribed, topic)
+	return c.Stream, nil
+}
+
 // RoundTripFunc .
 type RoundTripFunc func(req *http.Request) *http.Response
 
@@ -92,6 +281,6 @@ func NewTestConnectors(file string, code int, logger *simple.Logger) Clients {
 		}
 	})
 
-	conns := &MockConnectors{Http: httpclient, Logger: logger, Flag: "false"}
+	conns := &MockConnectors{Http: httpclient, Logger: logger, Redactor: newConfigRedactor(logger), Flag: "false"}
 	return conns
 }
@@ -0,0 +1,30 @@
+package connectors
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	RATELIMITPREFIX string = "ratelimit:"
+)
+
+// IncrWindow - increments the fixed window counter for key, the window starts with the first increment
+// returns the count so far and the time left in the window (shared by all replicas)
+func (c *Connectors) IncrWindow(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+	k := RATELIMITPREFIX + key
+	pipe := c.RedisClient.TxPipeline()
+	incr := pipe.Incr(ctx, k)
+	ttl := pipe.PTTL(ctx, k)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, 0, err
+	}
+	// a key without expiry is a new window (or one left behind by a failed expire)
+	if ttl.Val() < 0 {
+		if err := c.RedisClient.PExpire(ctx, k, window).Err(); err != nil {
+			return 0, 0, err
+		}
+		return incr.Val(), window, nil
+	}
+	return incr.Val(), ttl.Val(), nil
+}
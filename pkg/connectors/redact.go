@@ -0,0 +1,191 @@
+package connectors
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+	"github.com/microlib/simple"
+)
+
+const (
+	MASK            string = "****"
+	REDACTFIELDS    string = "email,mobile,address,jwttoken,firstName,lastName"
+	REDACTDETECTORS string = "email,phone,jwt"
+)
+
+// detectors - regex detectors selectable via REDACT_DETECTORS
+var detectors = map[string]*regexp.Regexp{
+	"email": regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+	"phone": regexp.MustCompile(`\+\d[\d\s().\-]{7,}\d|\(?\b\d{2,4}\)?[\s.\-]\d{3,4}[\s.\-]\d{3,4}\b`),
+	"jwt":   regexp.MustCompile(`\beyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]*`),
+}
+
+// Redactor - masks PII in log messages before they reach the logger
+// field names (no dot) are masked at any depth, dotted paths from the root of the json document
+type Redactor struct {
+	fields    map[string]bool
+	paths     [][]string
+	detectors []*regexp.Regexp
+}
+
+// newConfigRedactor - REDACT=false disables masking, REDACT_FIELDS and REDACT_DETECTORS override the defaults
+func newConfigRedactor(logger *simple.Logger) *Redactor {
+	cfg := config.Get()
+	if !cfg.Redact {
+		return nil
+	}
+	fields, names := cfg.RedactFields, cfg.RedactDetectors
+	if fields == "" {
+		fields = REDACTFIELDS
+	}
+	if names == "" {
+		names = REDACTDETECTORS
+	}
+	r, err := NewRedactor(fields, names)
+	if err != nil {
+		logger.Error(fmt.Sprintf("%v (using defaults)", err))
+		r, _ = NewRedactor(fields, REDACTDETECTORS)
+	}
+	return r
+}
+
+// NewRedactor - fields and names are comma separated lists (REDACT_FIELDS, REDACT_DETECTORS)
+func NewRedactor(fields string, names string) (*Redactor, error) {
+	r := &Redactor{fields: map[string]bool{}}
+	for _, f := range splitList(fields) {
+		if strings.Contains(f, ".") {
+			r.paths = append(r.paths, strings.Split(f, "."))
+		} else {
+			r.fields[strings.ToLower(f)] = true
+		}
+	}
+	for _, n := range splitList(names) {
+		re, ok := detectors[n]
+		if !ok {
+			return nil, fmt.Errorf("unknown redact detector %q", n)
+		}
+		r.detectors = append(r.detectors, re)
+	}
+	return r, nil
+}
+
+// Sprintf - formats the message with json documents and structs field masked, then applies the detectors
+func (r *Redactor) Sprintf(msg string, val ...interface{}) string {
+	if r == nil {
+		return fmt.Sprintf(msg, val...)
+	}
+	masked := make([]interface{}, len(val))
+	for i, v := range val {
+		masked[i] = r.maskValue(v)
+	}
+	out := fmt.Sprintf(msg, masked...)
+	for _, re := range r.detectors {
+		out = re.ReplaceAllString(out, MASK)
+	}
+	return out
+}
+
+// maskValue - private function, json strings/bytes and structs are masked field by field
+func (r *Redactor) maskValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil, error, fmt.Stringer:
+		return v
+	case string:
+		if b, ok := r.maskJSON([]byte(t)); ok {
+			return string(b)
+		}
+		return v
+	case []byte:
+		if b, ok := r.maskJSON(t); ok {
+			return string(b)
+		}
+		return v
+	}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct && rv.Kind() != reflect.Map {
+		return v
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	if masked, ok := r.maskJSON(b); ok {
+		return string(masked)
+	}
+	return v
+}
+
+func (r *Redactor) maskJSON(data []byte) ([]byte, bool) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') || !json.Valid(trimmed) {
+		return nil, false
+	}
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, false
+	}
+	doc = r.walk(doc, nil)
+	b, err := json.Marshal(doc)
+	return b, err == nil
+}
+
+func (r *Redactor) walk(v interface{}, path []string) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			p := append(append([]string{}, path...), k)
+			if r.match(p) {
+				t[k] = MASK
+				continue
+			}
+			t[k] = r.walk(val, p)
+		}
+	case []interface{}:
+		for i, val := range t {
+			t[i] = r.walk(val, path)
+		}
+	}
+	return v
+}
+
+func (r *Redactor) match(path []string) bool {
+	if r.fields[strings.ToLower(path[len(path)-1])] {
+		return true
+	}
+	for _, p := range r.paths {
+		if len(p) != len(path) {
+			continue
+		}
+		matched := true
+		for i := range p {
+			if p[i] != "*" && !strings.EqualFold(p[i], path[i]) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, i := range strings.Split(s, ",") {
+		if i = strings.TrimSpace(i); i != "" {
+			list = append(list, i)
+		}
+	}
+	return list
+}
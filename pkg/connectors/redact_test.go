@@ -0,0 +1,55 @@
+package connectors
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+)
+
+func TestRedactor(t *testing.T) {
+
+	r, _ := NewRedactor("email,mobile,request.address", REDACTDETECTORS)
+
+	t.Run("Sprintf : should mask json fields", func(t *testing.T) {
+		got := r.Sprintf("payload %s", []byte(`{ "request":{"email":"abc@xyz.com", "address":"1 main st", "number":"1234567"}, "address":"kept"}`))
+		want := `payload {"address":"kept","request":{"address":"****","email":"****","number":"1234567"}}`
+		if got != want {
+			t.Errorf(fmt.Sprintf("Function %s returned - got (%s) wanted (%s)", "Sprintf", got, want))
+		}
+	})
+
+	t.Run("Sprintf : should mask structs", func(t *testing.T) {
+		got := r.Sprintf("schema %v", &schema.CustomerPayload{Email: "abc@xyz.com", Mobile: "0871234567", Number: "1"})
+		want := `schema {"email":"****","mobile":"****","number":"1"}`
+		if got != want {
+			t.Errorf(fmt.Sprintf("Function %s returned - got (%s) wanted (%s)", "Sprintf", got, want))
+		}
+	})
+
+	t.Run("Sprintf : should apply detectors", func(t *testing.T) {
+		got := r.Sprintf("error %v", errors.New("contact abc@xyz.com or +353 87 123 4567 token eyJhbGciOiJIUzI1NiJ9.eyJ1c2VyIjoiYSJ9.sig"))
+		want := "error contact **** or **** token ****"
+		if got != want {
+			t.Errorf(fmt.Sprintf("Function %s returned - got (%s) wanted (%s)", "Sprintf", got, want))
+		}
+		// customer numbers are not phone numbers
+		if got := r.Sprintf("number %s", "1234567890"); got != "number 1234567890" {
+			t.Errorf(fmt.Sprintf("Function %s returned - got (%s)", "Sprintf", got))
+		}
+	})
+
+	t.Run("Sprintf : should pass through (disabled)", func(t *testing.T) {
+		var disabled *Redactor
+		if got := disabled.Sprintf("email %s", "abc@xyz.com"); got != "email abc@xyz.com" {
+			t.Errorf(fmt.Sprintf("Function %s returned - got (%s)", "Sprintf", got))
+		}
+	})
+
+	t.Run("NewRedactor : should fail (unknown detector)", func(t *testing.T) {
+		if _, err := NewRedactor("", "creditcard"); err == nil {
+			t.Errorf(fmt.Sprintf("Function %s returned with no error", "NewRedactor"))
+		}
+	})
+}
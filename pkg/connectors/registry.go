@@ -0,0 +1,159 @@
+package connectors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+)
+
+const (
+	REGISTRYREDIS string = "redis"
+	REGISTRYFILE  string = "file"
+)
+
+// ErrSchemaNotFound - returned when a topic or version is not in the registry
+var ErrSchemaNotFound = errors.New("schema not found")
+
+// ListSchemaTopics - returns every topic that has at least one registered schema
+// the SCHEMA_REGISTRY envar selects redis or file storage, no registry returns an empty list
+func (c *Connectors) ListSchemaTopics(ctx context.Context) ([]string, error) {
+	var topics []string
+	var err error
+	switch config.Get().SchemaRegistry {
+	case REGISTRYREDIS:
+		topics, err = c.RedisClient.SMembers(ctx, registryKey()+":topics").Result()
+	case REGISTRYFILE:
+		var entries []os.DirEntry
+		entries, err = os.ReadDir(registryDir())
+		if errors.Is(err, os.ErrNotExist) {
+			return []string{}, nil
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				topics = append(topics, e.Name())
+			}
+		}
+	}
+	sort.Strings(topics)
+	if topics == nil {
+		topics = []string{}
+	}
+	return topics, err
+}
+
+// ListSchemaVersions - returns all versions registered for the topic (oldest first)
+func (c *Connectors) ListSchemaVersions(ctx context.Context, topic string) ([]*schema.SchemaVersion, error) {
+	var raw []string
+	switch config.Get().SchemaRegistry {
+	case REGISTRYREDIS:
+		m, err := c.RedisClient.HGetAll(ctx, registryKey()+":"+topic).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range m {
+			raw = append(raw, v)
+		}
+	case REGISTRYFILE:
+		if !validRegistryTopic(topic) {
+			return nil, errors.New("invalid topic name " + topic)
+		}
+		files, err := filepath.Glob(filepath.Join(registryDir(), topic, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				return nil, err
+			}
+			raw = append(raw, string(b))
+		}
+	}
+	versions := []*schema.SchemaVersion{}
+	for _, s := range raw {
+		sv := &schema.SchemaVersion{}
+		if err := json.Unmarshal([]byte(s), sv); err != nil {
+			return nil, err
+		}
+		versions = append(versions, sv)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	return versions, nil
+}
+
+// GetSchemaVersion - returns a single version, version 0 returns the latest
+func (c *Connectors) GetSchemaVersion(ctx context.Context, topic string, version int) (*schema.SchemaVersion, error) {
+	versions, err := c.ListSchemaVersions(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, ErrSchemaNotFound
+	}
+	if version == 0 {
+		return versions[len(versions)-1], nil
+	}
+	for _, sv := range versions {
+		if sv.Version == version {
+			return sv, nil
+		}
+	}
+	return nil, ErrSchemaNotFound
+}
+
+// PutSchemaVersion - stores a new schema version (an existing version is never overwritten)
+func (c *Connectors) PutSchemaVersion(ctx context.Context, sv *schema.SchemaVersion) error {
+	b, err := json.Marshal(sv)
+	if err != nil {
+		return err
+	}
+	version := strconv.Itoa(sv.Version)
+	switch config.Get().SchemaRegistry {
+	case REGISTRYREDIS:
+		ok, err := c.RedisClient.HSetNX(ctx, registryKey()+":"+sv.Topic, version, b).Result()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("schema version " + version + " already exists")
+		}
+		return c.RedisClient.SAdd(ctx, registryKey()+":topics", sv.Topic).Err()
+	case REGISTRYFILE:
+		if !validRegistryTopic(sv.Topic) {
+			return errors.New("invalid topic name " + sv.Topic)
+		}
+		dir := filepath.Join(registryDir(), sv.Topic)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(filepath.Join(dir, version+".json"), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = f.Write(b)
+		return err
+	}
+	return errors.New("schema registry is not configured (SCHEMA_REGISTRY)")
+}
+
+// validRegistryTopic - the topic is used as a directory name (and glob pattern) in the file registry
+func validRegistryTopic(topic string) bool {
+	return topic != "" && topic != "." && topic != ".." && !strings.ContainsAny(topic, `/\*?[`)
+}
+
+func registryKey() string {
+	return config.Get().SchemaRegistryKey
+}
+
+func registryDir() string {
+	return config.Get().SchemaRegistryDir
+}
@@ -0,0 +1,45 @@
+package connectors
+
+import (
+	"context"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+	"github.com/redis/go-redis/v9"
+)
+
+// Subscribe - streams the messages of topic (PSUBSCRIBE when pattern is set) until ctx is done,
+// then unsubscribes and closes the channel, a subscription redis does not confirm is returned as an error
+func (c *Connectors) Subscribe(ctx context.Context, topic string, pattern bool) (<-chan *schema.PubSubMessage, error) {
+	var sub *redis.PubSub
+	if pattern {
+		sub = c.RedisClient.PSubscribe(ctx, topic)
+	} else {
+		sub = c.RedisClient.Subscribe(ctx, topic)
+	}
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, err
+	}
+	messages := make(chan *schema.PubSubMessage)
+	go func() {
+		defer sub.Close()
+		defer close(messages)
+		msgs := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+				select {
+				case messages <- &schema.PubSubMessage{Channel: msg.Channel, Pattern: msg.Pattern, Payload: msg.Payload}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return messages, nil
+}
@@ -0,0 +1,20 @@
+package connectors
+
+import (
+	"context"
+)
+
+// ListChannels - the active channels matching pattern (PUBSUB CHANNELS) with their subscriber counts (PUBSUB NUMSUB)
+// extra channels are included in the counts even when they have no subscribers
+func (c *Connectors) ListChannels(ctx context.Context, pattern string, extra ...string) (map[string]int64, error) {
+	channels, err := c.RedisClient.PubSubChannels(ctx, pattern).Result()
+	if err != nil {
+		return nil, err
+	}
+	return c.RedisClient.PubSubNumSub(ctx, append(channels, extra...)...).Result()
+}
+
+// CountPatterns - the number of pattern subscriptions (PUBSUB NUMPAT), these are not counted per channel
+func (c *Connectors) CountPatterns(ctx context.Context) (int64, error) {
+	return c.RedisClient.PubSubNumPat(ctx).Result()
+}
@@ -0,0 +1,42 @@
+package docpath
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// Decode - numbers are kept as written (1234567, not 1.234567e+06)
+func Decode(r io.Reader, v interface{}) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// Lookup - the value at the dot separated path of a decoded json document
+func Lookup(doc interface{}, path string) (interface{}, bool) {
+	for _, key := range strings.Split(path, ".") {
+		m, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if doc, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// Set - sets the value at the dot separated path, creating (or replacing non object) parents
+func Set(doc map[string]interface{}, path string, value interface{}) {
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := doc[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			doc[key] = next
+		}
+		doc = next
+	}
+	doc[keys[len(keys)-1]] = value
+}
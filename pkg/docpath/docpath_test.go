@@ -0,0 +1,45 @@
+package docpath
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDocpath(t *testing.T) {
+
+	t.Run("Decode : should pass (numbers kept as written)", func(t *testing.T) {
+		var doc interface{}
+		err := Decode(strings.NewReader(`{"a":{"n":1234567}}`), &doc)
+		if err != nil {
+			t.Errorf(fmt.Sprintf("Decode returned an unexpected error %v", err))
+		}
+		v, _ := Lookup(doc, "a.n")
+		if _, ok := v.(json.Number); !ok || fmt.Sprint(v) != "1234567" {
+			t.Errorf(fmt.Sprintf("Decode returned incorrect number - got (%T %v) wanted (json.Number 1234567)", v, v))
+		}
+	})
+
+	t.Run("Lookup : should fail (missing or non object parent)", func(t *testing.T) {
+		doc := map[string]interface{}{"a": "x"}
+		if _, ok := Lookup(doc, "a.b"); ok {
+			t.Errorf(fmt.Sprintf("Lookup returned a value under a non object parent"))
+		}
+		if _, ok := Lookup(doc, "b"); ok {
+			t.Errorf(fmt.Sprintf("Lookup returned a value for a missing key"))
+		}
+	})
+
+	t.Run("Set : should pass (creates and replaces parents)", func(t *testing.T) {
+		doc := map[string]interface{}{"a": "x"}
+		Set(doc, "a.b.c", 1)
+		Set(doc, "d", 2)
+		if v, ok := Lookup(doc, "a.b.c"); !ok || v != 1 {
+			t.Errorf(fmt.Sprintf("Set returned incorrect value - got (%v) wanted (1)", v))
+		}
+		if v, ok := Lookup(doc, "d"); !ok || v != 2 {
+			t.Errorf(fmt.Sprintf("Set returned incorrect value - got (%v) wanted (2)", v))
+		}
+	})
+}
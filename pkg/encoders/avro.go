@@ -0,0 +1,40 @@
+package encoders
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// avroEncoder - the schema is read from an .avsc file
+type avroEncoder struct {
+	codec *goavro.Codec
+}
+
+func newAvroEncoder(file string) (*avroEncoder, error) {
+	if file == "" {
+		return nil, fmt.Errorf("avro encoding requires schema")
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := goavro.NewCodec(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("avro schema %s %v", file, err)
+	}
+	return &avroEncoder{codec: codec}, nil
+}
+
+func (e *avroEncoder) ContentType() string {
+	return "avro/binary"
+}
+
+func (e *avroEncoder) Encode(data []byte) ([]byte, error) {
+	native, _, err := e.codec.NativeFromTextual(data)
+	if err != nil {
+		return nil, err
+	}
+	return e.codec.BinaryFromNative(nil, native)
+}
@@ -0,0 +1,168 @@
+package encoders
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+const (
+	JSON     string = "json"
+	MSGPACK  string = "msgpack"
+	PROTOBUF string = "protobuf"
+	AVRO     string = "avro"
+)
+
+// Encoder interface - converts the rendered (json) template output into the wire format
+type Encoder interface {
+	ContentType() string
+	Encode(data []byte) ([]byte, error)
+}
+
+// Spec - encoding configuration for a single topic
+type Spec struct {
+	Encoding   string `json:"encoding"`
+	Schema     string `json:"schema,omitempty"`
+	Descriptor string `json:"descriptor,omitempty"`
+	Message    string `json:"message,omitempty"`
+}
+
+// Config - the file referenced by the ENCODING_CONFIG envar
+type Config struct {
+	Default *Spec            `json:"default,omitempty"`
+	Topics  map[string]*Spec `json:"topics"`
+}
+
+// Registry - the encoders selected per topic
+type Registry struct {
+	fallback Encoder
+	topics   map[string]Encoder
+}
+
+var (
+	mu       sync.RWMutex
+	registry = &Registry{fallback: &jsonEncoder{}, topics: map[string]Encoder{}}
+)
+
+// Load - reads the encoding config file and replaces the active registry
+// an empty file name resets the registry to json for every topic
+func Load(file string) error {
+	reg, err := Read(file)
+	if err != nil {
+		return err
+	}
+	Install(reg)
+	return nil
+}
+
+// Read - builds the registry from the encoding config file without installing it
+func Read(file string) (*Registry, error) {
+	reg := &Registry{fallback: &jsonEncoder{}, topics: map[string]Encoder{}}
+	if file == "" {
+		return reg, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("encoding config %s %v", file, err)
+	}
+	return NewRegistry(cfg)
+}
+
+// Files - the avro schemas and protobuf descriptors the encoding config file references (sorted)
+func Files(file string) ([]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("encoding config %s %v", file, err)
+	}
+	specs := []*Spec{cfg.Default}
+	for _, spec := range cfg.Topics {
+		specs = append(specs, spec)
+	}
+	var files []string
+	for _, spec := range specs {
+		if spec != nil && spec.Schema != "" {
+			files = append(files, spec.Schema)
+		}
+		if spec != nil && spec.Descriptor != "" {
+			files = append(files, spec.Descriptor)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Install - replaces the active registry
+func Install(reg *Registry) {
+	mu.Lock()
+	registry = reg
+	mu.Unlock()
+}
+
+// NewRegistry - builds (and validates) an encoder for every configured topic
+func NewRegistry(cfg *Config) (*Registry, error) {
+	reg := &Registry{fallback: &jsonEncoder{}, topics: map[string]Encoder{}}
+	if cfg.Default != nil {
+		enc, err := New(cfg.Default)
+		if err != nil {
+			return nil, fmt.Errorf("default encoder %v", err)
+		}
+		reg.fallback = enc
+	}
+	for topic, spec := range cfg.Topics {
+		enc, err := New(spec)
+		if err != nil {
+			return nil, fmt.Errorf("topic %s encoder %v", topic, err)
+		}
+		reg.topics[topic] = enc
+	}
+	return reg, nil
+}
+
+// SetTopic - overrides the encoder of topic (replacing the one from the config file)
+func (r *Registry) SetTopic(topic string, spec *Spec) error {
+	enc, err := New(spec)
+	if err != nil {
+		return err
+	}
+	r.topics[topic] = enc
+	return nil
+}
+
+// New - encoder factory
+func New(spec *Spec) (Encoder, error) {
+	switch spec.Encoding {
+	case JSON, "":
+		return &jsonEncoder{}, nil
+	case MSGPACK:
+		return &msgpackEncoder{}, nil
+	case PROTOBUF:
+		return newProtobufEncoder(spec.Descriptor, spec.Message)
+	case AVRO:
+		return newAvroEncoder(spec.Schema)
+	}
+	return nil, fmt.Errorf("unknown encoding %q", spec.Encoding)
+}
+
+// ForTopic - returns the encoder configured for the topic (or the default)
+func ForTopic(topic string) Encoder {
+	mu.RLock()
+	defer mu.RUnlock()
+	return registry.ForTopic(topic)
+}
+
+func (r *Registry) ForTopic(topic string) Encoder {
+	if enc, ok := r.topics[topic]; ok {
+		return enc
+	}
+	return r.fallback
+}
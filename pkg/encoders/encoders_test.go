@@ -0,0 +1,127 @@
+package encoders
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const rendered = `{ "number":"1234567", "email":"abc.xyz.com" }`
+
+func TestEncoders(t *testing.T) {
+
+	t.Run("Load : should pass", func(t *testing.T) {
+		err := Load("../../tests/encoding.json")
+		defer Load("")
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned with error - got (%v) wanted (%v)", "Load", err, nil))
+		}
+		if ForTopic("packed").ContentType() != "application/msgpack" || ForTopic("customers").ContentType() != "avro/binary" || ForTopic("other").ContentType() != "application/json" {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect encoders", "ForTopic"))
+		}
+	})
+
+	t.Run("Load : should fail (unknown encoding)", func(t *testing.T) {
+		_, err := NewRegistry(&Config{Topics: map[string]*Spec{"test": {Encoding: "xml"}}})
+		if err == nil {
+			t.Errorf(fmt.Sprintf("Function %s returned with no error - got (%v)", "NewRegistry", err))
+		}
+	})
+
+	t.Run("json : should pass", func(t *testing.T) {
+		b, err := (&jsonEncoder{}).Encode([]byte(rendered))
+		if err != nil || string(b) != rendered {
+			t.Errorf(fmt.Sprintf("Encoder %s returned (%s) error (%v)", JSON, b, err))
+		}
+	})
+
+	t.Run("msgpack : should pass", func(t *testing.T) {
+		b, err := (&msgpackEncoder{}).Encode([]byte(`{ "number":"1234567", "count": 3, "ratio": 0.5 }`))
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Encoder %s returned with error %v", MSGPACK, err))
+		}
+		m := map[string]interface{}{}
+		_ = msgpack.Unmarshal(b, &m)
+		if m["number"] != "1234567" || fmt.Sprint(m["count"]) != "3" || m["ratio"] != 0.5 {
+			t.Errorf(fmt.Sprintf("Encoder %s round trip incorrect - got (%v)", MSGPACK, m))
+		}
+	})
+
+	t.Run("avro : should pass", func(t *testing.T) {
+		enc, err := New(&Spec{Encoding: AVRO, Schema: "../../tests/customer.avsc"})
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned with error %v", "New", err))
+		}
+		b, err := enc.Encode([]byte(rendered))
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Encoder %s returned with error %v", AVRO, err))
+		}
+		codec := enc.(*avroEncoder).codec
+		native, _, _ := codec.NativeFromBinary(b)
+		if native.(map[string]interface{})["email"] != "abc.xyz.com" {
+			t.Errorf(fmt.Sprintf("Encoder %s round trip incorrect - got (%v)", AVRO, native))
+		}
+	})
+
+	t.Run("avro : should fail (missing field)", func(t *testing.T) {
+		enc, _ := New(&Spec{Encoding: AVRO, Schema: "../../tests/customer.avsc"})
+		if _, err := enc.Encode([]byte(`{ "number":"1234567" }`)); err == nil {
+			t.Errorf(fmt.Sprintf("Encoder %s returned with no error", AVRO))
+		}
+	})
+
+	t.Run("protobuf : should pass", func(t *testing.T) {
+		file := writeDescriptorSet(t)
+		enc, err := New(&Spec{Encoding: PROTOBUF, Descriptor: file, Message: "example.Customer"})
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned with error %v", "New", err))
+		}
+		b, err := enc.Encode([]byte(rendered))
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Encoder %s returned with error %v", PROTOBUF, err))
+		}
+		// field 1 (number) length delimited
+		if !bytes.HasPrefix(b, append([]byte{0x0a, 7}, []byte("1234567")...)) {
+			t.Errorf(fmt.Sprintf("Encoder %s returned incorrect wire format - got (%x)", PROTOBUF, b))
+		}
+	})
+
+	t.Run("protobuf : should fail (unknown message)", func(t *testing.T) {
+		file := writeDescriptorSet(t)
+		if _, err := New(&Spec{Encoding: PROTOBUF, Descriptor: file, Message: "example.Order"}); err == nil {
+			t.Errorf(fmt.Sprintf("Function %s returned with no error", "New"))
+		}
+	})
+}
+
+// writeDescriptorSet - equivalent of protoc --descriptor_set_out for a two field message
+func writeDescriptorSet(t *testing.T) string {
+	str := descriptorpb.FieldDescriptorProto_TYPE_STRING
+	opt := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	fds := &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{{
+			Name:    proto.String("customer.proto"),
+			Package: proto.String("example"),
+			Syntax:  proto.String("proto3"),
+			MessageType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("Customer"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{Name: proto.String("number"), JsonName: proto.String("number"), Number: proto.Int32(1), Type: &str, Label: &opt},
+					{Name: proto.String("email"), JsonName: proto.String("email"), Number: proto.Int32(2), Type: &str, Label: &opt},
+				},
+			}},
+		}},
+	}
+	b, _ := proto.Marshal(fds)
+	file := filepath.Join(t.TempDir(), "customer.pb")
+	if err := os.WriteFile(file, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
@@ -0,0 +1,48 @@
+package encoders
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+type jsonEncoder struct{}
+
+func (e *jsonEncoder) ContentType() string {
+	return "application/json"
+}
+
+// Encode - json passes through untouched
+func (e *jsonEncoder) Encode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// decodeJSON - private utility, numbers are kept as int64 where possible
+func decodeJSON(data []byte) (interface{}, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return convertNumbers(v), nil
+}
+
+func convertNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		f, _ := t.Float64()
+		return f
+	case map[string]interface{}:
+		for k, val := range t {
+			t[k] = convertNumbers(val)
+		}
+	case []interface{}:
+		for i, val := range t {
+			t[i] = convertNumbers(val)
+		}
+	}
+	return v
+}
@@ -0,0 +1,19 @@
+package encoders
+
+import (
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type msgpackEncoder struct{}
+
+func (e *msgpackEncoder) ContentType() string {
+	return "application/msgpack"
+}
+
+func (e *msgpackEncoder) Encode(data []byte) ([]byte, error) {
+	v, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(v)
+}
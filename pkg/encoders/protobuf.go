@@ -0,0 +1,58 @@
+package encoders
+
+import (
+	"fmt"
+	"os"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// protobufEncoder - the message type is resolved from a descriptor set
+// (protoc --include_imports --descriptor_set_out=...)
+type protobufEncoder struct {
+	desc protoreflect.MessageDescriptor
+}
+
+func newProtobufEncoder(file string, message string) (*protobufEncoder, error) {
+	if file == "" || message == "" {
+		return nil, fmt.Errorf("protobuf encoding requires descriptor and message")
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	fds := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, fds); err != nil {
+		return nil, fmt.Errorf("descriptor set %s %v", file, err)
+	}
+	files, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return nil, fmt.Errorf("descriptor set %s %v", file, err)
+	}
+	d, err := files.FindDescriptorByName(protoreflect.FullName(message))
+	if err != nil {
+		return nil, fmt.Errorf("message %s %v", message, err)
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message", message)
+	}
+	return &protobufEncoder{desc: md}, nil
+}
+
+func (e *protobufEncoder) ContentType() string {
+	return "application/x-protobuf; messageType=" + string(e.desc.FullName())
+}
+
+func (e *protobufEncoder) Encode(data []byte) ([]byte, error) {
+	msg := dynamicpb.NewMessage(e.desc)
+	if err := protojson.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	return proto.Marshal(msg)
+}
@@ -0,0 +1,409 @@
+// Package enrich - per topic lookups that enrich the request with data from other services
+//
+// Each lookup renders its url (and optional body) as a text/template over the request, calls the
+// endpoint through connectors.Clients.Do and merges the selected response fields into the request.
+// Results are cached per rendered request for the lookup ttl. A failed lookup fails the publish,
+// is skipped or sets the default values, as configured by onerror.
+package enrich
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/connectors"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/docpath"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/metrics"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/tracing"
+)
+
+const (
+	// lookup failure policies
+	ONERRORFAIL    string = "fail"
+	ONERRORSKIP    string = "skip"
+	ONERRORDEFAULT string = "default"
+	// used when a lookup has no timeout
+	// a lookup without a ttl calls the endpoint on every publish, with onerror default or skip an unavailable
+	// endpoint is still called (and waited for up to the timeout) by every publish
+	TIMEOUT time.Duration = 2 * time.Second
+	// response bodies are read up to MAXRESPONSE bytes
+	MAXRESPONSE int64 = 1 << 20
+	// cached results per lookup, expired entries are pruned when it is full
+	CACHESIZE int = 10000
+)
+
+// Config - the file referenced by the ENRICHMENT_CONFIG envar, the lookups run in order per topic
+type Config struct {
+	Topics map[string][]*Lookup `json:"topics"`
+}
+
+// Lookup - one endpoint call
+// fields maps a request field (dot separated, i.e. address or enriched.tier) to the response path
+// it is taken from, header values may reference envars (${CRM_TOKEN})
+// the scheme and host of the url are constant, request fields may only be rendered after them (path or query),
+// the fields are escaped for the url and the rendered path has to stay below the constant start of the template path
+type Lookup struct {
+	Name     string                 `json:"name"`
+	Method   string                 `json:"method,omitempty"`
+	URL      string                 `json:"url"`
+	Body     string                 `json:"body,omitempty"`
+	Headers  map[string]string      `json:"headers,omitempty"`
+	Timeout  string                 `json:"timeout,omitempty"`
+	TTL      string                 `json:"ttl,omitempty"`
+	Fields   map[string]string      `json:"fields"`
+	OnError  string                 `json:"onerror,omitempty"`
+	Defaults map[string]interface{} `json:"defaults,omitempty"`
+
+	url     *template.Template
+	origin  string
+	path    string
+	exact   bool
+	body    *template.Template
+	timeout time.Duration
+	ttl     time.Duration
+	cache   *cache
+}
+
+// Rules - the lookups per topic
+type Rules struct {
+	topics map[string][]*Lookup
+}
+
+type entry struct {
+	values  map[string]interface{}
+	expires time.Time
+}
+
+type cache struct {
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+var (
+	mu     sync.RWMutex
+	active = &Rules{topics: map[string][]*Lookup{}}
+)
+
+// Load - reads the enrichment config and replaces the active rules
+// an empty file name disables enrichment
+func Load(file string) error {
+	r, err := Read(file)
+	if err != nil {
+		return err
+	}
+	Install(r)
+	return nil
+}
+
+// Read - parses and validates every lookup of the config file without installing them
+func Read(file string) (*Rules, error) {
+	r := &Rules{topics: map[string][]*Lookup{}}
+	if file == "" {
+		return r, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("enrichment config %s %v", file, err)
+	}
+	for topic, lookups := range cfg.Topics {
+		for i, l := range lookups {
+			if err := l.parse(); err != nil {
+				return nil, fmt.Errorf("topic %s lookup %d (%s) %v", topic, i, l.Name, err)
+			}
+		}
+		r.topics[topic] = lookups
+	}
+	return r, nil
+}
+
+// Install - replaces the active rules (and their caches)
+func Install(r *Rules) {
+	mu.Lock()
+	active = r
+	mu.Unlock()
+}
+
+// Enabled - reports whether the topic has lookups
+func Enabled(topic string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return len(active.topics[topic]) > 0
+}
+
+// Topic - runs the lookups of the topic on the json document ({"request":{...}}), returns the enriched document
+// a later lookup sees the fields merged by the earlier ones
+func Topic(ctx context.Context, con connectors.Clients, topic string, doc []byte) ([]byte, error) {
+	mu.RLock()
+	lookups := active.topics[topic]
+	mu.RUnlock()
+	if len(lookups) == 0 {
+		return doc, nil
+	}
+	root := map[string]interface{}{}
+	if err := docpath.Decode(bytes.NewReader(doc), &root); err != nil {
+		return nil, err
+	}
+	request, ok := root["request"].(map[string]interface{})
+	if !ok {
+		request = map[string]interface{}{}
+		root["request"] = request
+	}
+	for _, l := range lookups {
+		values, err := l.lookup(ctx, con, request)
+		if err != nil {
+			switch l.OnError {
+			case ONERRORSKIP:
+				con.Error("Enrich %s skipped %v", l.Name, err)
+				continue
+			case ONERRORDEFAULT:
+				con.Error("Enrich %s using defaults %v", l.Name, err)
+				values = map[string]interface{}{}
+			default:
+				return nil, fmt.Errorf("lookup %s %v", l.Name, err)
+			}
+		}
+		for field := range l.Fields {
+			if v, ok := values[field]; ok {
+				docpath.Set(request, field, v)
+			} else if v, ok := l.Defaults[field]; ok {
+				docpath.Set(request, field, v)
+			}
+		}
+	}
+	return json.Marshal(root)
+}
+
+func (l *Lookup) parse() error {
+	var err error
+	if l.Name == "" {
+		return errors.New("name is required")
+	}
+	if l.Method == "" {
+		l.Method = http.MethodGet
+	}
+	if l.url, err = template.New(l.Name).Parse(l.URL); err != nil || l.URL == "" {
+		return fmt.Errorf("url %q is required and must be a valid template %v", l.URL, err)
+	}
+	if l.origin, l.path, l.exact, err = staticOrigin(l.URL); err != nil {
+		return err
+	}
+	if l.Body != "" {
+		if l.body, err = template.New(l.Name).Parse(l.Body); err != nil {
+			return fmt.Errorf("body %v", err)
+		}
+	}
+	if len(l.Fields) == 0 {
+		return errors.New("fields are required")
+	}
+	l.timeout = TIMEOUT
+	if l.Timeout != "" {
+		if l.timeout, err = time.ParseDuration(l.Timeout); err != nil || l.timeout <= 0 {
+			return fmt.Errorf("timeout %q must be a positive duration", l.Timeout)
+		}
+	}
+	if l.TTL != "" {
+		if l.ttl, err = time.ParseDuration(l.TTL); err != nil || l.ttl < 0 {
+			return fmt.Errorf("ttl %q must be a duration", l.TTL)
+		}
+	}
+	switch l.OnError {
+	case "":
+		l.OnError = ONERRORFAIL
+	case ONERRORFAIL, ONERRORSKIP, ONERRORDEFAULT:
+	default:
+		return fmt.Errorf("onerror %q must be one of %s, %s or %s", l.OnError, ONERRORFAIL, ONERRORSKIP, ONERRORDEFAULT)
+	}
+	for k, v := range l.Headers {
+		l.Headers[k] = os.ExpandEnv(v)
+	}
+	l.cache = &cache{entries: map[string]*entry{}}
+	return nil
+}
+
+// lookup - the selected response fields, from the cache while they are fresh
+func (l *Lookup) lookup(ctx context.Context, con connectors.Clients, request map[string]interface{}) (map[string]interface{}, error) {
+	// the templates see the request as the publish template does ({{ .Number }}, {{ .Enriched.tier }})
+	data, err := templateData(request)
+	if err != nil {
+		return nil, err
+	}
+	escaped, err := templateData(escapeValues(request).(map[string]interface{}))
+	if err != nil {
+		return nil, err
+	}
+	var url, body bytes.Buffer
+	if err := l.url.Execute(&url, escaped); err != nil {
+		return nil, fmt.Errorf("url %v", err)
+	}
+	if l.body != nil {
+		if err := l.body.Execute(&body, data); err != nil {
+			return nil, fmt.Errorf("body %v", err)
+		}
+	}
+	key := l.Method + " " + url.String() + "\n" + body.String()
+	if values, ok := l.cache.get(key); ok {
+		metrics.EnrichmentLookups.WithLabelValues(l.Name, metrics.OUTCOMECACHED).Inc()
+		return values, nil
+	}
+	values, err := l.call(ctx, con, url.String(), body.Bytes())
+	metrics.EnrichmentLookups.WithLabelValues(l.Name, metrics.Outcome(err)).Inc()
+	if err != nil {
+		return nil, err
+	}
+	if l.ttl > 0 {
+		l.cache.put(key, values, l.ttl)
+	}
+	return values, nil
+}
+
+func (l *Lookup) call(ctx context.Context, con connectors.Clients, url string, body []byte) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+	var reader io.Reader
+	if len(body) > 0 {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, l.Method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	if origin := req.URL.Scheme + "://" + req.URL.Host; origin != l.origin || req.URL.User != nil {
+		return nil, fmt.Errorf("url %s is not on %s", url, l.origin)
+	}
+	if (l.exact && req.URL.Path != l.path) || !strings.HasPrefix(path.Clean("/"+req.URL.Path), l.path) {
+		return nil, fmt.Errorf("url %s is not below %s%s", url, l.origin, l.path)
+	}
+	req.Header.Set("Accept", "application/json")
+	if reader != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range l.Headers {
+		req.Header.Set(k, v)
+	}
+	if id := connectors.RequestId(ctx); id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+	for k, v := range tracing.Inject(ctx) {
+		req.Header.Set(k, v)
+	}
+	con.Trace("Enrich %s %s %s", l.Name, l.Method, url)
+	res, err := con.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("%s %s returned status %d", l.Method, url, res.StatusCode)
+	}
+	var doc interface{}
+	if err := docpath.Decode(io.LimitReader(res.Body, MAXRESPONSE), &doc); err != nil {
+		return nil, fmt.Errorf("response %v", err)
+	}
+	values := map[string]interface{}{}
+	for field, path := range l.Fields {
+		if v, ok := docpath.Lookup(doc, path); ok {
+			values[field] = v
+		}
+	}
+	return values, nil
+}
+
+func (c *cache) get(key string) (map[string]interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.values, true
+}
+
+func (c *cache) put(key string, values map[string]interface{}, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.entries) >= CACHESIZE {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= CACHESIZE {
+			return
+		}
+	}
+	c.entries[key] = &entry{values: values, expires: now.Add(ttl)}
+}
+
+// staticOrigin - the scheme and host of the url template, which must not depend on the request
+// (the template text before the first action has to reach the path or query, i.e. http://crm/customers/{{ .Number }}),
+// the constant start of the path and whether that is the whole path (request fields only in the query)
+func staticOrigin(src string) (string, string, bool, error) {
+	prefix, _, templated := strings.Cut(src, "{{")
+	u, err := neturl.Parse(prefix)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return "", "", false, fmt.Errorf("url %q must start with a constant http(s)://host", src)
+	}
+	rest := strings.TrimPrefix(prefix, u.Scheme+"://"+u.Host)
+	if templated && !strings.HasPrefix(rest, "/") && !strings.HasPrefix(rest, "?") {
+		return "", "", false, fmt.Errorf("url %q must not render request fields into the scheme or host", src)
+	}
+	return u.Scheme + "://" + u.Host, u.Path, !templated || strings.Contains(rest, "?"), nil
+}
+
+// templateData - the request as the publish template sees it ({{ .Number }}, {{ .Enriched.tier }})
+func templateData(request map[string]interface{}) (*schema.CustomerPayload, error) {
+	b, _ := json.Marshal(request)
+	data := &schema.CustomerPayload{}
+	if err := json.Unmarshal(b, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// escapeValues - every string percent encoded but the unreserved characters (rfc 3986), a value rendered into
+// the url can not add path segments, query parameters or a fragment
+func escapeValues(v interface{}) interface{} {
+	switch t := v.(type) {
+	case string:
+		var b strings.Builder
+		for i := 0; i < len(t); i++ {
+			c := t[i]
+			if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' {
+				b.WriteByte(c)
+			} else {
+				fmt.Fprintf(&b, "%%%02X", c)
+			}
+		}
+		return b.String()
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, x := range t {
+			m[k] = escapeValues(x)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(t))
+		for i, x := range t {
+			list[i] = escapeValues(x)
+		}
+		return list
+	}
+	return v
+}
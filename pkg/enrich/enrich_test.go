@@ -0,0 +1,152 @@
+package enrich
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/connectors"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+	"github.com/microlib/simple"
+)
+
+const (
+	PAYLOAD string = `{ "request":{"email":"abc@xyz.com", "number":"1234567"}}`
+)
+
+func TestEnrich(t *testing.T) {
+
+	logger := &simple.Logger{Level: "info"}
+	os.Setenv("CRM_TOKEN", "secret")
+	ctx := context.Background()
+	response, _ := os.ReadFile("../../tests/enrichment-response.json")
+	var calls []*http.Request
+	var bodies []string
+	recording := func(code int) connectors.Clients {
+		conn := connectors.NewTestConnectors("", code, logger)
+		calls, bodies = nil, nil
+		conn.(*connectors.MockConnectors).Http = connectors.NewHttpTestClient(func(req *http.Request) *http.Response {
+			calls = append(calls, req)
+			body := ""
+			if req.Body != nil {
+				b, _ := io.ReadAll(req.Body)
+				body = string(b)
+			}
+			bodies = append(bodies, body)
+			return &http.Response{StatusCode: code, Body: io.NopCloser(bytes.NewBuffer(response)), Header: make(http.Header)}
+		})
+		return conn
+	}
+	request := func(doc []byte) *schema.CustomerPayload {
+		cp := &schema.GenericSchema{}
+		if err := json.Unmarshal(doc, cp); err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned invalid json %v", "Topic", err))
+		}
+		return cp.Request
+	}
+	if err := Load("../../tests/enrichment.json"); err != nil {
+		t.Fatalf(fmt.Sprintf("Function %s returned error %v", "Load", err))
+	}
+	defer Load("")
+
+	t.Run("Read : should fail (invalid onerror policy)", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "enrichment.json")
+		os.WriteFile(file, []byte(`{ "topics": { "test": [{ "name":"bad", "url":"http://x", "fields":{"a":"b"}, "onerror":"retry" }]}}`), 0644)
+		if _, err := Read(file); err == nil {
+			t.Errorf(fmt.Sprintf("Function %s should fail", "Read"))
+		}
+	})
+
+	t.Run("Read : should fail (request fields in the scheme or host)", func(t *testing.T) {
+		for _, url := range []string{"{{ .Email }}/customers", "http://{{ .Email }}/customers", "http://crm.example.com{{ .Email }}", "http://crm.example.com:{{ .Number }}/", "ftp://crm/{{ .Number }}"} {
+			file := filepath.Join(t.TempDir(), "enrichment.json")
+			os.WriteFile(file, []byte(`{ "topics": { "test": [{ "name":"bad", "url":"`+url+`", "fields":{"a":"b"} }]}}`), 0644)
+			if _, err := Read(file); err == nil {
+				t.Errorf(fmt.Sprintf("Function %s should fail for %s", "Read", url))
+			}
+		}
+	})
+
+	t.Run("Topic : should pass (fields merged, lookups chained, headers sent)", func(t *testing.T) {
+		conn := recording(200)
+		doc, err := Topic(ctx, conn, "enriched", []byte(PAYLOAD))
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned error %v", "Topic", err))
+		}
+		req := request(doc)
+		if req.FirstName != "first" || req.Address != "1 main street" || req.Enriched["tier"] != "gold" || req.Email != "abc@xyz.com" {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect request - got (%s)", "Topic", doc))
+		}
+		if len(calls) != 2 || calls[0].URL.String() != "http://crm.example.com/customers/1234567" || calls[0].Header.Get("Authorization") != "Bearer secret" {
+			t.Fatalf(fmt.Sprintf("Function %s made incorrect calls - got (%d)", "Topic", len(calls)))
+		}
+		if calls[1].Method != "POST" || bodies[1] != `{ "customer":"1234567", "tier":"gold" }` {
+			t.Errorf(fmt.Sprintf("Function %s sent incorrect body - got (%s %s)", "Topic", calls[1].Method, bodies[1]))
+		}
+	})
+
+	t.Run("Topic : should fail (request values escaped, paths outside the template rejected)", func(t *testing.T) {
+		conn := recording(200)
+		_, err := Topic(ctx, conn, "enriched", []byte(`{ "request":{"number":"x/../../admin?"}}`))
+		if err == nil || len(calls) != 0 {
+			t.Errorf(fmt.Sprintf("Function %s should not call outside /customers/ - got (%d calls %v)", "Topic", len(calls), err))
+		}
+		if _, err := Topic(ctx, conn, "enriched", []byte(`{ "request":{"number":".."}}`)); err == nil || len(calls) != 0 {
+			t.Errorf(fmt.Sprintf("Function %s should not call outside /customers/ - got (%d calls %v)", "Topic", len(calls), err))
+		}
+		Topic(ctx, conn, "enriched", []byte(`{ "request":{"number":"12 34&admin=1#x"}}`))
+		if len(calls) == 0 || calls[0].URL.String() != "http://crm.example.com/customers/12%2034%26admin%3D1%23x" {
+			t.Errorf(fmt.Sprintf("Function %s did not escape the request values - got (%d calls)", "Topic", len(calls)))
+		}
+	})
+
+	t.Run("Topic : should pass (cached lookup)", func(t *testing.T) {
+		conn := recording(200)
+		Topic(ctx, conn, "enriched", []byte(PAYLOAD))
+		// only the lookup without a ttl is called again
+		if len(calls) != 1 || calls[0].Method != "POST" {
+			t.Errorf(fmt.Sprintf("Function %s did not use the cache - got (%d calls)", "Topic", len(calls)))
+		}
+	})
+
+	t.Run("Topic : should fail (fail policy)", func(t *testing.T) {
+		conn := recording(503)
+		if _, err := Topic(ctx, conn, "enriched", []byte(`{ "request":{"number":"7654321"}}`)); err == nil {
+			t.Errorf(fmt.Sprintf("Function %s should fail", "Topic"))
+		}
+	})
+
+	t.Run("Topic : should pass (default policy)", func(t *testing.T) {
+		conn := recording(200)
+		// every call fails, the customer lookup is still served from the cache
+		conn.(*connectors.MockConnectors).Meta("true")
+		doc, err := Topic(ctx, conn, "enriched", []byte(PAYLOAD))
+		if err != nil || request(doc).Address != "unknown" {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect request - got (%s %v)", "Topic", doc, err))
+		}
+	})
+
+	t.Run("Topic : should pass (skip policy, topic without lookups)", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "enrichment.json")
+		os.WriteFile(file, []byte(`{ "topics": { "test": [{ "name":"optional", "url":"http://x/{{ .Number }}", "fields":{"mobile":"mobile"}, "onerror":"skip" }]}}`), 0644)
+		rules, err := Read(file)
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned error %v", "Read", err))
+		}
+		Install(rules)
+		conn := recording(500)
+		doc, err := Topic(ctx, conn, "test", []byte(PAYLOAD))
+		if err != nil || request(doc).Mobile != "" || len(calls) != 1 {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect request - got (%s %v)", "Topic", doc, err))
+		}
+		if doc, err := Topic(ctx, conn, "other", []byte(PAYLOAD)); err != nil || string(doc) != PAYLOAD || Enabled("other") {
+			t.Errorf(fmt.Sprintf("Function %s should not change other topics - got (%s %v)", "Topic", doc, err))
+		}
+	})
+}
@@ -0,0 +1,260 @@
+// Package fieldcrypt - field level envelope encryption of json messages
+//
+// Each selected field value is encrypted with a random data key (AES-256-GCM) and the
+// data key is wrapped with the active key of the keyring. The field is replaced by an
+// object holding the key id, the wrapped data key and the ciphertext, so keys can be
+// rotated without re-encrypting old messages. Subscribers use DecryptFields with a
+// keyring holding (at least) the key ids they need.
+package fieldcrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	ALGORITHM string = "AES256-GCM"
+)
+
+// Field - the encrypted replacement of a field value
+type Field struct {
+	Enc  string `json:"enc"`
+	Kid  string `json:"kid"`
+	Key  string `json:"key"`
+	Data string `json:"data"`
+}
+
+// KeyringFile - the keyring file format, keys are base64 encoded 32 byte keys
+type KeyringFile struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"`
+}
+
+// Keyring - key encryption keys indexed by key id
+type Keyring struct {
+	active string
+	keys   map[string][]byte
+}
+
+// LoadKeyring - reads a keyring file
+func LoadKeyring(file string) (*Keyring, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	kf := &KeyringFile{}
+	if err := json.Unmarshal(data, kf); err != nil {
+		return nil, fmt.Errorf("keyring %s %v", file, err)
+	}
+	return NewKeyring(kf)
+}
+
+// NewKeyring - validates the keys, the active key is only required for encryption
+func NewKeyring(kf *KeyringFile) (*Keyring, error) {
+	k := &Keyring{active: kf.Active, keys: map[string][]byte{}}
+	for kid, enc := range kf.Keys {
+		key, err := base64.StdEncoding.DecodeString(enc)
+		if err != nil {
+			return nil, fmt.Errorf("key %s %v", kid, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %s must be 32 bytes (got %d)", kid, len(key))
+		}
+		k.keys[kid] = key
+	}
+	if _, ok := k.keys[k.active]; k.active != "" && !ok {
+		return nil, fmt.Errorf("active key %s not in keyring", k.active)
+	}
+	return k, nil
+}
+
+// EncryptFields - encrypts the values of the fields in the json document
+// field names (no dot) match at any depth, dotted paths match from the root
+func (k *Keyring) EncryptFields(doc []byte, fields []string) ([]byte, error) {
+	if k.active == "" {
+		return nil, errors.New("keyring has no active key")
+	}
+	v, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	v, err = walk(v, nil, func(path []string, val interface{}) (interface{}, bool, error) {
+		if !matches(fields, path) {
+			return val, false, nil
+		}
+		f, err := k.encrypt(strings.Join(path, "."), val)
+		return f, true, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// DecryptFields - replaces every encrypted field in the json document with its plain value
+func (k *Keyring) DecryptFields(doc []byte) ([]byte, error) {
+	v, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	v, err = walk(v, nil, func(path []string, val interface{}) (interface{}, bool, error) {
+		f, ok := asField(val)
+		if !ok {
+			return val, false, nil
+		}
+		plain, err := k.decrypt(strings.Join(path, "."), f)
+		return plain, true, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func (k *Keyring) encrypt(path string, val interface{}) (*Field, error) {
+	plain, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	data, err := seal(dek, plain, []byte(path))
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(k.keys[k.active], dek, []byte(k.active))
+	if err != nil {
+		return nil, err
+	}
+	return &Field{
+		Enc:  ALGORITHM,
+		Kid:  k.active,
+		Key:  base64.StdEncoding.EncodeToString(wrapped),
+		Data: base64.StdEncoding.EncodeToString(data),
+	}, nil
+}
+
+func (k *Keyring) decrypt(path string, f *Field) (interface{}, error) {
+	kek, ok := k.keys[f.Kid]
+	if !ok {
+		return nil, fmt.Errorf("%s: key %s not in keyring", path, f.Kid)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(f.Key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	data, err := base64.StdEncoding.DecodeString(f.Data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	dek, err := open(kek, wrapped, []byte(f.Kid))
+	if err != nil {
+		return nil, fmt.Errorf("%s: unwrap key %v", path, err)
+	}
+	plain, err := open(dek, data, []byte(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: decrypt %v", path, err)
+	}
+	return decode(plain)
+}
+
+// seal - nonce is prefixed to the ciphertext, the additional data binds it to the field path / key id
+func seal(key []byte, plain []byte, ad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, ad), nil
+}
+
+func open(key []byte, data []byte, ad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], ad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func decode(doc []byte) (interface{}, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	err := dec.Decode(&v)
+	return v, err
+}
+
+// walk - calls fn for every object member, fn returns the replacement and whether to stop descending
+func walk(v interface{}, path []string, fn func([]string, interface{}) (interface{}, bool, error)) (interface{}, error) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for key, val := range t {
+			p := append(append([]string{}, path...), key)
+			nv, done, err := fn(p, val)
+			if err != nil {
+				return nil, err
+			}
+			if !done {
+				if nv, err = walk(val, p, fn); err != nil {
+					return nil, err
+				}
+			}
+			t[key] = nv
+		}
+	case []interface{}:
+		for i, val := range t {
+			nv, err := walk(val, path, fn)
+			if err != nil {
+				return nil, err
+			}
+			t[i] = nv
+		}
+	}
+	return v, nil
+}
+
+func matches(fields []string, path []string) bool {
+	joined := strings.Join(path, ".")
+	for _, f := range fields {
+		if f == joined || (!strings.Contains(f, ".") && f == path[len(path)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func asField(v interface{}) (*Field, bool) {
+	m, ok := v.(map[string]interface{})
+	if !ok || m["enc"] != ALGORITHM || len(m) != 4 {
+		return nil, false
+	}
+	f := &Field{Enc: ALGORITHM}
+	f.Kid, _ = m["kid"].(string)
+	f.Key, _ = m["key"].(string)
+	f.Data, _ = m["data"].(string)
+	return f, f.Kid != "" && f.Key != "" && f.Data != ""
+}
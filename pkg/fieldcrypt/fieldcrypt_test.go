@@ -0,0 +1,84 @@
+package fieldcrypt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFieldCrypt(t *testing.T) {
+
+	doc := []byte(`{ "number":"1234567", "email":"abc@xyz.com", "request": { "mobile":"0871234567", "email": { "work":"w@xyz.com" } } }`)
+
+	t.Run("EncryptFields : should pass (round trip)", func(t *testing.T) {
+		k, err := LoadKeyring("../../tests/keyring.json")
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned with error %v", "LoadKeyring", err))
+		}
+		b, err := k.EncryptFields(doc, []string{"email", "request.mobile"})
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned with error %v", "EncryptFields", err))
+		}
+		if strings.Contains(string(b), "xyz.com") || strings.Contains(string(b), "0871234567") || !strings.Contains(string(b), `"kid":"k2"`) {
+			t.Errorf(fmt.Sprintf("Function %s left plain values - got (%s)", "EncryptFields", b))
+		}
+		plain, err := k.DecryptFields(b)
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned with error %v", "DecryptFields", err))
+		}
+		var got, want interface{}
+		_ = json.Unmarshal(plain, &got)
+		_ = json.Unmarshal(doc, &want)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf(fmt.Sprintf("Function %s round trip - got (%s) wanted (%s)", "DecryptFields", plain, doc))
+		}
+	})
+
+	t.Run("DecryptFields : should pass (rotated key)", func(t *testing.T) {
+		old, _ := LoadKeyring("../../tests/keyring.json")
+		old.active = "k1"
+		b, _ := old.EncryptFields(doc, []string{"number"})
+		k, _ := LoadKeyring("../../tests/keyring.json")
+		if _, err := k.DecryptFields(b); err != nil {
+			t.Errorf(fmt.Sprintf("Function %s returned with error %v", "DecryptFields", err))
+		}
+		delete(k.keys, "k1")
+		if _, err := k.DecryptFields(b); err == nil || !strings.Contains(err.Error(), "key k1 not in keyring") {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect error %v", "DecryptFields", err))
+		}
+	})
+
+	t.Run("DecryptFields : should fail (value moved to another field)", func(t *testing.T) {
+		k, _ := LoadKeyring("../../tests/keyring.json")
+		b, _ := k.EncryptFields([]byte(`{ "email":"abc@xyz.com", "number":"1" }`), []string{"email"})
+		m := map[string]json.RawMessage{}
+		_ = json.Unmarshal(b, &m)
+		m["number"] = m["email"]
+		b, _ = json.Marshal(m)
+		if _, err := k.DecryptFields(b); err == nil {
+			t.Errorf(fmt.Sprintf("Function %s returned with no error", "DecryptFields"))
+		}
+	})
+
+	t.Run("NewKeyring : should fail (short key)", func(t *testing.T) {
+		if _, err := NewKeyring(&KeyringFile{Active: "k1", Keys: map[string]string{"k1": "c2hvcnQ="}}); err == nil {
+			t.Errorf(fmt.Sprintf("Function %s returned with no error", "NewKeyring"))
+		}
+	})
+
+	t.Run("EncryptTopic : should pass", func(t *testing.T) {
+		if err := Load("../../tests/encryption.json"); err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned with error %v", "Load", err))
+		}
+		defer Load("")
+		b, err := EncryptTopic("secure", doc)
+		if err != nil || strings.Contains(string(b), "abc@xyz.com") {
+			t.Errorf(fmt.Sprintf("Function %s returned (%s) error (%v)", "EncryptTopic", b, err))
+		}
+		b, _ = EncryptTopic("other", doc)
+		if string(b) != string(doc) {
+			t.Errorf(fmt.Sprintf("Function %s should not touch other topics - got (%s)", "EncryptTopic", b))
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package fieldcrypt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Config - the file referenced by the ENCRYPTION_CONFIG envar
+type Config struct {
+	Keyring string              `json:"keyring"`
+	Topics  map[string][]string `json:"topics"`
+}
+
+// Rules - the fields encrypted per topic and the keyring used
+type Rules struct {
+	keyring *Keyring
+	topics  map[string][]string
+}
+
+var (
+	mu     sync.RWMutex
+	active = &Rules{topics: map[string][]string{}}
+)
+
+// Load - reads the encryption config (per topic field lists) and its keyring
+// an empty file name disables encryption
+func Load(file string) error {
+	r, err := Read(file)
+	if err != nil {
+		return err
+	}
+	Install(r)
+	return nil
+}
+
+// Read - builds the rules from the encryption config file without installing them
+func Read(file string) (*Rules, error) {
+	r := &Rules{topics: map[string][]string{}}
+	if file == "" {
+		return r, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("encryption config %s %v", file, err)
+	}
+	if r.keyring, err = LoadKeyring(cfg.Keyring); err != nil {
+		return nil, err
+	}
+	if r.keyring.active == "" {
+		return nil, fmt.Errorf("keyring %s has no active key", cfg.Keyring)
+	}
+	r.topics = cfg.Topics
+	return r, nil
+}
+
+// Files - the keyring the encryption config file references
+func Files(file string) ([]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("encryption config %s %v", file, err)
+	}
+	return []string{cfg.Keyring}, nil
+}
+
+// SetTopic - overrides the fields encrypted for topic, the keyring comes from the encryption config
+func (r *Rules) SetTopic(topic string, fields []string) error {
+	if r.keyring == nil {
+		return errors.New("field encryption needs a keyring (ENCRYPTION_CONFIG)")
+	}
+	if r.topics == nil {
+		r.topics = map[string][]string{}
+	}
+	r.topics[topic] = fields
+	return nil
+}
+
+// Install - replaces the active rules
+func Install(r *Rules) {
+	mu.Lock()
+	active = r
+	mu.Unlock()
+}
+
+// EncryptTopic - encrypts the fields configured for the topic, messages of other topics are returned untouched
+func EncryptTopic(topic string, doc []byte) ([]byte, error) {
+	mu.RLock()
+	r := active
+	mu.RUnlock()
+	fields := r.topics[topic]
+	if len(fields) == 0 {
+		return doc, nil
+	}
+	return r.keyring.EncryptFields(doc, fields)
+}
@@ -0,0 +1,226 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: publisher.proto
+
+// publish api, the grpc counterpart of POST /api/v1/publish
+
+package grpcapi
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Publisher_Publish_FullMethodName       = "/publisher.v1.Publisher/Publish"
+	Publisher_PublishBatch_FullMethodName  = "/publisher.v1.Publisher/PublishBatch"
+	Publisher_PublishStream_FullMethodName = "/publisher.v1.Publisher/PublishStream"
+)
+
+// PublisherClient is the client API for Publisher service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PublisherClient interface {
+	// Publish - a single payload, failures are returned as a grpc status
+	Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*PublishReply, error)
+	// PublishBatch - every payload is published, each one gets its own reply
+	PublishBatch(ctx context.Context, in *PublishBatchRequest, opts ...grpc.CallOption) (*PublishBatchReply, error)
+	// PublishStream - publishes the streamed payloads as they arrive, replies once the client closes the stream
+	PublishStream(ctx context.Context, opts ...grpc.CallOption) (Publisher_PublishStreamClient, error)
+}
+
+type publisherClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPublisherClient(cc grpc.ClientConnInterface) PublisherClient {
+	return &publisherClient{cc}
+}
+
+func (c *publisherClient) Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*PublishReply, error) {
+	out := new(PublishReply)
+	err := c.cc.Invoke(ctx, Publisher_Publish_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *publisherClient) PublishBatch(ctx context.Context, in *PublishBatchRequest, opts ...grpc.CallOption) (*PublishBatchReply, error) {
+	out := new(PublishBatchReply)
+	err := c.cc.Invoke(ctx, Publisher_PublishBatch_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *publisherClient) PublishStream(ctx context.Context, opts ...grpc.CallOption) (Publisher_PublishStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Publisher_ServiceDesc.Streams[0], Publisher_PublishStream_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &publisherPublishStreamClient{stream}
+	return x, nil
+}
+
+type Publisher_PublishStreamClient interface {
+	Send(*PublishRequest) error
+	CloseAndRecv() (*PublishBatchReply, error)
+	grpc.ClientStream
+}
+
+type publisherPublishStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *publisherPublishStreamClient) Send(m *PublishRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *publisherPublishStreamClient) CloseAndRecv() (*PublishBatchReply, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(PublishBatchReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// PublisherServer is the server API for Publisher service.
+// All implementations must embed UnimplementedPublisherServer
+// for forward compatibility
+type PublisherServer interface {
+	// Publish - a single payload, failures are returned as a grpc status
+	Publish(context.Context, *PublishRequest) (*PublishReply, error)
+	// PublishBatch - every payload is published, each one gets its own reply
+	PublishBatch(context.Context, *PublishBatchRequest) (*PublishBatchReply, error)
+	// PublishStream - publishes the streamed payloads as they arrive, replies once the client closes the stream
+	PublishStream(Publisher_PublishStreamServer) error
+	mustEmbedUnimplementedPublisherServer()
+}
+
+// UnimplementedPublisherServer must be embedded to have forward compatible implementations.
+type UnimplementedPublisherServer struct {
+}
+
+func (UnimplementedPublisherServer) Publish(context.Context, *PublishRequest) (*PublishReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Publish not implemented")
+}
+func (UnimplementedPublisherServer) PublishBatch(context.Context, *PublishBatchRequest) (*PublishBatchReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PublishBatch not implemented")
+}
+func (UnimplementedPublisherServer) PublishStream(Publisher_PublishStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method PublishStream not implemented")
+}
+func (UnimplementedPublisherServer) mustEmbedUnimplementedPublisherServer() {}
+
+// UnsafePublisherServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PublisherServer will
+// result in compilation errors.
+type UnsafePublisherServer interface {
+	mustEmbedUnimplementedPublisherServer()
+}
+
+func RegisterPublisherServer(s grpc.ServiceRegistrar, srv PublisherServer) {
+	s.RegisterService(&Publisher_ServiceDesc, srv)
+}
+
+func _Publisher_Publish_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PublishRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PublisherServer).Publish(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Publisher_Publish_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PublisherServer).Publish(ctx, req.(*PublishRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Publisher_PublishBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PublishBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PublisherServer).PublishBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Publisher_PublishBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PublisherServer).PublishBatch(ctx, req.(*PublishBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Publisher_PublishStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PublisherServer).PublishStream(&publisherPublishStreamServer{stream})
+}
+
+type Publisher_PublishStreamServer interface {
+	SendAndClose(*PublishBatchReply) error
+	Recv() (*PublishRequest, error)
+	grpc.ServerStream
+}
+
+type publisherPublishStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *publisherPublishStreamServer) SendAndClose(m *PublishBatchReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *publisherPublishStreamServer) Recv() (*PublishRequest, error) {
+	m := new(PublishRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Publisher_ServiceDesc is the grpc.ServiceDesc for Publisher service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Publisher_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "publisher.v1.Publisher",
+	HandlerType: (*PublisherServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Publish",
+			Handler:    _Publisher_Publish_Handler,
+		},
+		{
+			MethodName: "PublishBatch",
+			Handler:    _Publisher_PublishBatch_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PublishStream",
+			Handler:       _Publisher_PublishStream_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "publisher.proto",
+}
@@ -0,0 +1,220 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/connectors"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/handlers"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/metrics"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/ratelimit"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/tracing"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/validator"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	REQUESTIDMETADATA string = "x-request-id"
+	// the payloads are limited as the bodies of this route (MAX_BODY_SIZE_ROUTES or MAX_BODY_SIZE)
+	PUBLISHROUTE string = "/api/v1/publish"
+)
+
+// Server - the Publisher service, payloads go through the same pipeline as SendPayloadHandler
+type Server struct {
+	UnimplementedPublisherServer
+	con connectors.Clients
+}
+
+// NewServer - the grpc server with the publisher, health and reflection services registered
+// every call gets a request id (x-request-id metadata), a server span and the grpc_duration_seconds metric
+func NewServer(con connectors.Clients) *grpc.Server {
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(unaryInterceptor), grpc.ChainStreamInterceptor(streamInterceptor))
+	RegisterPublisherServer(srv, &Server{con: con})
+	hs := health.NewServer()
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus(Publisher_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, hs)
+	reflection.Register(srv)
+	return srv
+}
+
+// Publish - a failed payload is returned as a grpc status (InvalidArgument, ResourceExhausted or Internal)
+func (s *Server) Publish(ctx context.Context, req *PublishRequest) (*PublishReply, error) {
+	reply := s.publish(ctx, req)
+	if reply.Code != http.StatusOK {
+		return nil, status.Error(statusCode(int(reply.Code)), reply.Message)
+	}
+	return reply, nil
+}
+
+func (s *Server) PublishBatch(ctx context.Context, req *PublishBatchRequest) (*PublishBatchReply, error) {
+	batch := &PublishBatchReply{}
+	for _, r := range req.Requests {
+		batch.add(s.publish(ctx, r))
+	}
+	return batch, nil
+}
+
+func (s *Server) PublishStream(stream Publisher_PublishStreamServer) error {
+	batch := &PublishBatchReply{}
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(batch)
+		}
+		if err != nil {
+			return err
+		}
+		batch.add(s.publish(stream.Context(), req))
+	}
+}
+
+// publish - applies the body limit of POST /api/v1/publish (as BodyMiddleware) and the client rate limit
+// (as RateLimitMiddleware) and runs the pipeline
+func (s *Server) publish(ctx context.Context, req *PublishRequest) *PublishReply {
+	con := s.con.WithContext(ctx)
+	if limit := handlers.BodyLimit(PUBLISHROUTE); int64(len(req.Payload)) > limit {
+		return &PublishReply{Code: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("payload of %d bytes exceeds the limit of %d", len(req.Payload), limit)}
+	}
+	if err := ratelimit.Allow(ctx, con, ratelimit.SCOPECLIENT, clientKey(ctx)); err != nil {
+		var lerr *ratelimit.LimitError
+		if errors.As(err, &lerr) {
+			metrics.Throttled.WithLabelValues(lerr.Scope).Inc()
+			return &PublishReply{Code: http.StatusTooManyRequests, Message: err.Error()}
+		}
+		// fail open when the redis counter is unavailable
+		con.Error("Publish %v", err)
+	}
+	header := http.Header{}
+	header.Set(handlers.CONTENTTYPE, handlers.APPLICATIONJSON)
+	for k, v := range req.Headers {
+		header.Set(k, v)
+	}
+	meta, code, err := handlers.Publish(ctx, req.Payload, header, con)
+	if err != nil {
+		reply := &PublishReply{Code: int32(code), Message: err.Error()}
+		var perr *validator.PayloadError
+		if errors.As(err, &perr) {
+			reply.Errors = perr.Details
+		}
+		return reply
+	}
+	return &PublishReply{Code: http.StatusOK, Message: "published successfully", Meta: toMeta(meta)}
+}
+
+func (b *PublishBatchReply) add(reply *PublishReply) {
+	b.Replies = append(b.Replies, reply)
+	if reply.Code != http.StatusOK {
+		b.Failed++
+	}
+}
+
+func toMeta(meta *schema.SchemaInterface) *Meta {
+	if meta == nil {
+		return nil
+	}
+	return &Meta{Id: meta.ID, LastUpdate: meta.LastUpdate, MetaInfo: meta.MetaInfo}
+}
+
+// statusCode - the grpc equivalent of the http status SendPayloadHandler responds with
+func statusCode(code int) codes.Code {
+	switch code {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusTooManyRequests, http.StatusRequestEntityTooLarge:
+		return codes.ResourceExhausted
+	}
+	return codes.Internal
+}
+
+// clientKey - as ratelimit.ClientKey with the call context (identity verified by callContext), metadata as headers
+// and the peer address
+func clientKey(ctx context.Context) string {
+	r := (&http.Request{Header: http.Header{}}).WithContext(ctx)
+	md, _ := metadata.FromIncomingContext(ctx)
+	for k, vals := range md {
+		for _, v := range vals {
+			r.Header.Add(k, v)
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+	return ratelimit.ClientKey(r)
+}
+
+// callContext - the request id (taken from the metadata or generated), the verified client identity (see
+// handlers.Identity) and the server span continuing the caller's trace, the returned metadata carries the
+// request id back to the caller
+func callContext(ctx context.Context, method string) (context.Context, metadata.MD, func(error)) {
+	start := time.Now()
+	md, _ := metadata.FromIncomingContext(ctx)
+	id := uuid.New().String()
+	if ids := md.Get(REQUESTIDMETADATA); len(ids) > 0 && ids[0] != "" && len(ids[0]) <= 128 {
+		id = ids[0]
+	}
+	carrier := map[string]string{}
+	for k, vals := range md {
+		if len(vals) > 0 {
+			carrier[k] = vals[0]
+		}
+	}
+	ctx, span := tracing.StartRPC(connectors.ContextWithRequestId(verifiedContext(ctx, md), id), carrier, method)
+	return ctx, metadata.Pairs(REQUESTIDMETADATA, id), func(err error) {
+		tracing.End(span, err)
+		metrics.GrpcDuration.WithLabelValues(method, status.Code(err).String()).Observe(time.Since(start).Seconds())
+	}
+}
+
+// verifiedContext - the x-api-key or authorization metadata checked as the http headers by IdentityMiddleware
+func verifiedContext(ctx context.Context, md metadata.MD) context.Context {
+	header := http.Header{}
+	for k, vals := range md {
+		for _, v := range vals {
+			header.Add(k, v)
+		}
+	}
+	if id := handlers.Identity(header); id != "" {
+		return ratelimit.Verified(ctx, id)
+	}
+	return ctx
+}
+
+func unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, header, done := callContext(ctx, info.FullMethod)
+	grpc.SetHeader(ctx, header)
+	res, err := handler(ctx, req)
+	done(err)
+	return res, err
+}
+
+func streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, header, done := callContext(ss.Context(), info.FullMethod)
+	ss.SetHeader(header)
+	err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	done(err)
+	return err
+}
+
+// contextStream - a server stream carrying the call context
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,133 @@
+package grpcapi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/connectors"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/ratelimit"
+	"github.com/microlib/simple"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	PAYLOAD    string = `{ "request":{"email":"abc@xyz.com", "number":"1234567"}}`
+	BADPAYLOAD string = `"not a payload"`
+)
+
+func TestGrpcPublisher(t *testing.T) {
+
+	logger := &simple.Logger{Level: "info"}
+	conn := connectors.NewTestConnectors("", 200, logger)
+	lis := bufconn.Listen(1024 * 1024)
+	srv := NewServer(conn)
+	go srv.Serve(lis)
+	defer srv.Stop()
+	cc, err := grpc.Dial("bufnet", grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf(fmt.Sprintf("Function %s returned error %v", "Dial", err))
+	}
+	defer cc.Close()
+	client := NewPublisherClient(cc)
+	ctx := context.Background()
+
+	t.Run("Publish : should pass (request id returned as header metadata)", func(t *testing.T) {
+		var header metadata.MD
+		ctx := metadata.AppendToOutgoingContext(ctx, REQUESTIDMETADATA, "grpc-test-id")
+		res, err := client.Publish(ctx, &PublishRequest{Payload: []byte(PAYLOAD)}, grpc.Header(&header))
+		if err != nil || res.Code != 200 {
+			t.Fatalf(fmt.Sprintf("Function %s returned incorrect reply - got (%v %v)", "Publish", res, err))
+		}
+		if ids := header.Get(REQUESTIDMETADATA); len(ids) != 1 || ids[0] != "grpc-test-id" {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect request id - got (%v)", "Publish", ids))
+		}
+	})
+
+	t.Run("Publish : should fail (invalid argument status)", func(t *testing.T) {
+		_, err := client.Publish(ctx, &PublishRequest{Payload: []byte(BADPAYLOAD)})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect status - got (%v)", "Publish", err))
+		}
+	})
+
+	t.Run("PublishBatch : should pass (failed count)", func(t *testing.T) {
+		res, err := client.PublishBatch(ctx, &PublishBatchRequest{Requests: []*PublishRequest{
+			{Payload: []byte(PAYLOAD)}, {Payload: []byte(BADPAYLOAD)}, {Payload: []byte(PAYLOAD)},
+		}})
+		if err != nil || len(res.Replies) != 3 || res.Failed != 1 || res.Replies[1].Code != 400 {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect reply - got (%v %v)", "PublishBatch", res, err))
+		}
+	})
+
+	t.Run("PublishStream : should pass (failed count)", func(t *testing.T) {
+		stream, err := client.PublishStream(ctx)
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned error %v", "PublishStream", err))
+		}
+		for _, payload := range []string{PAYLOAD, PAYLOAD, BADPAYLOAD} {
+			stream.Send(&PublishRequest{Payload: []byte(payload)})
+		}
+		res, err := stream.CloseAndRecv()
+		if err != nil || len(res.Replies) != 3 || res.Failed != 1 {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect reply - got (%v %v)", "PublishStream", res, err))
+		}
+	})
+
+	t.Run("Health : should pass (serving)", func(t *testing.T) {
+		res, err := healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{Service: Publisher_ServiceDesc.ServiceName})
+		if err != nil || res.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect status - got (%v %v)", "Check", res, err))
+		}
+	})
+
+	t.Run("Publish : should pass (x-api-key client limited apart from the peer address)", func(t *testing.T) {
+		os.Setenv("API_KEYS", "unlimited=k3y")
+		defer os.Unsetenv("API_KEYS")
+		if err := ratelimit.Load("../../tests/ratelimit.json"); err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned error %v", "Load", err))
+		}
+		defer ratelimit.Load("")
+		keyed := metadata.AppendToOutgoingContext(ctx, "x-api-key", "k3y")
+		got := []codes.Code{}
+		for i := 0; i < 3; i++ {
+			_, err := client.Publish(keyed, &PublishRequest{Payload: []byte(PAYLOAD)})
+			got = append(got, status.Code(err))
+		}
+		for i := 0; i < 3; i++ {
+			_, err := client.Publish(ctx, &PublishRequest{Payload: []byte(PAYLOAD)})
+			got = append(got, status.Code(err))
+		}
+		if fmt.Sprint(got) != "[OK OK OK OK OK ResourceExhausted]" {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect status codes - got (%v)", "Publish", got))
+		}
+	})
+
+	t.Run("Publish : should fail (payload over the publish body limit)", func(t *testing.T) {
+		os.Setenv("MAX_BODY_SIZE_ROUTES", PUBLISHROUTE+"=16")
+		defer os.Unsetenv("MAX_BODY_SIZE_ROUTES")
+		if _, err := client.Publish(ctx, &PublishRequest{Payload: []byte(PAYLOAD)}); status.Code(err) != codes.ResourceExhausted {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect status - got (%v)", "Publish", err))
+		}
+		res, err := client.PublishBatch(ctx, &PublishBatchRequest{Requests: []*PublishRequest{{Payload: []byte(`{"request":{}}`)}, {Payload: []byte(PAYLOAD)}}})
+		if err != nil || res.Failed != 1 || res.Replies[1].Code != 413 {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect reply - got (%v %v)", "PublishBatch", res, err))
+		}
+	})
+
+	t.Run("statusCode : should pass (http to grpc codes)", func(t *testing.T) {
+		if statusCode(400) != codes.InvalidArgument || statusCode(429) != codes.ResourceExhausted || statusCode(413) != codes.ResourceExhausted || statusCode(500) != codes.Internal {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect codes", "statusCode"))
+		}
+	})
+}
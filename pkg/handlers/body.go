@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/klauspost/compress/zstd"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/pipeline"
+)
+
+const (
+	CONTENTENCODING string = "Content-Encoding"
+	SCHEMAJSON      string = "application/schema+json"
+	// default request body limit (MAX_BODY_SIZE)
+	MAXBODYSIZE int64 = 1 << 20
+)
+
+// routeContentTypes - the media types accepted by each route with a request body
+// binary mode cloudevents carry the event data as is, so any media type is accepted with ce-specversion
+var routeContentTypes = map[string][]string{
+	"/api/v1/publish":                        {APPLICATIONJSON, pipeline.CLOUDEVENTSJSON},
+	"/api/v1/admin/schemas/{topic}/versions": {APPLICATIONJSON, SCHEMAJSON},
+}
+
+// DecodeError - the compressed request body could not be decoded
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return "could not decode request body " + e.Err.Error()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// BodyMiddleware implements mux.MiddlewareFunc.
+// Checks the Content-Type (415), decodes gzip, deflate or zstd bodies (415 for other encodings)
+// and limits the body (compressed and decoded) to MAX_BODY_SIZE or the MAX_BODY_SIZE_ROUTES entry
+// for the route (i.e. "/api/v1/publish=65536"), reads over the limit fail with *http.MaxBytesError
+func BodyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions || r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+		path := ""
+		if route := mux.CurrentRoute(r); route != nil {
+			path, _ = route.GetPathTemplate()
+		}
+
+		if types, ok := routeContentTypes[path]; ok && !acceptedContentType(r.Header, types) {
+			msg := "BodyMiddleware unsupported content type %q (accepts %s)"
+			addHeaders(w, r)
+			b := responseErrorFormat(http.StatusUnsupportedMediaType, w, msg, r.Header.Get(CONTENTTYPE), strings.Join(types, ", "))
+			fmt.Fprintf(w, "%s", string(b))
+			return
+		}
+
+		limit := BodyLimit(path)
+		body, err := decodeBody(r.Header.Get(CONTENTENCODING), http.MaxBytesReader(w, r.Body, limit))
+		if err != nil {
+			code := http.StatusBadRequest
+			var merr *http.MaxBytesError
+			if errors.Is(err, errUnsupportedEncoding) {
+				code = http.StatusUnsupportedMediaType
+			} else if errors.As(err, &merr) {
+				code = http.StatusRequestEntityTooLarge
+			}
+			msg := "BodyMiddleware %v"
+			addHeaders(w, r)
+			b := responseErrorFormat(code, w, msg, err)
+			fmt.Fprintf(w, "%s", string(b))
+			return
+		}
+		if body != r.Body {
+			r.Header.Del(CONTENTENCODING)
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+			body = http.MaxBytesReader(w, body, limit)
+		}
+		r.Body = body
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bodyError - maps body read errors, 413 over the limit, 400 for a corrupt compressed body
+// returns false for any other error
+func bodyError(w http.ResponseWriter, name string, err error) bool {
+	var merr *http.MaxBytesError
+	var derr *DecodeError
+	code := 0
+	switch {
+	case errors.As(err, &merr):
+		code = http.StatusRequestEntityTooLarge
+	case errors.As(err, &derr):
+		code = http.StatusBadRequest
+	default:
+		return false
+	}
+	msg := name + " %v"
+	b := responseErrorFormat(code, w, msg, err)
+	fmt.Fprintf(w, "%s", string(b))
+	return true
+}
+
+// acceptedContentType - the media type (parameters ignored) is one of types
+func acceptedContentType(header http.Header, types []string) bool {
+	if header.Get(pipeline.CEHEADERPREFIX+"Specversion") != "" {
+		return true
+	}
+	mt, _, err := mime.ParseMediaType(header.Get(CONTENTTYPE))
+	if err != nil {
+		return false
+	}
+	for _, t := range types {
+		if mt == t {
+			return true
+		}
+	}
+	return false
+}
+
+// BodyLimit - MAX_BODY_SIZE_ROUTES entry for the route, MAX_BODY_SIZE or the default
+func BodyLimit(path string) int64 {
+	cfg := config.Get()
+	for _, entry := range strings.Split(cfg.MaxBodySizeRoutes, ",") {
+		route, size, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if n, err := strconv.ParseInt(size, 10, 64); ok && route == path && err == nil && n > 0 {
+			return n
+		}
+	}
+	if cfg.MaxBodySize > 0 {
+		return cfg.MaxBodySize
+	}
+	return MAXBODYSIZE
+}
+
+var errUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// decodeBody - wraps the body with the decompressor for the content encoding
+func decodeBody(encoding string, body io.ReadCloser) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	var err error
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		rc, err = gzip.NewReader(body)
+	case "deflate":
+		rc, err = zlib.NewReader(body)
+	case "zstd":
+		var d *zstd.Decoder
+		if d, err = zstd.NewReader(body); err == nil {
+			rc = d.IOReadCloser()
+		}
+	default:
+		return nil, fmt.Errorf("%w %s", errUnsupportedEncoding, encoding)
+	}
+	if err != nil {
+		var merr *http.MaxBytesError
+		if errors.As(err, &merr) {
+			return nil, err
+		}
+		return nil, &DecodeError{Err: err}
+	}
+	return &decodedBody{ReadCloser: rc, body: body}, nil
+}
+
+// decodedBody - decoder errors are reported as *DecodeError, the limit error as is
+type decodedBody struct {
+	io.ReadCloser
+	body io.ReadCloser
+}
+
+func (d *decodedBody) Read(p []byte) (int, error) {
+	n, err := d.ReadCloser.Read(p)
+	var merr *http.MaxBytesError
+	if err != nil && err != io.EOF && !errors.As(err, &merr) {
+		err = &DecodeError{Err: err}
+	}
+	return n, err
+}
+
+func (d *decodedBody) Close() error {
+	d.ReadCloser.Close()
+	return d.body.Close()
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+)
+
+const (
+	CORSMETHODS string = "GET, POST, PUT, DELETE"
+	CORSHEADERS string = "Accept, Content-Type, Content-Length, Content-Encoding, Accept-Encoding, X-CSRF-Token, Authorization, Accept-Language, X-API-Key, X-Request-ID, X-Correlation-ID, traceparent, tracestate"
+	CORSEXPOSED string = "X-Request-ID, Retry-After"
+)
+
+// corsPolicy - read from the CORS_* settings
+type corsPolicy struct {
+	origins     []string
+	methods     string
+	headers     string
+	exposed     string
+	credentials bool
+	maxAge      int
+}
+
+func configCorsPolicy() *corsPolicy {
+	cfg := config.Get()
+	return &corsPolicy{
+		origins:     splitList(cfg.CorsAllowedOrigins, "*"),
+		methods:     strings.Join(splitList(cfg.CorsAllowedMethods, CORSMETHODS), ", "),
+		headers:     strings.Join(splitList(cfg.CorsAllowedHeaders, CORSHEADERS), ", "),
+		exposed:     strings.Join(splitList(cfg.CorsExposedHeaders, CORSEXPOSED), ", "),
+		credentials: cfg.CorsAllowCredentials,
+		maxAge:      cfg.CorsMaxAge,
+	}
+}
+
+// allowed - the Access-Control-Allow-Origin value for origin, empty when the origin is not allowed
+// entries are exact origins, "*" or a wildcard subdomain such as "https://*.example.com"
+// "*" matches no origin when credentials are allowed (rejected by ValidateEnvars), credentialed requests
+// are only allowed for the listed origins
+func (p *corsPolicy) allowed(origin string) string {
+	for _, o := range p.origins {
+		if o == "*" && p.credentials {
+			continue
+		}
+		match := o == "*" || strings.EqualFold(o, origin)
+		if prefix, suffix, ok := strings.Cut(o, "*."); ok && o != "*" {
+			match = strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, "."+suffix)
+		}
+		if !match {
+			continue
+		}
+		if o == "*" {
+			return "*"
+		}
+		return origin
+	}
+	return ""
+}
+
+// CorsMiddleware - the single place the cors headers are set, wraps the router so
+// preflight requests (OPTIONS with Access-Control-Request-Method) are answered without reaching a handler
+func CorsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p := configCorsPolicy()
+		allow := p.allowed(origin)
+		w.Header().Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			if allow == "" {
+				msg := "CorsMiddleware origin %s not allowed"
+				addHeaders(w, r)
+				b := responseErrorFormat(http.StatusForbidden, w, msg, origin)
+				fmt.Fprintf(w, "%s", string(b))
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", allow)
+			w.Header().Set("Access-Control-Allow-Methods", p.methods)
+			w.Header().Set("Access-Control-Allow-Headers", p.headers)
+			if p.credentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			if p.maxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(p.maxAge))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if allow != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allow)
+			w.Header().Set("Access-Control-Expose-Headers", p.exposed)
+			if p.credentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// splitList - comma separated setting, def when not set
+func splitList(value string, def string) []string {
+	if strings.TrimSpace(value) == "" {
+		value = def
+	}
+	var list []string
+	for _, i := range strings.Split(value, ",") {
+		if i = strings.TrimSpace(i); i != "" {
+			list = append(list, i)
+		}
+	}
+	return list
+}
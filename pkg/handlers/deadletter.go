@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/connectors"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/pipeline"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+)
+
+// ListDeadLettersHandler - admin handler that lists all captured dead letters
+func ListDeadLettersHandler(w http.ResponseWriter, r *http.Request, con connectors.Clients) {
+	con = con.WithContext(r.Context())
+	addHeaders(w, r)
+	dls, err := con.ListDeadLetters(r.Context())
+	if err != nil {
+		msg := "ListDeadLettersHandler %v"
+		con.Error(msg, err)
+		b := responseErrorFormat(http.StatusInternalServerError, w, msg, err)
+		fmt.Fprintf(w, "%s", string(b))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	b, _ := json.MarshalIndent(dls, "", "	")
+	fmt.Fprintf(w, "%s", string(b))
+}
+
+// GetDeadLetterHandler - admin handler to inspect a single dead letter
+func GetDeadLetterHandler(w http.ResponseWriter, r *http.Request, con connectors.Clients) {
+	con = con.WithContext(r.Context())
+	addHeaders(w, r)
+	dl, ok := lookupDeadLetter(w, r, con, "GetDeadLetterHandler")
+	if !ok {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	b, _ := json.MarshalIndent(dl, "", "	")
+	fmt.Fprintf(w, "%s", string(b))
+}
+
+// RedriveDeadLetterHandler - admin handler that re-publishes a dead letter
+// the entry is removed from the store only when the re-drive succeeds
+func RedriveDeadLetterHandler(w http.ResponseWriter, r *http.Request, con connectors.Clients) {
+	con = con.WithContext(r.Context())
+	addHeaders(w, r)
+	dl, ok := lookupDeadLetter(w, r, con, "RedriveDeadLetterHandler")
+	if !ok {
+		return
+	}
+
+	meta, stage, err := pipeline.Process(r.Context(), con, []byte(dl.Body), http.Header(dl.Headers))
+	if err != nil {
+		msg := "RedriveDeadLetterHandler %s failed at stage " + stage + " : %v"
+		con.Error(msg, dl.ID, err)
+		retryAfter(w, err)
+		b := responseErrorDetails(stageStatus(stage), w, errorDetails(err), msg, dl.ID, err)
+		fmt.Fprintf(w, "%s", string(b))
+		return
+	}
+
+	if err := con.RemoveDeadLetter(r.Context(), dl.ID); err != nil {
+		con.Error("RedriveDeadLetterHandler could not remove %s %v", dl.ID, err)
+	}
+
+	msg := "RedriveDeadLetterHandler re-driven successfully " + dl.ID
+	con.Info(msg)
+	response := &schema.Response{Name: config.Get().Name, StatusCode: "200", Status: "OK", Message: msg, Payload: meta}
+	w.WriteHeader(http.StatusOK)
+	b, _ := json.MarshalIndent(response, "", "	")
+	fmt.Fprintf(w, "%s", string(b))
+}
+
+// lookupDeadLetter - private utility, writes the error response when the id can't be found
+func lookupDeadLetter(w http.ResponseWriter, r *http.Request, con connectors.Clients, name string) (*schema.DeadLetter, bool) {
+	id := mux.Vars(r)["id"]
+	dl, err := con.GetDeadLetter(r.Context(), id)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, connectors.ErrDeadLetterNotFound) {
+			code = http.StatusNotFound
+		}
+		msg := name + " %s %v"
+		con.Error(msg, id, err)
+		b := responseErrorFormat(code, w, msg, id, err)
+		fmt.Fprintf(w, "%s", string(b))
+		return nil, false
+	}
+	return dl, true
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/connectors"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/encoders"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/fieldcrypt"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/ratelimit"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/routes"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/templates"
+)
+
+const (
+	// a missed change notification is picked up by comparing versions at this interval
+	DYNAMICRESYNC time.Duration = 30 * time.Second
+)
+
+var (
+	dynamicMutex sync.RWMutex
+	// the last dynamic config read from redis, used while redis reads fail
+	dynamicCache *schema.DynamicConfig
+)
+
+// DynamicVersion - the version of the dynamic config in use (0 when there is none)
+func DynamicVersion() int64 {
+	dynamicMutex.RLock()
+	defer dynamicMutex.RUnlock()
+	return versionOrZero(dynamicCache)
+}
+
+// RefreshDynamicConfig - re-applies the active configuration with the latest dynamic config
+func RefreshDynamicConfig(ctx context.Context, con connectors.Clients) (int64, error) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	if _, err := applyConfig(ctx, con, config.Get()); err != nil {
+		return 0, err
+	}
+	return DynamicVersion(), nil
+}
+
+// WatchDynamicConfig - refreshes on every change notification and whenever the redis version
+// differs from the one in use (checked every DYNAMICRESYNC), until ctx is done
+func WatchDynamicConfig(ctx context.Context, con connectors.Clients) {
+	changes, err := con.SubscribeDynamicConfig(ctx)
+	if err != nil {
+		con.Error("WatchDynamicConfig subscribe %v", err)
+	}
+	ticker := time.NewTicker(DYNAMICRESYNC)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v, ok := <-changes:
+			if !ok {
+				changes = nil
+				continue
+			}
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil && n == DynamicVersion() {
+				continue
+			}
+		case <-ticker.C:
+			dc, err := con.GetDynamicConfig(ctx)
+			if err != nil {
+				con.Error("WatchDynamicConfig keeping version %d : %v", DynamicVersion(), err)
+				continue
+			}
+			if dc.Version == DynamicVersion() {
+				continue
+			}
+		}
+		version, err := RefreshDynamicConfig(ctx, con)
+		if err != nil {
+			con.Error("WatchDynamicConfig refresh failed, keeping version %d : %v", DynamicVersion(), err)
+			continue
+		}
+		con.Info("WatchDynamicConfig applied dynamic config version %d", version)
+	}
+}
+
+// GetDynamicConfigHandler - returns the dynamic config held in redis
+func GetDynamicConfigHandler(w http.ResponseWriter, r *http.Request, con connectors.Clients) {
+	con = con.WithContext(r.Context())
+	addHeaders(w, r)
+	dc, err := con.GetDynamicConfig(r.Context())
+	if err != nil {
+		msg := "GetDynamicConfigHandler %v"
+		con.Error(msg, err)
+		b := responseErrorFormat(http.StatusServiceUnavailable, w, msg, err)
+		fmt.Fprintf(w, "%s", string(b))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	b, _ := json.MarshalIndent(dc, "", "	")
+	fmt.Fprintf(w, "%s", string(b))
+}
+
+// PutDynamicConfigHandler - stores the request body as the template, rate limit, route or policy of the topic
+// (DELETE removes it), every replica is notified and this one is refreshed straight away
+func PutDynamicConfigHandler(w http.ResponseWriter, r *http.Request, con connectors.Clients) {
+	con = con.WithContext(r.Context())
+	addHeaders(w, r)
+	vars := mux.Vars(r)
+	kind, topic := vars["kind"], vars["topic"]
+	switch kind {
+	case connectors.DYNAMICTEMPLATES, connectors.DYNAMICLIMITS, connectors.DYNAMICROUTES, connectors.DYNAMICPOLICIES:
+	default:
+		msg := "PutDynamicConfigHandler unknown config %q (templates, ratelimits, routes or policies)"
+		b := responseErrorFormat(http.StatusNotFound, w, msg, kind)
+		fmt.Fprintf(w, "%s", string(b))
+		return
+	}
+	var value string
+	if r.Method != http.MethodDelete {
+		body, err := io.ReadAll(r.Body)
+		if err == nil {
+			err = validDynamic(kind, topic, body)
+		}
+		if err != nil {
+			msg := "PutDynamicConfigHandler %s %s %v"
+			con.Error(msg, kind, topic, err)
+			b := responseErrorFormat(http.StatusBadRequest, w, msg, kind, topic, err)
+			fmt.Fprintf(w, "%s", string(b))
+			return
+		}
+		value = string(body)
+	}
+	version, err := con.PutDynamicConfig(r.Context(), kind, topic, value)
+	if err != nil {
+		msg := "PutDynamicConfigHandler %v"
+		con.Error(msg, err)
+		b := responseErrorFormat(http.StatusServiceUnavailable, w, msg, err)
+		fmt.Fprintf(w, "%s", string(b))
+		return
+	}
+	if _, err := RefreshDynamicConfig(r.Context(), con); err != nil {
+		con.Error("PutDynamicConfigHandler refresh %v", err)
+	}
+	msg := fmt.Sprintf("PutDynamicConfigHandler %s %s stored, dynamic config version %d", kind, topic, version)
+	con.Info(msg)
+	response := &schema.Response{Name: config.Get().Name, StatusCode: "200", Status: "OK", Message: msg}
+	w.WriteHeader(http.StatusOK)
+	b, _ := json.MarshalIndent(response, "", "	")
+	fmt.Fprintf(w, "%s", string(b))
+}
+
+// dynamicConfig - the latest dynamic config from redis, the cached copy when the read fails
+// nil (the file config only) when DYNAMIC_CONFIG is not set or nothing was read yet
+func dynamicConfig(ctx context.Context, con connectors.Clients, cfg *config.Config) *schema.DynamicConfig {
+	if cfg.DynamicConfig == "" {
+		return nil
+	}
+	dc, err := con.GetDynamicConfig(ctx)
+	if err != nil {
+		dynamicMutex.RLock()
+		defer dynamicMutex.RUnlock()
+		con.Error("dynamic config read failed, using the cached version %d : %v", versionOrZero(dynamicCache), err)
+		return dynamicCache
+	}
+	return dc
+}
+
+// topicPolicy - the per topic encoding and encrypted fields of the dynamic config (policies)
+type topicPolicy struct {
+	Encoding *encoders.Spec `json:"encoding,omitempty"`
+	Encrypt  []string       `json:"encrypt,omitempty"`
+}
+
+// overlayDynamic - applies the dynamic settings over the file config, invalid entries are skipped
+func overlayDynamic(con connectors.Clients, dc *schema.DynamicConfig, tpl *templates.Set, limits *ratelimit.Config, enc *encoders.Registry, crypt *fieldcrypt.Rules, table *routes.Table) {
+	for topic, src := range dc.Templates {
+		if err := tpl.Parse(topic, src); err != nil {
+			con.Error("dynamic config version %d skipping %v", dc.Version, err)
+		}
+	}
+	for topic, raw := range dc.RateLimits {
+		l, err := ratelimit.ParseLimit([]byte(raw))
+		if err != nil {
+			con.Error("dynamic config version %d skipping topic %s rate limit %v", dc.Version, topic, err)
+			continue
+		}
+		limits.SetTopic(topic, l)
+	}
+	for topic, raw := range dc.Routes {
+		channels, err := routes.Parse([]byte(raw))
+		if err != nil {
+			con.Error("dynamic config version %d skipping topic %s route %v", dc.Version, topic, err)
+			continue
+		}
+		table.Set(topic, channels)
+	}
+	for topic, raw := range dc.Policies {
+		p, err := parsePolicy([]byte(raw))
+		if err == nil && p.Encoding != nil {
+			err = enc.SetTopic(topic, p.Encoding)
+		}
+		if err == nil && len(p.Encrypt) > 0 {
+			err = crypt.SetTopic(topic, p.Encrypt)
+		}
+		if err != nil {
+			con.Error("dynamic config version %d skipping topic %s policy %v", dc.Version, topic, err)
+		}
+	}
+}
+
+func installDynamic(dc *schema.DynamicConfig) {
+	dynamicMutex.Lock()
+	dynamicCache = dc
+	dynamicMutex.Unlock()
+}
+
+func validDynamic(kind string, topic string, value []byte) error {
+	if len(value) == 0 {
+		return fmt.Errorf("empty value (use DELETE to remove it)")
+	}
+	var err error
+	switch kind {
+	case connectors.DYNAMICLIMITS:
+		_, err = ratelimit.ParseLimit(value)
+	case connectors.DYNAMICROUTES:
+		_, err = routes.Parse(value)
+	case connectors.DYNAMICPOLICIES:
+		var p *topicPolicy
+		if p, err = parsePolicy(value); err == nil && p.Encoding != nil {
+			_, err = encoders.New(p.Encoding)
+		}
+	default:
+		_, err = template.New(topic).Parse(string(value))
+	}
+	return err
+}
+
+// parsePolicy - a policy sets the encoding, the encrypted fields or both
+func parsePolicy(value []byte) (*topicPolicy, error) {
+	p := &topicPolicy{}
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(p); err != nil {
+		return nil, fmt.Errorf("policy %v", err)
+	}
+	if p.Encoding == nil && len(p.Encrypt) == 0 {
+		return nil, errors.New("policy sets neither encoding nor encrypt")
+	}
+	return p, nil
+}
+
+func versionOrZero(dc *schema.DynamicConfig) int64 {
+	if dc == nil {
+		return 0
+	}
+	return dc.Version
+}
@@ -4,15 +4,21 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"strconv"
-	"text/template"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
 	"github.com/lmzuccarelli/golang-redis-publisher/pkg/connectors"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/metrics"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/pipeline"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/ratelimit"
 	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/validator"
 )
 
 const (
@@ -21,9 +27,9 @@ const (
 )
 
 // SendPayloadHandler - api function handler that sends events to redis pub/sub bus
+// the http adapter of the publish pipeline (see pipeline.Process)
 func SendPayloadHandler(w http.ResponseWriter, r *http.Request, con connectors.Clients) {
-	var cp *schema.GenericSchema
-
+	con = con.WithContext(r.Context())
 	addHeaders(w, r)
 
 	// read the jwt token data in the body
@@ -33,6 +39,10 @@ func SendPayloadHandler(w http.ResponseWriter, r *http.Request, con connectors.C
 		r.Body = io.NopCloser(bytes.NewBufferString(""))
 	}
 	body, err := io.ReadAll(r.Body)
+	if err != nil && bodyError(w, "SendPayloadHandler", err) {
+		con.Error("SendPayloadHandler %v", err)
+		return
+	}
 	if err != nil {
 		msg := "Body data (JWT) error : access forbidden %v"
 		b := responseErrorFormat(http.StatusForbidden, w, msg, err)
@@ -40,16 +50,6 @@ func SendPayloadHandler(w http.ResponseWriter, r *http.Request, con connectors.C
 		return
 	}
 
-	// unmarshal result from mw backend
-	errs := json.Unmarshal(body, &cp)
-	if errs != nil {
-		msg := "SendPayloadHandler could not unmarshal input data to schema %v"
-		con.Error(msg, errs)
-		b := responseErrorFormat(http.StatusInternalServerError, w, msg, errs)
-		fmt.Fprintf(w, "%s", string(b))
-		return
-	}
-
 	// check the jwt token
 	//creds, err := verifyJwtToken(bp.JwtToken)
 	//if err != nil {
@@ -60,68 +60,107 @@ func SendPayloadHandler(w http.ResponseWriter, r *http.Request, con connectors.C
 	//	return
 	//}
 
-	//token := os.Getenv("TOKEN")
-	//url := os.Getenv("URL")
-	// check for the correct parameters. Do not check text for password resets
-	//msg := "SendPayloadHandler is not valid %s"
-	//con.Error(msg, "")
-	//b := responseErrorFormat(http.StatusInternalServerError, w, msg, "")
-	//fmt.Fprintf(w, "%s", string(b))
-	//return
-
-	// do some funky transforms ;)
-	//generic := &schema.GenericSchema{Request: cp}
-	payload := `{ "number":"{{ .Number }}", "email":"{{ .Email }}" }`
-
-	con.Trace("SendPayloadHandler new schema %v", cp.Request)
-	tmpl := template.New("publish")
-	//parse some content and generate a template
-	tmp, _ := tmpl.Parse(payload)
-	var tpl bytes.Buffer
-	err = tmp.Execute(&tpl, cp.Request)
+	meta, stage, err := pipeline.Process(r.Context(), con, body, r.Header)
 	if err != nil {
-		con.Error("SendPayloadHandler parse template %v", errs)
-		b := responseErrorFormat(http.StatusInternalServerError, w, " %v", errs)
-		fmt.Fprintf(w, "%s", string(b))
-		return
-	}
-
-	// now make the call to get all data
-	con.Trace("SendPayloadHandler payload %s", tpl.String())
-
-	err = con.Publish(context.Background(), os.Getenv("TOPIC"), tpl.String())
-	if err != nil {
-		con.Error("SendPayloadHandler publish request %v", errs)
-		b := responseErrorFormat(http.StatusInternalServerError, w, " %v", errs)
+		msg := "SendPayloadHandler %v"
+		// throttled payloads are rejected, not dead lettered (the producer retries)
+		if !retryAfter(w, err) {
+			con.Error(msg, err)
+			deadLetter(r.Context(), con, stage, err, body, r.Header)
+		}
+		b := responseErrorDetails(stageStatus(stage), w, errorDetails(err), msg, err)
 		fmt.Fprintf(w, "%s", string(b))
 		return
 	}
 
 	msg := "SendPayloadHandler published successfully"
 	con.Debug(msg+" %v", string(body))
-	response := &schema.Response{Name: os.Getenv("NAME"), StatusCode: "200", Status: "OK", Message: msg}
+	response := &schema.Response{Name: config.Get().Name, StatusCode: "200", Status: "OK", Message: msg, Payload: meta}
 	w.WriteHeader(http.StatusOK)
 	b, _ := json.MarshalIndent(response, "", "	")
 	fmt.Fprintf(w, "%s", string(b))
 }
 
+// Publish - runs the publish pipeline (see pipeline.Process) for callers other than SendPayloadHandler (websocket, grpc)
+// failures are logged and dead lettered (throttled payloads are only counted), the http status
+// SendPayloadHandler would respond with is returned along with the error
+func Publish(ctx context.Context, body []byte, header http.Header, con connectors.Clients) (*schema.SchemaInterface, int, error) {
+	meta, stage, err := pipeline.Process(ctx, con, body, header)
+	if err == nil {
+		return meta, http.StatusOK, nil
+	}
+	var lerr *ratelimit.LimitError
+	if errors.As(err, &lerr) {
+		metrics.Throttled.WithLabelValues(lerr.Scope).Inc()
+	} else {
+		con.Error("Publish %v", err)
+		deadLetter(ctx, con, stage, err, body, header)
+	}
+	return nil, stageStatus(stage), err
+}
+
+// stageStatus - client side failures (decode, validate, unmarshal) are reported as bad requests
+func stageStatus(stage string) int {
+	if stage == pipeline.STAGELIMIT {
+		return http.StatusTooManyRequests
+	}
+	if stage == pipeline.STAGEDECODE || stage == pipeline.STAGEVALIDATE || stage == pipeline.STAGEUNMARSHAL {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// errorDetails - the individual schema violations (if any) for the error response
+func errorDetails(err error) []string {
+	var perr *validator.PayloadError
+	if errors.As(err, &perr) {
+		return perr.Details
+	}
+	return nil
+}
+
+// credentialHeaders - never stored with a dead letter (the admin api serves the headers back)
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key"}
+
+// deadLetter - captures the failed payload so it can be inspected and re-driven
+func deadLetter(ctx context.Context, con connectors.Clients, stage string, err error, body []byte, headers http.Header) {
+	headers = headers.Clone()
+	for _, h := range credentialHeaders {
+		headers.Del(h)
+	}
+	dl := &schema.DeadLetter{
+		ID:        uuid.New().String(),
+		Stage:     stage,
+		Error:     err.Error(),
+		Body:      string(body),
+		Headers:   headers,
+		Timestamp: time.Now().UnixMilli(),
+	}
+	if e := con.PushDeadLetter(ctx, dl); e != nil {
+		con.Error("deadLetter could not store payload %v", e)
+		return
+	}
+	con.Debug("deadLetter stored %s (stage %s)", dl.ID, stage)
+}
+
 func IsAlive(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintf(w, "{ \"version\" : \""+os.Getenv("VERSION")+"\" , \"name\": \""+os.Getenv("NAME")+"\" }")
+	fmt.Fprintf(w, "{ \"version\" : \""+config.Get().Version+"\" , \"name\": \""+config.Get().Name+"\" , \"configversion\": \""+ConfigVersion()+"\" , \"dynamicversion\": "+strconv.FormatInt(DynamicVersion(), 10)+" }")
 }
 
-// headers (with cors) utility
+// headers utility (cors is handled by CorsMiddleware)
 func addHeaders(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set(CONTENTTYPE, APPLICATIONJSON)
-	// use this for cors
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-	w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
 }
 
 // responsFormat - utility function
 func responseErrorFormat(code int, w http.ResponseWriter, msg string, val ...interface{}) []byte {
+	return responseErrorDetails(code, w, nil, msg, val...)
+}
+
+// responseErrorDetails - utility function, as responseErrorFormat with a list of individual errors
+func responseErrorDetails(code int, w http.ResponseWriter, details []string, msg string, val ...interface{}) []byte {
 	var b []byte
-	response := &schema.Response{Name: os.Getenv("NAME"), StatusCode: strconv.Itoa(code), Status: "ERROR", Message: fmt.Sprintf(msg, val...)}
+	response := &schema.Response{Name: config.Get().Name, StatusCode: strconv.Itoa(code), Status: "ERROR", Message: fmt.Sprintf(msg, val...), Errors: details}
 	w.WriteHeader(code)
 	b, _ = json.MarshalIndent(response, "", "	")
 	return b
@@ -2,16 +2,45 @@ package handlers
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/klauspost/compress/zstd"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
 	"github.com/lmzuccarelli/golang-redis-publisher/pkg/connectors"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/encoders"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/metrics"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/pipeline"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/ratelimit"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/routes"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/templates"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/topics"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/tracing"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/validator"
 	"github.com/microlib/simple"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 type errReader int
@@ -71,7 +100,7 @@ func TestAllMiddleware(t *testing.T) {
 	})
 
 	t.Run("SendPayloadHandler : should fail (nil body)", func(t *testing.T) {
-		var STATUS int = 500
+		var STATUS int = 400
 		os.Setenv("TOKEN", "[{ \"id\": 1, \"name\": \"BX-01\", \"token\": \"1212121\"}]")
 		os.Setenv("TESTING", "false")
 		// We create a ResponseRecorder (which satisfies http.ResponseWriter) to record the response.
@@ -212,3 +241,1383 @@ func TestAllMiddleware(t *testing.T) {
 	})
 
 }
+
+func TestDeadLetters(t *testing.T) {
+
+	logger := &simple.Logger{Level: "trace"}
+	os.Setenv("TOPIC", "test")
+	conn := connectors.NewTestConnectors("", 200, logger)
+	mock := conn.(*connectors.MockConnectors)
+
+	t.Run("SendPayloadHandler : should dead letter (forced publish error)", func(t *testing.T) {
+		var STATUS int = 500
+		mock.Meta("true")
+		requestPayload := `{ "request":{"email":"abc.xyz.com", "number":"1234567"}}`
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/publish", bytes.NewBuffer([]byte(requestPayload)))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		req.Header.Set("X-API-Key", "abc")
+		req.Header.Set(pipeline.CORRELATIONID, "abc-123")
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			SendPayloadHandler(w, r, conn)
+		})
+		handler.ServeHTTP(rr, req)
+		mock.Meta("false")
+
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "SendPayloadHandler", rr.Code, STATUS))
+		}
+		if len(mock.DeadLetters) != 1 || mock.DeadLetters[0].Stage != pipeline.STAGEPUBLISH || mock.DeadLetters[0].Body != requestPayload {
+			t.Fatalf(fmt.Sprintf("Handler %s did not capture dead letter - got (%v)", "SendPayloadHandler", mock.DeadLetters))
+		}
+		headers := http.Header(mock.DeadLetters[0].Headers)
+		if headers.Get("Authorization") != "" || headers.Get("X-API-Key") != "" || headers.Get(pipeline.CORRELATIONID) != "abc-123" {
+			t.Errorf(fmt.Sprintf("Handler %s stored incorrect dead letter headers - got (%v)", "SendPayloadHandler", headers))
+		}
+	})
+
+	t.Run("SendPayloadHandler : should dead letter (unmarshal error)", func(t *testing.T) {
+		var STATUS int = 400
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/publish", bytes.NewBuffer([]byte(`{ "request": `)))
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			SendPayloadHandler(w, r, conn)
+		})
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "SendPayloadHandler", rr.Code, STATUS))
+		}
+		if len(mock.DeadLetters) != 2 || mock.DeadLetters[1].Stage != pipeline.STAGEUNMARSHAL {
+			t.Errorf(fmt.Sprintf("Handler %s did not capture dead letter - got (%v)", "SendPayloadHandler", mock.DeadLetters))
+		}
+	})
+
+	t.Run("ListDeadLettersHandler : should pass", func(t *testing.T) {
+		var STATUS int = 200
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/admin/deadletters", nil)
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ListDeadLettersHandler(w, r, conn)
+		})
+		handler.ServeHTTP(rr, req)
+
+		var dls []*schema.DeadLetter
+		if e := json.Unmarshal(rr.Body.Bytes(), &dls); e != nil || len(dls) != 2 {
+			t.Errorf(fmt.Sprintf("Handler %s returned incorrect list - got (%s) error (%v)", "ListDeadLettersHandler", rr.Body.String(), e))
+		}
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "ListDeadLettersHandler", rr.Code, STATUS))
+		}
+	})
+
+	t.Run("GetDeadLetterHandler : should fail (not found)", func(t *testing.T) {
+		var STATUS int = 404
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/admin/deadletters/unknown", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "unknown"})
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			GetDeadLetterHandler(w, r, conn)
+		})
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "GetDeadLetterHandler", rr.Code, STATUS))
+		}
+	})
+
+	t.Run("RedriveDeadLetterHandler : should pass", func(t *testing.T) {
+		var STATUS int = 200
+		id := mock.DeadLetters[0].ID
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/admin/deadletters/"+id+"/redrive", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			RedriveDeadLetterHandler(w, r, conn)
+		})
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "RedriveDeadLetterHandler", rr.Code, STATUS))
+		}
+		if len(mock.DeadLetters) != 1 {
+			t.Errorf(fmt.Sprintf("Handler %s did not remove re-driven entry - got (%d) entries", "RedriveDeadLetterHandler", len(mock.DeadLetters)))
+		}
+	})
+
+	t.Run("RedriveDeadLetterHandler : should fail (unmarshal error)", func(t *testing.T) {
+		var STATUS int = 400
+		id := mock.DeadLetters[0].ID
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/admin/deadletters/"+id+"/redrive", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			RedriveDeadLetterHandler(w, r, conn)
+		})
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "RedriveDeadLetterHandler", rr.Code, STATUS))
+		}
+		if len(mock.DeadLetters) != 1 {
+			t.Errorf(fmt.Sprintf("Handler %s should keep failed entry - got (%d) entries", "RedriveDeadLetterHandler", len(mock.DeadLetters)))
+		}
+	})
+}
+
+func TestEnvelope(t *testing.T) {
+
+	logger := &simple.Logger{Level: "trace"}
+	os.Setenv("TOPIC", "test")
+	os.Setenv("NAME", "golang-redis-publisher")
+	os.Setenv("VERSION", "1.0.1")
+	os.Setenv("ENVELOPE", "true")
+	defer os.Setenv("ENVELOPE", "false")
+
+	t.Run("SendPayloadHandler : should pass (envelope)", func(t *testing.T) {
+		var STATUS int = 200
+		conn := connectors.NewTestConnectors("", STATUS, logger)
+		requestPayload := `{ "request":{"email":"abc.xyz.com", "number":"1234567"}}`
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/publish", bytes.NewBuffer([]byte(requestPayload)))
+		req.Header.Set(pipeline.CORRELATIONID, "abc-123")
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			SendPayloadHandler(w, r, conn)
+		})
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "SendPayloadHandler", rr.Code, STATUS))
+		}
+		var response schema.Response
+		_ = json.Unmarshal(rr.Body.Bytes(), &response)
+		var env schema.Envelope
+		published := conn.(*connectors.MockConnectors).Published
+		if e := json.Unmarshal(published[0].([]byte), &env); e != nil {
+			t.Fatalf("Should not fail : found error %v", e)
+		}
+		if response.Payload == nil || response.Payload.ID != env.ID || env.ID == 0 {
+			t.Errorf(fmt.Sprintf("Handler %s message id not echoed - got (%v) wanted (%d)", "SendPayloadHandler", response.Payload, env.ID))
+		}
+		if env.CorrelationID != "abc-123" || env.Source != "golang-redis-publisher" || env.Topic != "test" || string(env.Data) != `{"number":"1234567","email":"abc.xyz.com"}` {
+			t.Errorf(fmt.Sprintf("Handler %s envelope incorrect - got (%s)", "SendPayloadHandler", published[0]))
+		}
+	})
+}
+
+func TestCloudEvents(t *testing.T) {
+
+	logger := &simple.Logger{Level: "trace"}
+	os.Setenv("TOPIC", "test")
+
+	t.Run("SendPayloadHandler : should pass (structured cloudevent)", func(t *testing.T) {
+		var STATUS int = 200
+		conn := connectors.NewTestConnectors("", STATUS, logger)
+		requestPayload := `{ "specversion":"1.0", "id":"ce-1", "source":"/test", "type":"com.example.customer", "traceparent":"00-abc", "data":{ "request":{"email":"abc.xyz.com", "number":"1234567"}}}`
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/publish", bytes.NewBuffer([]byte(requestPayload)))
+		req.Header.Set(CONTENTTYPE, pipeline.CLOUDEVENTSJSON+"; charset=utf-8")
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			SendPayloadHandler(w, r, conn)
+		})
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "SendPayloadHandler", rr.Code, STATUS))
+		}
+		var ce schema.CloudEvent
+		published := conn.(*connectors.MockConnectors).Published
+		if e := json.Unmarshal(published[0].([]byte), &ce); e != nil {
+			t.Fatalf("Should not fail : found error %v", e)
+		}
+		if ce.ID != "ce-1" || ce.Extensions["traceparent"] != "00-abc" || string(ce.Data) != `{"number":"1234567","email":"abc.xyz.com"}` {
+			t.Errorf(fmt.Sprintf("Handler %s published incorrect cloudevent - got (%s)", "SendPayloadHandler", published[0]))
+		}
+	})
+
+	t.Run("SendPayloadHandler : should pass (binary cloudevent routed by type)", func(t *testing.T) {
+		var STATUS int = 200
+		os.Setenv("CE_TYPE_TOPIC", "true")
+		defer os.Setenv("CE_TYPE_TOPIC", "false")
+		os.Setenv("TOPIC_NAMESPACE", "com.example.*")
+		defer os.Unsetenv("TOPIC_NAMESPACE")
+		conn := connectors.NewTestConnectors("", STATUS, logger)
+		requestPayload := `{ "request":{"email":"abc.xyz.com", "number":"1234567"}}`
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/publish", bytes.NewBuffer([]byte(requestPayload)))
+		req.Header.Set(CONTENTTYPE, APPLICATIONJSON)
+		req.Header.Set("ce-specversion", "1.0")
+		req.Header.Set("ce-id", "ce-2")
+		req.Header.Set("ce-source", "/test")
+		req.Header.Set("ce-type", "com.example.customer")
+		req.Header.Set("ce-tenant", "acme")
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			SendPayloadHandler(w, r, conn)
+		})
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "SendPayloadHandler", rr.Code, STATUS))
+		}
+		var ce schema.CloudEvent
+		published := conn.(*connectors.MockConnectors).Published
+		if e := json.Unmarshal(published[0].([]byte), &ce); e != nil {
+			t.Fatalf("Should not fail : found error %v", e)
+		}
+		if ce.ID != "ce-2" || ce.Type != "com.example.customer" || ce.Extensions["tenant"] != "acme" {
+			t.Errorf(fmt.Sprintf("Handler %s published incorrect cloudevent - got (%s)", "SendPayloadHandler", published[0]))
+		}
+	})
+
+	t.Run("SendPayloadHandler : should fail (missing cloudevent attributes)", func(t *testing.T) {
+		var STATUS int = 400
+		conn := connectors.NewTestConnectors("", STATUS, logger)
+		requestPayload := `{ "specversion":"0.3", "source":"/test", "data":{}}`
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/publish", bytes.NewBuffer([]byte(requestPayload)))
+		req.Header.Set(CONTENTTYPE, pipeline.CLOUDEVENTSJSON)
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			SendPayloadHandler(w, r, conn)
+		})
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "SendPayloadHandler", rr.Code, STATUS))
+		}
+		if len(conn.(*connectors.MockConnectors).Published) != 0 {
+			t.Errorf(fmt.Sprintf("Handler %s should not publish an invalid cloudevent", "SendPayloadHandler"))
+		}
+	})
+}
+
+func TestSchemaValidation(t *testing.T) {
+
+	logger := &simple.Logger{Level: "trace"}
+	os.Setenv("TOPIC", "test")
+	if err := validator.LoadSchemas("../../tests/schemas.json"); err != nil {
+		t.Fatalf("Should not fail : found error %v", err)
+	}
+	defer validator.LoadSchemas("")
+
+	t.Run("SendPayloadHandler : should fail (schema violation)", func(t *testing.T) {
+		var STATUS int = 400
+		conn := connectors.NewTestConnectors("", STATUS, logger)
+		requestPayload := `{ "request":{"email":"abc.xyz.com"}}`
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/publish", bytes.NewBuffer([]byte(requestPayload)))
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			SendPayloadHandler(w, r, conn)
+		})
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "SendPayloadHandler", rr.Code, STATUS))
+		}
+		var response schema.Response
+		_ = json.Unmarshal(rr.Body.Bytes(), &response)
+		if len(response.Errors) != 2 {
+			t.Errorf(fmt.Sprintf("Handler %s returned incorrect error details - got (%v)", "SendPayloadHandler", response.Errors))
+		}
+		if len(conn.(*connectors.MockConnectors).Published) != 0 {
+			t.Errorf(fmt.Sprintf("Handler %s should not publish an invalid payload", "SendPayloadHandler"))
+		}
+	})
+
+	t.Run("SendPayloadHandler : should fail (wrongly typed field)", func(t *testing.T) {
+		var STATUS int = 400
+		conn := connectors.NewTestConnectors("", STATUS, logger)
+		requestPayload := `{ "request":{"email":"abc@xyz.com", "number":1234567}}`
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/publish", bytes.NewBuffer([]byte(requestPayload)))
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			SendPayloadHandler(w, r, conn)
+		})
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "SendPayloadHandler", rr.Code, STATUS))
+		}
+		var response schema.Response
+		_ = json.Unmarshal(rr.Body.Bytes(), &response)
+		if len(response.Errors) != 1 || !strings.Contains(response.Errors[0], "/request/number") {
+			t.Errorf(fmt.Sprintf("Handler %s returned incorrect error details - got (%v)", "SendPayloadHandler", response.Errors))
+		}
+	})
+
+	t.Run("SendPayloadHandler : should fail (wrongly typed field, no schema)", func(t *testing.T) {
+		var STATUS int = 400
+		os.Setenv("TOPIC", "noschema")
+		defer os.Setenv("TOPIC", "test")
+		conn := connectors.NewTestConnectors("", STATUS, logger)
+		requestPayload := `{ "request":{"email":"abc@xyz.com", "number":1234567}}`
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/publish", bytes.NewBuffer([]byte(requestPayload)))
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			SendPayloadHandler(w, r, conn)
+		})
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "SendPayloadHandler", rr.Code, STATUS))
+		}
+	})
+
+	t.Run("SendPayloadHandler : should fail (output schema violation)", func(t *testing.T) {
+		var STATUS int = 500
+		os.Setenv("TOPIC", "output")
+		defer os.Setenv("TOPIC", "test")
+		conn := connectors.NewTestConnectors("", STATUS, logger)
+		requestPayload := `{ "request":{"email":"abc@xyz.com"}}`
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/publish", bytes.NewBuffer([]byte(requestPayload)))
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			SendPayloadHandler(w, r, conn)
+		})
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "SendPayloadHandler", rr.Code, STATUS))
+		}
+		mock := conn.(*connectors.MockConnectors)
+		if len(mock.Published) != 0 || len(mock.DeadLetters) != 1 || mock.DeadLetters[0].Stage != pipeline.STAGEOUTPUT {
+			t.Errorf(fmt.Sprintf("Handler %s should dead letter and not publish - got (%v)", "SendPayloadHandler", mock.DeadLetters))
+		}
+		if testutil.ToFloat64(metrics.OutputViolations.WithLabelValues("output")) != 1 {
+			t.Errorf(fmt.Sprintf("Handler %s did not count the violation", "SendPayloadHandler"))
+		}
+	})
+
+	t.Run("SendPayloadHandler : should pass (schema)", func(t *testing.T) {
+		var STATUS int = 200
+		conn := connectors.NewTestConnectors("", STATUS, logger)
+		requestPayload := `{ "request":{"email":"abc@xyz.com", "number":"1234567"}}`
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/publish", bytes.NewBuffer([]byte(requestPayload)))
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			SendPayloadHandler(w, r, conn)
+		})
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "SendPayloadHandler", rr.Code, STATUS))
+		}
+	})
+}
+
+func TestSchemaRegistry(t *testing.T) {
+
+	logger := &simple.Logger{Level: "trace"}
+	os.Setenv("TOPIC", "registry")
+	os.Setenv("ENVELOPE", "true")
+	defer os.Setenv("ENVELOPE", "false")
+	defer validator.LoadSchemas("")
+	conn := connectors.NewTestConnectors("", 200, logger)
+
+	register := func(body string, query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/admin/schemas/registry/versions"+query, bytes.NewBuffer([]byte(body)))
+		req = mux.SetURLVars(req, map[string]string{"topic": "registry"})
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			RegisterSchemaHandler(w, r, conn)
+		}).ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("RegisterSchemaHandler : should pass", func(t *testing.T) {
+		var STATUS int = 201
+		rr := register(`{ "type":"object", "properties": { "request": { "type":"object", "required":["number"] } } }`, "")
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "RegisterSchemaHandler", rr.Code, STATUS))
+		}
+		rr = register(`{ "type":"object", "properties": { "request": { "type":"object", "required":["number"] }, "meta": { "type":"string" } } }`, "")
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "RegisterSchemaHandler", rr.Code, STATUS))
+		}
+	})
+
+	t.Run("RegisterSchemaHandler : should fail (incompatible)", func(t *testing.T) {
+		var STATUS int = 409
+		rr := register(`{ "type":"object", "required":["meta"], "properties": { "request": { "type":"object", "required":["number"] }, "meta": { "type":"string" } } }`, "?compatibility=backward")
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "RegisterSchemaHandler", rr.Code, STATUS))
+		}
+	})
+
+	t.Run("RegisterSchemaHandler : should fail (invalid schema)", func(t *testing.T) {
+		var STATUS int = 400
+		rr := register(`{ "type": 12 }`, "")
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "RegisterSchemaHandler", rr.Code, STATUS))
+		}
+	})
+
+	t.Run("GetSchemaVersionHandler : should pass (latest)", func(t *testing.T) {
+		var STATUS int = 200
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/admin/schemas/registry/versions/latest", nil)
+		req = mux.SetURLVars(req, map[string]string{"topic": "registry", "version": "latest"})
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			GetSchemaVersionHandler(w, r, conn)
+		}).ServeHTTP(rr, req)
+		var sv schema.SchemaVersion
+		_ = json.Unmarshal(rr.Body.Bytes(), &sv)
+		if rr.Code != STATUS || sv.Version != 2 {
+			t.Errorf(fmt.Sprintf("Handler %s returned incorrect version - got (%d) (%d)", "GetSchemaVersionHandler", rr.Code, sv.Version))
+		}
+	})
+
+	t.Run("ListSchemaVersionsHandler : should pass", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/admin/schemas/registry/versions", nil)
+		req = mux.SetURLVars(req, map[string]string{"topic": "registry"})
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ListSchemaVersionsHandler(w, r, conn)
+		}).ServeHTTP(rr, req)
+		var versions []*schema.SchemaVersion
+		_ = json.Unmarshal(rr.Body.Bytes(), &versions)
+		if len(versions) != 2 {
+			t.Errorf(fmt.Sprintf("Handler %s returned incorrect versions - got (%s)", "ListSchemaVersionsHandler", rr.Body.String()))
+		}
+	})
+
+	t.Run("SendPayloadHandler : should pass (schema version stamped)", func(t *testing.T) {
+		var STATUS int = 200
+		conn.(*connectors.MockConnectors).Published = nil
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/publish", bytes.NewBuffer([]byte(`{ "request":{"email":"abc@xyz.com", "number":"1234567"}}`)))
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			SendPayloadHandler(w, r, conn)
+		}).ServeHTTP(rr, req)
+		if rr.Code != STATUS {
+			t.Fatalf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "SendPayloadHandler", rr.Code, STATUS))
+		}
+		var env schema.Envelope
+		_ = json.Unmarshal(conn.(*connectors.MockConnectors).Published[0].([]byte), &env)
+		if env.SchemaVersion != "2" {
+			t.Errorf(fmt.Sprintf("Handler %s stamped incorrect schema version - got (%s) wanted (%s)", "SendPayloadHandler", env.SchemaVersion, "2"))
+		}
+	})
+
+	t.Run("SendPayloadHandler : should fail (registered schema)", func(t *testing.T) {
+		var STATUS int = 400
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/publish", bytes.NewBuffer([]byte(`{ "request":{"email":"abc@xyz.com"}}`)))
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			SendPayloadHandler(w, r, conn)
+		}).ServeHTTP(rr, req)
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "SendPayloadHandler", rr.Code, STATUS))
+		}
+	})
+}
+
+func TestRequestId(t *testing.T) {
+
+	logger := &simple.Logger{Level: "info"}
+	conn := connectors.NewTestConnectors("", 200, logger)
+	os.Setenv("ENVELOPE", "true")
+	defer os.Unsetenv("ENVELOPE")
+	h := RequestIdMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SendPayloadHandler(w, r, conn)
+	}))
+
+	t.Run("RequestIdMiddleware : should pass (header propagated)", func(t *testing.T) {
+		conn.(*connectors.MockConnectors).Published = nil
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/publish", bytes.NewBuffer([]byte(`{ "request":{"email":"abc@xyz.com", "number":"1234567"}}`)))
+		req.Header.Set(REQUESTIDHEADER, "req-42")
+		h.ServeHTTP(rr, req)
+		if rr.Header().Get(REQUESTIDHEADER) != "req-42" {
+			t.Errorf(fmt.Sprintf("Handler %s returned incorrect request id - got (%s) wanted (%s)", "RequestIdMiddleware", rr.Header().Get(REQUESTIDHEADER), "req-42"))
+		}
+		var env schema.Envelope
+		_ = json.Unmarshal(conn.(*connectors.MockConnectors).Published[0].([]byte), &env)
+		if env.RequestID != "req-42" {
+			t.Errorf(fmt.Sprintf("Handler %s stamped incorrect request id - got (%s) wanted (%s)", "SendPayloadHandler", env.RequestID, "req-42"))
+		}
+	})
+
+	t.Run("RequestIdMiddleware : should pass (generated)", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/publish", bytes.NewBuffer([]byte(`{ "request":{"email":"abc@xyz.com", "number":"1234567"}}`)))
+		req.Header.Set(REQUESTIDHEADER, "bad id\n")
+		h.ServeHTTP(rr, req)
+		if id := rr.Header().Get(REQUESTIDHEADER); id == "" || id == "bad id\n" {
+			t.Errorf(fmt.Sprintf("Handler %s should generate a request id - got (%s)", "RequestIdMiddleware", id))
+		}
+	})
+}
+
+func TestPublishMetrics(t *testing.T) {
+
+	logger := &simple.Logger{Level: "info"}
+	os.Setenv("TOPIC", "metrics")
+	defer os.Setenv("TOPIC", "test")
+
+	t.Run("SendPayloadHandler : should pass (success counted)", func(t *testing.T) {
+		conn := connectors.NewTestConnectors("", 200, logger)
+		before := testutil.ToFloat64(metrics.Publishes.WithLabelValues("metrics", metrics.OUTCOMESUCCESS))
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/publish", bytes.NewBuffer([]byte(`{ "request":{"email":"abc@xyz.com", "number":"1234567"}}`)))
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			SendPayloadHandler(w, r, conn)
+		}).ServeHTTP(rr, req)
+		if got := testutil.ToFloat64(metrics.Publishes.WithLabelValues("metrics", metrics.OUTCOMESUCCESS)); got != before+1 {
+			t.Errorf(fmt.Sprintf("Handler %s counted incorrect publishes - got (%v) wanted (%v)", "SendPayloadHandler", got, before+1))
+		}
+	})
+
+	t.Run("SendPayloadHandler : should pass (failed stage counted)", func(t *testing.T) {
+		conn := connectors.NewTestConnectors("", 200, logger)
+		conn.(*connectors.MockConnectors).Meta("true")
+		before := testutil.ToFloat64(metrics.Publishes.WithLabelValues("metrics", pipeline.STAGEPUBLISH))
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/publish", bytes.NewBuffer([]byte(`{ "request":{"email":"abc@xyz.com", "number":"1234567"}}`)))
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			SendPayloadHandler(w, r, conn)
+		}).ServeHTTP(rr, req)
+		if got := testutil.ToFloat64(metrics.Publishes.WithLabelValues("metrics", pipeline.STAGEPUBLISH)); got != before+1 {
+			t.Errorf(fmt.Sprintf("Handler %s counted incorrect failures - got (%v) wanted (%v)", "SendPayloadHandler", got, before+1))
+		}
+		if testutil.CollectAndCount(metrics.TemplateDuration) == 0 {
+			t.Errorf(fmt.Sprintf("Handler %s did not observe the template duration", "SendPayloadHandler"))
+		}
+	})
+}
+
+func TestTracing(t *testing.T) {
+
+	logger := &simple.Logger{Level: "info"}
+	conn := connectors.NewTestConnectors("", 200, logger)
+	os.Setenv("TOPIC", "test")
+	os.Setenv("ENVELOPE", "true")
+	defer os.Unsetenv("ENVELOPE")
+	_, _ = tracing.Init(context.Background())
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	t.Run("SendPayloadHandler : should pass (traceparent continued)", func(t *testing.T) {
+		conn.(*connectors.MockConnectors).Published = nil
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/publish", bytes.NewBuffer([]byte(`{ "request":{"email":"abc@xyz.com", "number":"1234567"}}`)))
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, span := tracing.StartServer(r, "/api/v1/publish")
+			SendPayloadHandler(w, r.WithContext(ctx), conn)
+			span.End()
+		}).ServeHTTP(rr, req)
+		var env schema.Envelope
+		_ = json.Unmarshal(conn.(*connectors.MockConnectors).Published[0].([]byte), &env)
+		if !strings.HasPrefix(env.TraceParent, "00-4bf92f3577b34da6a3ce929d0e0e4736-") {
+			t.Errorf(fmt.Sprintf("Handler %s injected incorrect traceparent - got (%s)", "SendPayloadHandler", env.TraceParent))
+		}
+		var names []string
+		for _, s := range recorder.Ended() {
+			names = append(names, s.Name())
+		}
+		want := "decode,validate,enrich,transform,encode,route,publish,POST /api/v1/publish"
+		if strings.Join(names, ",") != want {
+			t.Errorf(fmt.Sprintf("Handler %s recorded incorrect spans - got (%s) wanted (%s)", "SendPayloadHandler", strings.Join(names, ","), want))
+		}
+	})
+
+	t.Run("SendPayloadHandler : should fail (error type recorded without payload values)", func(t *testing.T) {
+		if err := validator.LoadSchemas("../../tests/schemas.json"); err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned error %v", "LoadSchemas", err))
+		}
+		defer validator.LoadSchemas("")
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/publish", bytes.NewBuffer([]byte(`{ "request":{"email":"abc.xyz.com"}}`)))
+		SendPayloadHandler(rr, req, conn)
+		ended := recorder.Ended()
+		var validate sdktrace.ReadOnlySpan
+		for _, s := range ended {
+			if s.Name() == "validate" {
+				validate = s
+			}
+		}
+		if validate == nil || validate.Status().Description != "*validator.PayloadError" {
+			t.Fatalf(fmt.Sprintf("Handler %s recorded incorrect validate span", "SendPayloadHandler"))
+		}
+		b, _ := json.Marshal(validate.Events())
+		if strings.Contains(string(b), "abc.xyz.com") || !strings.Contains(string(b), "PayloadError") {
+			t.Errorf(fmt.Sprintf("Handler %s exported the error message - got (%s)", "SendPayloadHandler", b))
+		}
+	})
+
+	t.Run("EndServer : should pass (response status recorded, only 5xx failed)", func(t *testing.T) {
+		for _, STATUS := range []int{200, 404, 500} {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/api/v1/status", nil)
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx, span := tracing.StartServer(r, "/api/v1/status")
+				sw := tracing.NewStatusWriter(w)
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if STATUS != 200 {
+						w.WriteHeader(STATUS)
+					}
+					fmt.Fprintf(w, "%s", "{}")
+					w.(http.Flusher).Flush()
+				}).ServeHTTP(sw, r.WithContext(ctx))
+				tracing.EndServer(span, sw.Status)
+			}).ServeHTTP(rr, req)
+			ended := recorder.Ended()
+			span := ended[len(ended)-1]
+			var code int64
+			for _, a := range span.Attributes() {
+				if a.Key == "http.status_code" {
+					code = a.Value.AsInt64()
+				}
+			}
+			if code != int64(STATUS) || !rr.Flushed {
+				t.Errorf(fmt.Sprintf("Handler %s recorded incorrect status - got (%d) wanted (%d)", "EndServer", code, STATUS))
+			}
+			if failed := span.Status().Code == otelcodes.Error; failed != (STATUS >= 500) {
+				t.Errorf(fmt.Sprintf("Handler %s recorded incorrect span status for %d - got (%v)", "EndServer", STATUS, span.Status().Code))
+			}
+		}
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+
+	logger := &simple.Logger{Level: "info"}
+	conn := connectors.NewTestConnectors("", 200, logger)
+	if err := ratelimit.Load("../../tests/ratelimit.json"); err != nil {
+		t.Fatalf(fmt.Sprintf("Function %s returned error %v", "Load", err))
+	}
+	defer ratelimit.Load("")
+	h := RateLimitMiddleware(conn)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SendPayloadHandler(w, r, conn)
+	}))
+
+	t.Run("RateLimitMiddleware : should fail (client throttled)", func(t *testing.T) {
+		var STATUS int = 429
+		codes := []int{}
+		for i := 0; i < 3; i++ {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/api/v1/publish", bytes.NewBuffer([]byte(`{ "request":{"email":"abc@xyz.com", "number":"1234567"}}`)))
+			req.RemoteAddr = "10.1.1.1:1234"
+			h.ServeHTTP(rr, req)
+			codes = append(codes, rr.Code)
+			if i == 2 && rr.Header().Get("Retry-After") != "1" {
+				t.Errorf(fmt.Sprintf("Handler %s returned incorrect Retry-After - got (%s)", "RateLimitMiddleware", rr.Header().Get("Retry-After")))
+			}
+		}
+		if codes[2] != STATUS || codes[0] != 200 {
+			t.Errorf(fmt.Sprintf("Handler %s returned incorrect status codes - got (%v)", "RateLimitMiddleware", codes))
+		}
+	})
+
+	t.Run("IdentityMiddleware : should pass (api key and jwt subject limited apart from the address)", func(t *testing.T) {
+		os.Setenv("API_KEYS", "unlimited=k3y")
+		os.Setenv("JWT_SECRETKEY", "s3cret")
+		defer func() {
+			os.Unsetenv("API_KEYS")
+			os.Unsetenv("JWT_SECRETKEY")
+		}()
+		h := IdentityMiddleware(h)
+		send := func(set func(*http.Request)) int {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/api/v1/publish", bytes.NewBuffer([]byte(`{ "request":{"email":"abc@xyz.com", "number":"1234567"}}`)))
+			req.RemoteAddr = "10.2.2.2:1234"
+			set(req)
+			h.ServeHTTP(rr, req)
+			return rr.Code
+		}
+		codes := []int{}
+		for i := 0; i < 3; i++ {
+			codes = append(codes, send(func(r *http.Request) { r.Header.Set("X-Api-Key", "k3y") }))
+		}
+		for _, sub := range []string{"user1", "user1", "user2", "user2", "user1"} {
+			codes = append(codes, send(func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+testJwt("HS256", sub, "s3cret")) }))
+		}
+		// unknown keys and forged jwts share the address bucket
+		codes = append(codes, send(func(r *http.Request) { r.Header.Set("X-Api-Key", "guess") }))
+		codes = append(codes, send(func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+testJwt("HS256", "user3", "wrong")) }))
+		codes = append(codes, send(func(r *http.Request) {}))
+		if fmt.Sprint(codes) != "[200 200 200 200 200 200 200 429 200 200 429]" {
+			t.Errorf(fmt.Sprintf("Handler %s returned incorrect status codes - got (%v)", "IdentityMiddleware", codes))
+		}
+	})
+
+	t.Run("verifyJwtSubject : should fail (unsigned, expired or not yet valid)", func(t *testing.T) {
+		enc := base64.RawURLEncoding
+		now := time.Unix(1700000000, 0)
+		signed := func(claims string) string {
+			unsigned := enc.EncodeToString([]byte(`{"alg":"HS256"}`)) + "." + enc.EncodeToString([]byte(claims))
+			mac := hmac.New(sha256.New, []byte("s3cret"))
+			mac.Write([]byte(unsigned))
+			return unsigned + "." + enc.EncodeToString(mac.Sum(nil))
+		}
+		tokens := []string{
+			enc.EncodeToString([]byte(`{"alg":"none"}`)) + "." + enc.EncodeToString([]byte(`{"sub":"user1"}`)) + ".",
+			signed(`{"sub":"user1","exp":1600000000}`),
+			signed(`{"sub":"user1","nbf":1800000000}`),
+			signed(`{"exp":1800000000}`),
+		}
+		for _, token := range tokens {
+			if sub, err := verifyJwtSubject(token, []byte("s3cret"), now); err == nil {
+				t.Errorf(fmt.Sprintf("Function %s should fail - got (%s)", "verifyJwtSubject", sub))
+			}
+		}
+		if sub, err := verifyJwtSubject(signed(`{"sub":"user1","exp":1800000000}`), []byte("s3cret"), now); err != nil || sub != "user1" {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect subject - got (%s %v)", "verifyJwtSubject", sub, err))
+		}
+	})
+
+	t.Run("SendPayloadHandler : should fail (topic throttled, not dead lettered)", func(t *testing.T) {
+		var STATUS int = 429
+		os.Setenv("TOPIC", "limited")
+		defer os.Setenv("TOPIC", "test")
+		mock := conn.(*connectors.MockConnectors)
+		mock.DeadLetters = nil
+		codes := []int{}
+		for i := 0; i < 2; i++ {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/api/v1/publish", bytes.NewBuffer([]byte(`{ "request":{"email":"abc@xyz.com", "number":"1234567"}}`)))
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				SendPayloadHandler(w, r, conn)
+			}).ServeHTTP(rr, req)
+			codes = append(codes, rr.Code)
+		}
+		if codes[0] != 200 || codes[1] != STATUS || len(mock.DeadLetters) != 0 {
+			t.Errorf(fmt.Sprintf("Handler %s returned incorrect status codes - got (%v) dead letters (%d)", "SendPayloadHandler", codes, len(mock.DeadLetters)))
+		}
+	})
+}
+
+func TestBodyMiddleware(t *testing.T) {
+
+	logger := &simple.Logger{Level: "info"}
+	conn := connectors.NewTestConnectors("", 200, logger)
+	router := mux.NewRouter()
+	router.Use(BodyMiddleware)
+	router.HandleFunc("/api/v1/publish", func(w http.ResponseWriter, r *http.Request) {
+		SendPayloadHandler(w, r, conn)
+	}).Methods("POST")
+	payload := []byte(`{ "request":{"email":"abc@xyz.com", "number":"1234567"}}`)
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(payload)
+	zw.Close()
+	zs, _ := zstd.NewWriter(nil)
+	zstdPayload := zs.EncodeAll(payload, nil)
+
+	tests := []struct {
+		name     string
+		body     []byte
+		ctype    string
+		encoding string
+		maxSize  string
+		status   int
+	}{
+		{"should pass (json)", payload, "application/json; charset=utf-8", "", "", 200},
+		{"should pass (gzip)", gz.Bytes(), APPLICATIONJSON, "gzip", "", 200},
+		{"should pass (zstd)", zstdPayload, APPLICATIONJSON, "zstd", "", 200},
+		{"should fail (content type)", payload, "text/plain", "", "", 415},
+		{"should fail (missing content type)", payload, "", "", "", 415},
+		{"should fail (encoding)", payload, APPLICATIONJSON, "br", "", 415},
+		{"should fail (corrupt gzip)", append(gz.Bytes()[:20:20], []byte("garbage")...), APPLICATIONJSON, "gzip", "", 400},
+		{"should fail (too large)", payload, APPLICATIONJSON, "", "16", 413},
+		{"should fail (decoded too large)", gz.Bytes(), APPLICATIONJSON, "gzip", "/api/v1/publish=50", 413},
+	}
+	for _, tc := range tests {
+		t.Run("BodyMiddleware : "+tc.name, func(t *testing.T) {
+			os.Setenv("MAX_BODY_SIZE", tc.maxSize)
+			os.Setenv("MAX_BODY_SIZE_ROUTES", "")
+			if strings.Contains(tc.maxSize, "=") {
+				os.Setenv("MAX_BODY_SIZE", "")
+				os.Setenv("MAX_BODY_SIZE_ROUTES", tc.maxSize)
+			}
+			defer os.Unsetenv("MAX_BODY_SIZE")
+			defer os.Unsetenv("MAX_BODY_SIZE_ROUTES")
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/api/v1/publish", bytes.NewBuffer(tc.body))
+			if tc.ctype != "" {
+				req.Header.Set(CONTENTTYPE, tc.ctype)
+			}
+			if tc.encoding != "" {
+				req.Header.Set(CONTENTENCODING, tc.encoding)
+			}
+			router.ServeHTTP(rr, req)
+			if rr.Code != tc.status {
+				t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d) %s", "BodyMiddleware", rr.Code, tc.status, rr.Body.String()))
+			}
+		})
+	}
+}
+
+func TestCors(t *testing.T) {
+
+	called := false
+	h := CorsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusOK)
+	}))
+	os.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example.com, https://*.example.org")
+	os.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+	os.Setenv("CORS_MAX_AGE", "600")
+	defer os.Unsetenv("CORS_ALLOWED_ORIGINS")
+	defer os.Unsetenv("CORS_ALLOW_CREDENTIALS")
+	defer os.Unsetenv("CORS_MAX_AGE")
+
+	t.Run("CorsMiddleware : should pass (preflight short circuited)", func(t *testing.T) {
+		var STATUS int = 204
+		called = false
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("OPTIONS", "/api/v1/publish", nil)
+		req.Header.Set("Origin", "https://api.example.org")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		h.ServeHTTP(rr, req)
+		if rr.Code != STATUS || called {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d) handler called (%v)", "CorsMiddleware", rr.Code, STATUS, called))
+		}
+		if rr.Header().Get("Access-Control-Allow-Origin") != "https://api.example.org" || rr.Header().Get("Access-Control-Allow-Credentials") != "true" || rr.Header().Get("Access-Control-Max-Age") != "600" {
+			t.Errorf(fmt.Sprintf("Handler %s returned incorrect headers - got (%v)", "CorsMiddleware", rr.Header()))
+		}
+	})
+
+	t.Run("CorsMiddleware : should fail (preflight origin not allowed)", func(t *testing.T) {
+		var STATUS int = 403
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("OPTIONS", "/api/v1/publish", nil)
+		req.Header.Set("Origin", "https://evil.com")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		h.ServeHTTP(rr, req)
+		if rr.Code != STATUS || rr.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "CorsMiddleware", rr.Code, STATUS))
+		}
+	})
+
+	t.Run("CorsMiddleware : should pass (simple request)", func(t *testing.T) {
+		called = false
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/publish", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		h.ServeHTTP(rr, req)
+		if !called || rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" || rr.Header().Get("Access-Control-Expose-Headers") != CORSEXPOSED {
+			t.Errorf(fmt.Sprintf("Handler %s returned incorrect headers - got (%v)", "CorsMiddleware", rr.Header()))
+		}
+	})
+
+	t.Run("CorsMiddleware : should pass (wildcard without credentials)", func(t *testing.T) {
+		os.Setenv("CORS_ALLOWED_ORIGINS", "*")
+		os.Setenv("CORS_ALLOW_CREDENTIALS", "false")
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/admin/schemas", nil)
+		req.Header.Set("Origin", "https://any.com")
+		h.ServeHTTP(rr, req)
+		if rr.Header().Get("Access-Control-Allow-Origin") != "*" {
+			t.Errorf(fmt.Sprintf("Handler %s returned incorrect origin - got (%s)", "CorsMiddleware", rr.Header().Get("Access-Control-Allow-Origin")))
+		}
+	})
+
+	t.Run("CorsMiddleware : should fail (wildcard with credentials, origin not reflected)", func(t *testing.T) {
+		os.Setenv("CORS_ALLOWED_ORIGINS", "*")
+		os.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/admin/schemas", nil)
+		req.Header.Set("Origin", "https://any.com")
+		h.ServeHTTP(rr, req)
+		if rr.Header().Get("Access-Control-Allow-Origin") != "" || rr.Header().Get("Access-Control-Allow-Credentials") != "" {
+			t.Errorf(fmt.Sprintf("Handler %s returned incorrect headers - got (%v)", "CorsMiddleware", rr.Header()))
+		}
+	})
+}
+
+func TestReload(t *testing.T) {
+
+	logger := &simple.Logger{Level: "info"}
+	conn := connectors.NewTestConnectors("", 200, logger)
+	defer config.Set(nil)
+	defer templates.Load("")
+
+	t.Run("Reload : should pass (templates installed, version reported by IsAlive)", func(t *testing.T) {
+		os.Setenv("TEMPLATE_CONFIG", "../../tests/templates.json")
+		defer os.Unsetenv("TEMPLATE_CONFIG")
+		version, err := Reload(context.Background(), conn)
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned error %v", "Reload", err))
+		}
+		if version == "" || ConfigVersion() != version || config.Get().TemplateConfig != "../../tests/templates.json" {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect version - got (%s) active (%s)", "Reload", version, ConfigVersion()))
+		}
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/isalive", nil)
+		http.HandlerFunc(IsAlive).ServeHTTP(rr, req)
+		if !strings.Contains(rr.Body.String(), version) {
+			t.Errorf(fmt.Sprintf("Handler %s returned no config version - got (%s)", "IsAlive", rr.Body.String()))
+		}
+	})
+
+	t.Run("versionOf : should pass (secret values not hashed)", func(t *testing.T) {
+		a, b := *config.Get(), *config.Get()
+		a.AdminToken, b.AdminToken = "s3cret", "other"
+		va, _ := versionOf(&a)
+		vb, _ := versionOf(&b)
+		b.Topic = "other"
+		vc, _ := versionOf(&b)
+		if va != vb || va == vc {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect versions - got (%s %s %s)", "versionOf", va, vb, vc))
+		}
+	})
+
+	t.Run("WatchConfig : should pass (reloaded when a referenced file changes)", func(t *testing.T) {
+		dir := t.TempDir()
+		avsc, _ := os.ReadFile("../../tests/customer.avsc")
+		os.WriteFile(filepath.Join(dir, "customer.avsc"), avsc, 0644)
+		os.WriteFile(filepath.Join(dir, "encoding.json"), []byte(`{ "topics": { "customers": { "encoding": "avro", "schema": "`+filepath.Join(dir, "customer.avsc")+`" }}}`), 0644)
+		os.Setenv("TEMPLATE_CONFIG", "../../tests/templates.json")
+		os.Setenv("ENCODING_CONFIG", filepath.Join(dir, "encoding.json"))
+		defer os.Unsetenv("TEMPLATE_CONFIG")
+		defer os.Unsetenv("ENCODING_CONFIG")
+		defer encoders.Load("")
+		before, err := Reload(context.Background(), conn)
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned error %v", "Reload", err))
+		}
+		os.WriteFile(filepath.Join(dir, "customer.avsc"), bytes.Replace(avsc, []byte(`"name"`), []byte(`"doc":"changed", "name"`), 1), 0644)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan bool)
+		go func() {
+			WatchConfig(ctx, conn, 10*time.Millisecond)
+			close(done)
+		}()
+		for i := 0; i < 100 && ConfigVersion() == before; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+		<-done
+		if ConfigVersion() == before {
+			t.Errorf(fmt.Sprintf("Function %s did not reload version %s", "WatchConfig", before))
+		}
+	})
+
+	t.Run("ReloadHandler : should fail (bad template, previous version kept)", func(t *testing.T) {
+		var STATUS int = 422
+		before := ConfigVersion()
+		os.Setenv("TEMPLATE_CONFIG", "../../tests/badtemplates.json")
+		defer os.Unsetenv("TEMPLATE_CONFIG")
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/admin/reload", nil)
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ReloadHandler(w, r, conn)
+		}).ServeHTTP(rr, req)
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "ReloadHandler", rr.Code, STATUS))
+		}
+		if ConfigVersion() != before || config.Get().TemplateConfig != "../../tests/templates.json" {
+			t.Errorf(fmt.Sprintf("Handler %s should keep version %s - got (%s)", "ReloadHandler", before, ConfigVersion()))
+		}
+	})
+
+	t.Run("ReloadHandler : should pass", func(t *testing.T) {
+		var STATUS int = 200
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/admin/reload", nil)
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ReloadHandler(w, r, conn)
+		}).ServeHTTP(rr, req)
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "ReloadHandler", rr.Code, STATUS))
+		}
+	})
+}
+
+func TestDynamicConfig(t *testing.T) {
+
+	logger := &simple.Logger{Level: "info"}
+	conn := connectors.NewTestConnectors("", 200, logger)
+	mock := conn.(*connectors.MockConnectors)
+	os.Setenv("DYNAMIC_CONFIG", "redis")
+	defer os.Unsetenv("DYNAMIC_CONFIG")
+	defer config.Set(nil)
+	defer templates.Load("")
+	defer ratelimit.Load("")
+	defer installDynamic(nil)
+
+	put := func(kind string, topic string, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/api/v1/admin/config/"+kind+"/"+topic, bytes.NewBuffer([]byte(body)))
+		req = mux.SetURLVars(req, map[string]string{"kind": kind, "topic": topic})
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			PutDynamicConfigHandler(w, r, conn)
+		}).ServeHTTP(rr, req)
+		return rr
+	}
+	render := func(topic string) string {
+		var buf bytes.Buffer
+		templates.ForTopic(topic).Execute(&buf, map[string]string{"Email": "abc@xyz.com", "Number": "1234567"})
+		return buf.String()
+	}
+
+	t.Run("PutDynamicConfigHandler : should pass (template applied)", func(t *testing.T) {
+		var STATUS int = 200
+		rr := put("templates", "audit", `{ "audit":"{{ .Email }}" }`)
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "PutDynamicConfigHandler", rr.Code, STATUS))
+		}
+		if render("audit") != `{ "audit":"abc@xyz.com" }` || DynamicVersion() != 1 {
+			t.Errorf(fmt.Sprintf("Handler %s template not applied - got (%s) version (%d)", "PutDynamicConfigHandler", render("audit"), DynamicVersion()))
+		}
+	})
+
+	t.Run("PutDynamicConfigHandler : should fail (invalid rate limit)", func(t *testing.T) {
+		var STATUS int = 400
+		rr := put("ratelimits", "audit", `{ "rate":0, "burst":1 }`)
+		if rr.Code != STATUS || DynamicVersion() != 1 {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "PutDynamicConfigHandler", rr.Code, STATUS))
+		}
+	})
+
+	t.Run("PutDynamicConfigHandler : should fail (unknown config)", func(t *testing.T) {
+		var STATUS int = 404
+		rr := put("schemas", "audit", `{}`)
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "PutDynamicConfigHandler", rr.Code, STATUS))
+		}
+	})
+
+	t.Run("RefreshDynamicConfig : should pass (redis read fails, cached config kept)", func(t *testing.T) {
+		mock.Meta("true")
+		defer mock.Meta("false")
+		if _, err := RefreshDynamicConfig(context.Background(), conn); err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned error %v", "RefreshDynamicConfig", err))
+		}
+		if render("audit") != `{ "audit":"abc@xyz.com" }` || DynamicVersion() != 1 {
+			t.Errorf(fmt.Sprintf("Function %s should keep the cached config - got (%s) version (%d)", "RefreshDynamicConfig", render("audit"), DynamicVersion()))
+		}
+	})
+
+	t.Run("WatchDynamicConfig : should pass (change notification applied)", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		mock.Changes = make(chan string)
+		go WatchDynamicConfig(ctx, conn)
+		version, _ := conn.PutDynamicConfig(ctx, connectors.DYNAMICTEMPLATES, "audit", `{ "changed":"{{ .Number }}" }`)
+		mock.Changes <- strconv.FormatInt(version, 10)
+		for i := 0; i < 100 && DynamicVersion() != version; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if DynamicVersion() != version || render("audit") != `{ "changed":"1234567" }` {
+			t.Errorf(fmt.Sprintf("Function %s did not apply version %d - got (%d)", "WatchDynamicConfig", version, DynamicVersion()))
+		}
+	})
+
+	t.Run("PutDynamicConfigHandler : should pass (route and policy applied)", func(t *testing.T) {
+		var STATUS int = 200
+		os.Setenv("TOPIC_NAMESPACE", "audit*")
+		defer os.Unsetenv("TOPIC_NAMESPACE")
+		config.Set(nil)
+		defer routes.Install(routes.New())
+		defer encoders.Load("")
+		rr := put("routes", "audit", `["audit","audit.copy"]`)
+		if rr.Code != STATUS || strings.Join(routes.Channels("audit"), ",") != "audit,audit.copy" {
+			t.Errorf(fmt.Sprintf("Handler %s route not applied - got (%d %v)", "PutDynamicConfigHandler", rr.Code, routes.Channels("audit")))
+		}
+		rr = put("policies", "audit", `{ "encoding":{ "encoding":"msgpack" } }`)
+		if rr.Code != STATUS || encoders.ForTopic("audit").ContentType() != "application/msgpack" {
+			t.Errorf(fmt.Sprintf("Handler %s policy not applied - got (%d %s)", "PutDynamicConfigHandler", rr.Code, encoders.ForTopic("audit").ContentType()))
+		}
+	})
+
+	t.Run("PutDynamicConfigHandler : should fail (route outside the namespace, empty policy)", func(t *testing.T) {
+		var STATUS int = 400
+		for kind, body := range map[string]string{"routes": `["dynamicconfig:changes"]`, "policies": `{ "encrypt":[] }`} {
+			if rr := put(kind, "audit", body); rr.Code != STATUS {
+				t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code for %s - got (%d) wanted (%d)", "PutDynamicConfigHandler", kind, rr.Code, STATUS))
+			}
+		}
+	})
+}
+
+func TestTopicsAdmin(t *testing.T) {
+
+	logger := &simple.Logger{Level: "info"}
+	conn := connectors.NewTestConnectors("", 200, logger)
+	mock := conn.(*connectors.MockConnectors)
+	mock.Subscribers = map[string]int64{"test": 2, "test.audit": 1, "unrelated": 5}
+	topics.Reset()
+	defer topics.Reset()
+	admin := AdminAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ListTopicsHandler(w, r, conn)
+	}))
+
+	t.Run("AdminAuthMiddleware : should fail (admin api disabled)", func(t *testing.T) {
+		var STATUS int = 403
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/admin/topics", nil)
+		admin.ServeHTTP(rr, req)
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "AdminAuthMiddleware", rr.Code, STATUS))
+		}
+	})
+
+	os.Setenv("ADMIN_TOKEN", "s3cret")
+	defer os.Unsetenv("ADMIN_TOKEN")
+
+	t.Run("AdminAuthMiddleware : should fail (wrong token)", func(t *testing.T) {
+		var STATUS int = 401
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/admin/topics", nil)
+		req.Header.Set("Authorization", "Bearer wrong")
+		admin.ServeHTTP(rr, req)
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "AdminAuthMiddleware", rr.Code, STATUS))
+		}
+	})
+
+	t.Run("ListTopicsHandler : should pass (namespace channels and local counters)", func(t *testing.T) {
+		var STATUS int = 200
+		topics.Record("test", nil)
+		topics.Record("test.idle", errors.New("forced error"))
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/admin/topics", nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		admin.ServeHTTP(rr, req)
+		if rr.Code != STATUS {
+			t.Fatalf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "ListTopicsHandler", rr.Code, STATUS))
+		}
+		res := &schema.Topics{}
+		json.Unmarshal(rr.Body.Bytes(), res)
+		got := []string{}
+		for _, ts := range res.Topics {
+			got = append(got, fmt.Sprintf("%s:%d:%d:%d", ts.Topic, ts.Subscribers, ts.Published, ts.Errors))
+		}
+		if res.Namespace != "test*" || strings.Join(got, ",") != "test:2:1:0,test.audit:1:0:0,test.idle:0:0:1" {
+			t.Errorf(fmt.Sprintf("Handler %s returned incorrect topics - got (%s) %v", "ListTopicsHandler", res.Namespace, got))
+		}
+	})
+
+	t.Run("GetTopicHandler : should fail (outside the namespace)", func(t *testing.T) {
+		var STATUS int = 404
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/admin/topics/unrelated", nil)
+		req = mux.SetURLVars(req, map[string]string{"topic": "unrelated"})
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			GetTopicHandler(w, r, conn)
+		}).ServeHTTP(rr, req)
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "GetTopicHandler", rr.Code, STATUS))
+		}
+	})
+
+	t.Run("GetTopicHandler : should fail (redis unavailable)", func(t *testing.T) {
+		var STATUS int = 503
+		mock.Meta("true")
+		defer mock.Meta("false")
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/admin/topics/test", nil)
+		req = mux.SetURLVars(req, map[string]string{"topic": "test"})
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			GetTopicHandler(w, r, conn)
+		}).ServeHTTP(rr, req)
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "GetTopicHandler", rr.Code, STATUS))
+		}
+	})
+}
+
+func TestSubscribe(t *testing.T) {
+
+	logger := &simple.Logger{Level: "info"}
+	conn := connectors.NewTestConnectors("", 200, logger)
+	mock := conn.(*connectors.MockConnectors)
+
+	stream := func(target string, topic string, msgs ...*schema.PubSubMessage) *httptest.ResponseRecorder {
+		ctx, cancel := context.WithCancel(context.Background())
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequestWithContext(ctx, "GET", target, nil)
+		req = mux.SetURLVars(req, map[string]string{"topic": topic})
+		mock.Stream = make(chan *schema.PubSubMessage)
+		done := make(chan struct{})
+		go func() {
+			SubscribeHandler(rr, req, conn)
+			close(done)
+		}()
+		for _, msg := range msgs {
+			mock.Stream <- msg
+		}
+		// the client disconnects
+		cancel()
+		<-done
+		return rr
+	}
+
+	t.Run("SubscribeHandler : should pass (filtered server-sent events)", func(t *testing.T) {
+		rr := stream("/api/v1/subscribe/test?field=request.number=1234567&contains=abc", "test",
+			&schema.PubSubMessage{Channel: "test", Payload: `{"request":{"email":"abc@xyz.com","number":1234567}}`},
+			&schema.PubSubMessage{Channel: "test", Payload: `{"request":{"email":"abc@xyz.com","number":7654321}}`},
+			&schema.PubSubMessage{Channel: "test", Payload: `{"request":{"email":"def@xyz.com","number":1234567}}`},
+		)
+		body := rr.Body.String()
+		if rr.Header().Get(CONTENTTYPE) != EVENTSTREAM || strings.Count(body, "event: message") != 1 || !strings.Contains(body, `id: 1`) || strings.Contains(body, "7654321") {
+			t.Errorf(fmt.Sprintf("Handler %s returned incorrect stream - got (%s)", "SubscribeHandler", body))
+		}
+	})
+
+	t.Run("SubscribeHandler : should pass (pattern messages outside the namespace dropped)", func(t *testing.T) {
+		rr := stream("/api/v1/subscribe/test*?pattern=true", "test*",
+			&schema.PubSubMessage{Channel: "test.audit", Pattern: "test*", Payload: "audit"},
+			&schema.PubSubMessage{Channel: "other", Pattern: "test*", Payload: "other"},
+		)
+		body := rr.Body.String()
+		if strings.Count(body, "event: message") != 1 || !strings.Contains(body, `"channel":"test.audit"`) || mock.Subscribed[len(mock.Subscribed)-1] != "test*" {
+			t.Errorf(fmt.Sprintf("Handler %s returned incorrect stream - got (%s)", "SubscribeHandler", body))
+		}
+	})
+
+	t.Run("SubscribeHandler : should fail (invalid filter)", func(t *testing.T) {
+		var STATUS int = 400
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/subscribe/test?field=nofield", nil)
+		req = mux.SetURLVars(req, map[string]string{"topic": "test"})
+		SubscribeHandler(rr, req, conn)
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "SubscribeHandler", rr.Code, STATUS))
+		}
+	})
+
+	t.Run("StreamAuthMiddleware : should pass (subscribe token as access_token, admin token as bearer)", func(t *testing.T) {
+		var STATUS int = 200
+		os.Setenv("ADMIN_TOKEN", "s3cret")
+		os.Setenv("SUBSCRIBE_TOKEN", "r3ad")
+		defer os.Unsetenv("ADMIN_TOKEN")
+		defer os.Unsetenv("SUBSCRIBE_TOKEN")
+		for _, header := range []string{"", "Bearer s3cret", "Bearer r3ad"} {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/api/v1/subscribe/test?access_token=r3ad", nil)
+			if header != "" {
+				req.Header.Set("Authorization", header)
+			}
+			StreamAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})).ServeHTTP(rr, req)
+			if rr.Code != STATUS {
+				t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "StreamAuthMiddleware", rr.Code, STATUS))
+			}
+		}
+	})
+
+	t.Run("StreamAuthMiddleware : should fail (admin token as access_token)", func(t *testing.T) {
+		var STATUS int = 401
+		os.Setenv("ADMIN_TOKEN", "s3cret")
+		defer os.Unsetenv("ADMIN_TOKEN")
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/subscribe/test?access_token=s3cret", nil)
+		StreamAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})).ServeHTTP(rr, req)
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "StreamAuthMiddleware", rr.Code, STATUS))
+		}
+	})
+
+	t.Run("StreamAuthMiddleware : should fail (streams disabled)", func(t *testing.T) {
+		var STATUS int = 403
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/subscribe/test?access_token=r3ad", nil)
+		StreamAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})).ServeHTTP(rr, req)
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "StreamAuthMiddleware", rr.Code, STATUS))
+		}
+		if !strings.Contains(rr.Body.String(), "StreamAuthMiddleware subscribe streams are disabled (SUBSCRIBE_TOKEN or ADMIN_TOKEN)") {
+			t.Errorf(fmt.Sprintf("Handler %s returned incorrect message - got (%s)", "StreamAuthMiddleware", rr.Body.String()))
+		}
+	})
+}
+
+func TestWebSocket(t *testing.T) {
+
+	logger := &simple.Logger{Level: "info"}
+	conn := connectors.NewTestConnectors("", 200, logger)
+	mock := conn.(*connectors.MockConnectors)
+	mock.Stream = make(chan *schema.PubSubMessage)
+	os.Setenv("SUBSCRIBE_TOKEN", "r3ad")
+	defer os.Unsetenv("SUBSCRIBE_TOKEN")
+	// upgraded through the status writer of the server spans (Hijack passed through)
+	srv := httptest.NewServer(StreamAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WebSocketHandler(tracing.NewStatusWriter(w), r, conn)
+	})))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/v1/ws"
+	if _, res, err := websocket.DefaultDialer.Dial(url, nil); err == nil || res == nil || res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf(fmt.Sprintf("Function %s should fail without the token - got (%v)", "Dial", err))
+	}
+	ws, _, err := websocket.DefaultDialer.Dial(url+"?access_token=r3ad", nil)
+	if err != nil {
+		t.Fatalf(fmt.Sprintf("Function %s returned error %v", "Dial", err))
+	}
+	defer ws.Close()
+	roundTrip := func(frame *schema.WebSocketFrame) *schema.WebSocketFrame {
+		ws.WriteJSON(frame)
+		res := &schema.WebSocketFrame{}
+		ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := ws.ReadJSON(res); err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned error %v", "ReadJSON", err))
+		}
+		return res
+	}
+
+	t.Run("WebSocketHandler : should pass (publish ack)", func(t *testing.T) {
+		res := roundTrip(&schema.WebSocketFrame{Type: WSPUBLISH, ID: "1", Payload: json.RawMessage(`{ "request":{"email":"abc@xyz.com", "number":"1234567"}}`)})
+		if res.Type != WSACK || res.ID != "1" {
+			t.Errorf(fmt.Sprintf("Handler %s returned incorrect frame - got (%s %s %s)", "WebSocketHandler", res.Type, res.ID, res.Error))
+		}
+	})
+
+	t.Run("WebSocketHandler : should fail (publish error frame)", func(t *testing.T) {
+		res := roundTrip(&schema.WebSocketFrame{Type: WSPUBLISH, ID: "2", Payload: json.RawMessage(`"not a payload"`)})
+		if res.Type != WSERROR || res.ID != "2" || res.Code != 400 {
+			t.Errorf(fmt.Sprintf("Handler %s returned incorrect frame - got (%s %s %d)", "WebSocketHandler", res.Type, res.ID, res.Code))
+		}
+	})
+
+	t.Run("WebSocketHandler : should fail (topic outside the namespace)", func(t *testing.T) {
+		res := roundTrip(&schema.WebSocketFrame{Type: WSSUBSCRIBE, ID: "3", Topic: "other"})
+		if res.Type != WSERROR || res.Code != 404 {
+			t.Errorf(fmt.Sprintf("Handler %s returned incorrect frame - got (%s %d)", "WebSocketHandler", res.Type, res.Code))
+		}
+	})
+
+	t.Run("WebSocketHandler : should pass (subscribe, message, unsubscribe)", func(t *testing.T) {
+		res := roundTrip(&schema.WebSocketFrame{Type: WSSUBSCRIBE, ID: "4", Topic: "test"})
+		if res.Type != WSACK || res.Topic != "test" {
+			t.Fatalf(fmt.Sprintf("Handler %s returned incorrect frame - got (%s %s)", "WebSocketHandler", res.Type, res.Error))
+		}
+		mock.Stream <- &schema.PubSubMessage{Channel: "test", Payload: `{"email":"abc@xyz.com"}`}
+		msg := &schema.WebSocketFrame{}
+		ws.ReadJSON(msg)
+		if msg.Type != WSMESSAGE || msg.Channel != "test" || string(msg.Payload) != `{"email":"abc@xyz.com"}` {
+			t.Errorf(fmt.Sprintf("Handler %s returned incorrect message - got (%s %s %s)", "WebSocketHandler", msg.Type, msg.Channel, msg.Payload))
+		}
+		res = roundTrip(&schema.WebSocketFrame{Type: WSUNSUBSCRIBE, ID: "5", Topic: "test"})
+		if res.Type != WSACK || res.ID != "5" {
+			t.Errorf(fmt.Sprintf("Handler %s returned incorrect frame - got (%s %s)", "WebSocketHandler", res.Type, res.Error))
+		}
+	})
+
+	t.Run("deliver : should pass (drop policy when the send buffer is full)", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		c := &wsConn{ctx: ctx, send: make(chan *schema.WebSocketFrame, 1), slow: WSDROP}
+		if !c.deliver(&schema.WebSocketFrame{Type: WSMESSAGE}) || c.deliver(&schema.WebSocketFrame{Type: WSMESSAGE}) || c.dropped != 1 {
+			t.Errorf(fmt.Sprintf("Function %s should drop when full - got (%d dropped)", "deliver", c.dropped))
+		}
+	})
+}
+
+// testJwt - a jwt for subject signed with key
+func testJwt(alg string, subject string, key string) string {
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString([]byte(`{"alg":"`+alg+`","typ":"JWT"}`)) + "." + enc.EncodeToString([]byte(`{"sub":"`+subject+`"}`))
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + enc.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/ratelimit"
+)
+
+const (
+	APIKEYHEADER string = "X-Api-Key"
+)
+
+// IdentityMiddleware implements mux.MiddlewareFunc.
+// A configured X-Api-Key (API_KEYS) or a bearer jwt signed with JWT_SECRETKEY identifies the client for the
+// rate limits (see ratelimit.Verified), requests without a valid one are limited by their address
+func IdentityMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := Identity(r.Header); id != "" {
+			r = r.WithContext(ratelimit.Verified(r.Context(), id))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Identity - "apikey:<client>" for a key of API_KEYS (client=key,...), "sub:<subject>" for a valid jwt
+// (empty when neither), the key itself never becomes part of the identity as client keys are logged
+func Identity(header http.Header) string {
+	cfg := config.Get()
+	if key := header.Get(APIKEYHEADER); key != "" {
+		for _, entry := range strings.Split(cfg.ApiKeys, ",") {
+			client, k, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if ok && client != "" && tokenEqual(key, k) {
+				return "apikey:" + client
+			}
+		}
+	}
+	if token, ok := strings.CutPrefix(header.Get("Authorization"), "Bearer "); ok && cfg.JwtSecretKey != "" {
+		if sub, err := verifyJwtSubject(token, []byte(cfg.JwtSecretKey), time.Now()); err == nil {
+			return "sub:" + sub
+		}
+	}
+	return ""
+}
+
+// verifyJwtSubject - the subject of a HS256/HS384/HS512 signed jwt, exp and nbf are checked when present
+func verifyJwtSubject(token string, key []byte, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("jwt must have 3 parts")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJwtPart(parts[0], &header); err != nil {
+		return "", err
+	}
+	var h func() hash.Hash
+	switch header.Alg {
+	case "HS256":
+		h = sha256.New
+	case "HS384":
+		h = sha512.New384
+	case "HS512":
+		h = sha512.New
+	default:
+		return "", fmt.Errorf("unexpected signing method: %v", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(h, key)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if subtle.ConstantTimeCompare(sig, mac.Sum(nil)) != 1 {
+		return "", errors.New("jwt signature is invalid")
+	}
+	var claims struct {
+		Sub string   `json:"sub"`
+		Exp *float64 `json:"exp"`
+		Nbf *float64 `json:"nbf"`
+	}
+	if err := decodeJwtPart(parts[1], &claims); err != nil {
+		return "", err
+	}
+	if claims.Exp != nil && float64(now.Unix()) >= *claims.Exp {
+		return "", errors.New("jwt is expired")
+	}
+	if claims.Nbf != nil && float64(now.Unix()) < *claims.Nbf {
+		return "", errors.New("jwt is not valid yet")
+	}
+	if claims.Sub == "" {
+		return "", errors.New("jwt subject is empty")
+	}
+	return claims.Sub, nil
+}
+
+func decodeJwtPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/connectors"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/metrics"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/ratelimit"
+)
+
+const (
+	REQUESTIDHEADER string = "X-Request-ID"
+)
+
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._:\-]{1,128}$`)
+
+// RequestIdMiddleware implements mux.MiddlewareFunc.
+// The request id is taken from X-Request-ID (a new one is generated when missing or invalid),
+// stored in the request context for the request scoped clients and returned as a response header
+func RequestIdMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(REQUESTIDHEADER)
+		if !validRequestId.MatchString(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(REQUESTIDHEADER, id)
+		next.ServeHTTP(w, r.WithContext(connectors.ContextWithRequestId(r.Context(), id)))
+	})
+}
+
+// AdminAuthMiddleware implements mux.MiddlewareFunc.
+// Admin requests need "Authorization: Bearer <ADMIN_TOKEN>" (401), without ADMIN_TOKEN the admin api is disabled (403)
+func AdminAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tokenAuthorized(w, r, "AdminAuthMiddleware", "admin api is disabled (ADMIN_TOKEN)", config.Get().AdminToken, "", false) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// StreamAuthMiddleware implements mux.MiddlewareFunc.
+// Streams (server-sent events, websocket) take the read only SUBSCRIBE_TOKEN, also as the access_token query
+// parameter as browsers (EventSource, WebSocket) can not set headers, or the ADMIN_TOKEN as bearer token only
+// (it never appears in urls), without either token the streams are disabled (403)
+func StreamAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := config.Get()
+		if tokenAuthorized(w, r, "StreamAuthMiddleware", "subscribe streams are disabled (SUBSCRIBE_TOKEN or ADMIN_TOKEN)", cfg.AdminToken, cfg.SubscribeToken, true) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// tokenAuthorized - checks the bearer token against admin (or the read only token when set), the access_token
+// query parameter is only checked (when allowed and there is no Authorization header) against the read only token
+// writes the 401 / 403 response (prefixed with the middleware name) when the request is not authorized
+func tokenAuthorized(w http.ResponseWriter, r *http.Request, name string, disabled string, admin string, readOnly string, query bool) bool {
+	if admin == "" && readOnly == "" {
+		addHeaders(w, r)
+		b := responseErrorFormat(http.StatusForbidden, w, "%s %s", name, disabled)
+		fmt.Fprintf(w, "%s", string(b))
+		return false
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	valid := ok && (tokenEqual(got, admin) || tokenEqual(got, readOnly))
+	if r.Header.Get("Authorization") == "" && query {
+		valid = tokenEqual(r.URL.Query().Get("access_token"), readOnly)
+	}
+	if !valid {
+		addHeaders(w, r)
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		b := responseErrorFormat(http.StatusUnauthorized, w, "%s invalid or missing bearer token", name)
+		fmt.Fprintf(w, "%s", string(b))
+		return false
+	}
+	return true
+}
+
+// tokenEqual - constant time comparison, an unset token matches nothing
+func tokenEqual(got string, token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// RateLimitMiddleware - throttles each client (api key or jwt subject set by IdentityMiddleware, or ip) with a 429 and Retry-After
+// the limits are read from RATELIMIT_CONFIG, the middleware fails open when the redis counter is unavailable
+func RateLimitMiddleware(con connectors.Clients) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			err := ratelimit.Allow(r.Context(), con, ratelimit.SCOPECLIENT, ratelimit.ClientKey(r))
+			if err != nil {
+				con := con.WithContext(r.Context())
+				if !retryAfter(w, err) {
+					con.Error("RateLimitMiddleware %v", err)
+					next.ServeHTTP(w, r)
+					return
+				}
+				msg := "RateLimitMiddleware %v"
+				con.Debug(msg, err)
+				addHeaders(w, r)
+				b := responseErrorFormat(http.StatusTooManyRequests, w, msg, err)
+				fmt.Fprintf(w, "%s", string(b))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// retryAfter - sets the Retry-After header and counts the throttled request when err is a rate limit error
+func retryAfter(w http.ResponseWriter, err error) bool {
+	var lerr *ratelimit.LimitError
+	if !errors.As(err, &lerr) {
+		return false
+	}
+	metrics.Throttled.WithLabelValues(lerr.Scope).Inc()
+	w.Header().Set("Retry-After", strconv.Itoa(lerr.RetryAfterSeconds()))
+	return true
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/connectors"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/validator"
+)
+
+// LoadSchemaRegistry - installs the latest registered version of every topic as its input schema
+func LoadSchemaRegistry(ctx context.Context, con connectors.Clients) error {
+	return loadSchemaRegistry(ctx, con, validator.RegisterInput)
+}
+
+// loadSchemaRegistry - registers the latest registry version of every topic with register
+func loadSchemaRegistry(ctx context.Context, con connectors.Clients, register func(string, int, []byte) error) error {
+	topics, err := con.ListSchemaTopics(ctx)
+	if err != nil {
+		return err
+	}
+	for _, topic := range topics {
+		sv, err := con.GetSchemaVersion(ctx, topic, 0)
+		if err != nil {
+			return fmt.Errorf("topic %s %v", topic, err)
+		}
+		if err := register(topic, sv.Version, sv.Schema); err != nil {
+			return fmt.Errorf("topic %s version %d %v", topic, sv.Version, err)
+		}
+		con.Debug("LoadSchemaRegistry topic %s version %d", topic, sv.Version)
+	}
+	return nil
+}
+
+// ListSchemasHandler - lists the latest schema version of every topic in the registry
+func ListSchemasHandler(w http.ResponseWriter, r *http.Request, con connectors.Clients) {
+	con = con.WithContext(r.Context())
+	addHeaders(w, r)
+	topics, err := con.ListSchemaTopics(r.Context())
+	if err != nil {
+		schemaError(w, con, "ListSchemasHandler", err)
+		return
+	}
+	latest := []*schema.SchemaVersion{}
+	for _, topic := range topics {
+		sv, err := con.GetSchemaVersion(r.Context(), topic, 0)
+		if err != nil {
+			schemaError(w, con, "ListSchemasHandler", err)
+			return
+		}
+		latest = append(latest, sv)
+	}
+	w.WriteHeader(http.StatusOK)
+	b, _ := json.MarshalIndent(latest, "", "	")
+	fmt.Fprintf(w, "%s", string(b))
+}
+
+// ListSchemaVersionsHandler - lists every version registered for a topic
+func ListSchemaVersionsHandler(w http.ResponseWriter, r *http.Request, con connectors.Clients) {
+	con = con.WithContext(r.Context())
+	addHeaders(w, r)
+	versions, err := con.ListSchemaVersions(r.Context(), mux.Vars(r)["topic"])
+	if err != nil {
+		schemaError(w, con, "ListSchemaVersionsHandler", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	b, _ := json.MarshalIndent(versions, "", "	")
+	fmt.Fprintf(w, "%s", string(b))
+}
+
+// GetSchemaVersionHandler - returns a single version ("latest" is accepted as the version)
+func GetSchemaVersionHandler(w http.ResponseWriter, r *http.Request, con connectors.Clients) {
+	con = con.WithContext(r.Context())
+	addHeaders(w, r)
+	version := 0
+	if v := mux.Vars(r)["version"]; v != "latest" {
+		var err error
+		if version, err = strconv.Atoi(v); err != nil || version < 1 {
+			msg := "GetSchemaVersionHandler invalid version %s"
+			b := responseErrorFormat(http.StatusBadRequest, w, msg, v)
+			fmt.Fprintf(w, "%s", string(b))
+			return
+		}
+	}
+	sv, err := con.GetSchemaVersion(r.Context(), mux.Vars(r)["topic"], version)
+	if err != nil {
+		schemaError(w, con, "GetSchemaVersionHandler", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	b, _ := json.MarshalIndent(sv, "", "	")
+	fmt.Fprintf(w, "%s", string(b))
+}
+
+// RegisterSchemaHandler - registers the body as the next schema version of the topic
+// the compatibility (none, backward, forward, full) is read from the query parameter (the route
+// is only served to admins, see AdminAuthMiddleware) or the SCHEMA_COMPATIBILITY envar (default backward) and checked against the latest version
+// the version is installed by this instance only, the others pick it up on their next reload (see ReloadHandler)
+func RegisterSchemaHandler(w http.ResponseWriter, r *http.Request, con connectors.Clients) {
+	con = con.WithContext(r.Context())
+	addHeaders(w, r)
+	topic := mux.Vars(r)["topic"]
+	body, err := io.ReadAll(r.Body)
+	if err != nil && bodyError(w, "RegisterSchemaHandler", err) {
+		con.Error("RegisterSchemaHandler %v", err)
+		return
+	}
+	if err != nil {
+		schemaError(w, con, "RegisterSchemaHandler", err)
+		return
+	}
+
+	mode := r.URL.Query().Get("compatibility")
+	if mode == "" {
+		mode = config.Get().SchemaCompatibility
+	}
+
+	if err := validator.CheckSchema(body); err != nil {
+		msg := "RegisterSchemaHandler invalid schema %v"
+		con.Error(msg, err)
+		b := responseErrorFormat(http.StatusBadRequest, w, msg, err)
+		fmt.Fprintf(w, "%s", string(b))
+		return
+	}
+
+	next := 1
+	latest, err := con.GetSchemaVersion(r.Context(), topic, 0)
+	switch {
+	case err == nil:
+		if err := validator.CheckCompatibility(mode, latest.Schema, body); err != nil {
+			var cerr *validator.CompatibilityError
+			code := http.StatusBadRequest
+			var details []string
+			if errors.As(err, &cerr) {
+				code = http.StatusConflict
+				details = cerr.Details
+			}
+			msg := "RegisterSchemaHandler %v"
+			con.Error(msg, err)
+			b := responseErrorDetails(code, w, details, msg, err)
+			fmt.Fprintf(w, "%s", string(b))
+			return
+		}
+		next = latest.Version + 1
+	case !errors.Is(err, connectors.ErrSchemaNotFound):
+		schemaError(w, con, "RegisterSchemaHandler", err)
+		return
+	}
+
+	sv := &schema.SchemaVersion{Topic: topic, Version: next, Compatibility: mode, Schema: json.RawMessage(body), Created: time.Now().UnixMilli()}
+	if err := con.PutSchemaVersion(r.Context(), sv); err != nil {
+		schemaError(w, con, "RegisterSchemaHandler", err)
+		return
+	}
+	if err := validator.RegisterInput(topic, sv.Version, sv.Schema); err != nil {
+		schemaError(w, con, "RegisterSchemaHandler", err)
+		return
+	}
+
+	con.Info("RegisterSchemaHandler topic %s version %d registered", topic, sv.Version)
+	w.WriteHeader(http.StatusCreated)
+	b, _ := json.MarshalIndent(sv, "", "	")
+	fmt.Fprintf(w, "%s", string(b))
+}
+
+// schemaError - private utility, not found maps to 404 everything else to 500
+func schemaError(w http.ResponseWriter, con connectors.Clients, name string, err error) {
+	code := http.StatusInternalServerError
+	if errors.Is(err, connectors.ErrSchemaNotFound) {
+		code = http.StatusNotFound
+	}
+	msg := name + " %v"
+	con.Error(msg, err)
+	b := responseErrorFormat(code, w, msg, err)
+	fmt.Fprintf(w, "%s", string(b))
+}
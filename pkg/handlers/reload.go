@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/connectors"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/encoders"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/enrich"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/fieldcrypt"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/ratelimit"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/routes"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/templates"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/validator"
+)
+
+var (
+	reloadMutex   sync.Mutex
+	versionMutex  sync.RWMutex
+	configVersion string
+)
+
+// ConfigVersion - the content hash of the active configuration (empty until ApplyConfig succeeds)
+func ConfigVersion() string {
+	versionMutex.RLock()
+	defer versionMutex.RUnlock()
+	return configVersion
+}
+
+// ApplyConfig - reads the templates, encodings, schemas (plus the schema registry), encryption
+// rules, enrichment lookups and rate limits referenced by cfg (plus the dynamic config and its routes) and then installs them and cfg
+// nothing is installed unless every one of them is valid, so a bad file keeps the previous config
+// the switch is not atomic, each component is installed in turn and a publish running meanwhile may see
+// new templates with the previous encodings (or schemas, rate limits ...)
+func ApplyConfig(ctx context.Context, con connectors.Clients, cfg *config.Config) (string, error) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	return applyConfig(ctx, con, cfg)
+}
+
+// Reload - reads the config files, envars and flags again and applies them (see ApplyConfig)
+// the listen port, redis and tracing settings are only read at startup
+func Reload(ctx context.Context, con connectors.Clients) (string, error) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	cfg, err := config.Reload()
+	if err != nil {
+		return "", err
+	}
+	return applyConfig(ctx, con, cfg)
+}
+
+// WatchConfig - reloads whenever the config or one of the files it references changes,
+// checking every interval until ctx is done
+func WatchConfig(ctx context.Context, con connectors.Clients, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cfg, err := config.Reload()
+			if err != nil {
+				con.Error("WatchConfig %v", err)
+				continue
+			}
+			if version, err := versionOf(cfg); err != nil || version == ConfigVersion() {
+				continue
+			}
+			version, err := Reload(ctx, con)
+			if err != nil {
+				con.Error("WatchConfig reload failed, keeping version %s : %v", ConfigVersion(), err)
+				continue
+			}
+			con.Info("WatchConfig reloaded configuration version %s", version)
+		}
+	}
+}
+
+// ReloadHandler - reloads the configuration on demand, responding with the new version
+func ReloadHandler(w http.ResponseWriter, r *http.Request, con connectors.Clients) {
+	con = con.WithContext(r.Context())
+	addHeaders(w, r)
+	version, err := Reload(r.Context(), con)
+	if err != nil {
+		msg := "ReloadHandler reload failed, keeping version %s : %v"
+		con.Error(msg, ConfigVersion(), err)
+		b := responseErrorFormat(http.StatusUnprocessableEntity, w, msg, ConfigVersion(), err)
+		fmt.Fprintf(w, "%s", string(b))
+		return
+	}
+	msg := "ReloadHandler reloaded configuration version " + version
+	con.Info(msg)
+	response := &schema.Response{Name: config.Get().Name, StatusCode: "200", Status: "OK", Message: msg}
+	w.WriteHeader(http.StatusOK)
+	b, _ := json.MarshalIndent(response, "", "	")
+	fmt.Fprintf(w, "%s", string(b))
+}
+
+func applyConfig(ctx context.Context, con connectors.Clients, cfg *config.Config) (string, error) {
+	version, err := versionOf(cfg)
+	if err != nil {
+		return "", err
+	}
+	tpl, err := templates.Read(cfg.TemplateConfig)
+	if err != nil {
+		return "", fmt.Errorf("template config %v", err)
+	}
+	enc, err := encoders.Read(cfg.EncodingConfig)
+	if err != nil {
+		return "", fmt.Errorf("encoding config %v", err)
+	}
+	crypt, err := fieldcrypt.Read(cfg.EncryptionConfig)
+	if err != nil {
+		return "", fmt.Errorf("encryption config %v", err)
+	}
+	lookups, err := enrich.Read(cfg.EnrichmentConfig)
+	if err != nil {
+		return "", fmt.Errorf("enrichment config %v", err)
+	}
+	limits, err := ratelimit.Read(cfg.RateLimitConfig)
+	if err != nil {
+		return "", fmt.Errorf("rate limit config %v", err)
+	}
+	schemas, err := validator.ReadSchemas(cfg.SchemaConfig)
+	if err != nil {
+		return "", fmt.Errorf("schema config %v", err)
+	}
+	// per topic templates, rate limits and policies from redis take precedence over the files,
+	// topics are only routed through the dynamic config
+	table := routes.New()
+	dc := dynamicConfig(ctx, con, cfg)
+	if dc != nil {
+		overlayDynamic(con, dc, tpl, limits, enc, crypt, table)
+	}
+	// registered versions take precedence over the file schemas, as at startup
+	if err := loadSchemaRegistry(ctx, con, schemas.RegisterInput); err != nil {
+		return "", fmt.Errorf("schema registry %v", err)
+	}
+
+	config.Set(cfg)
+	templates.Install(tpl)
+	encoders.Install(enc)
+	fieldcrypt.Install(crypt)
+	enrich.Install(lookups)
+	ratelimit.Install(limits)
+	routes.Install(table)
+	validator.InstallSchemas(schemas)
+	installDynamic(dc)
+	versionMutex.Lock()
+	configVersion = version
+	versionMutex.Unlock()
+	return version, nil
+}
+
+// versionOf - a short hash of the config with the secrets masked (the version is public, see IsAlive),
+// the config files and the files they reference (schemas, descriptors, keyring), changing only the value
+// of a secret keeps the version
+func versionOf(cfg *config.Config) (string, error) {
+	files := []string{cfg.TemplateConfig, cfg.EncodingConfig, cfg.EncryptionConfig, cfg.EnrichmentConfig, cfg.RateLimitConfig, cfg.SchemaConfig}
+	for _, refs := range []struct {
+		file  string
+		files func(string) ([]string, error)
+	}{{cfg.EncodingConfig, encoders.Files}, {cfg.EncryptionConfig, fieldcrypt.Files}, {cfg.SchemaConfig, validator.SchemaFiles}} {
+		if refs.file == "" {
+			continue
+		}
+		list, err := refs.files(refs.file)
+		if err != nil {
+			return "", err
+		}
+		files = append(files, list...)
+	}
+	h := sha256.New()
+	fmt.Fprint(h, cfg.String())
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %d\n", file, len(data))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12], nil
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/connectors"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/docpath"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/topics"
+)
+
+const (
+	EVENTSTREAM string = "text/event-stream"
+	// used when SSE_HEARTBEAT is not a positive duration
+	SSEHEARTBEAT time.Duration = 15 * time.Second
+)
+
+// messageFilter - the per connection filters, every one of them has to match
+// contains are substrings of the payload, fields are "path=value" pairs on the json payload (dot separated path)
+type messageFilter struct {
+	contains []string
+	fields   map[string]string
+}
+
+// SubscribeHandler - streams the messages of a topic of the namespace as server-sent events
+// ?pattern=true PSUBSCRIBEs (i.e. /api/v1/subscribe/test.*), ?contains=abc and ?field=request.email=abc@xyz.com
+// filter the messages (repeatable), a heartbeat comment is sent every SSE_HEARTBEAT
+// the redis subscription ends when the client disconnects
+func SubscribeHandler(w http.ResponseWriter, r *http.Request, con connectors.Clients) {
+	con = con.WithContext(r.Context())
+	addHeaders(w, r)
+	topic := mux.Vars(r)["topic"]
+	namespace := topics.Namespace()
+	if !topics.Match(namespace, topic) {
+		msg := "SubscribeHandler topic %s is not in the namespace %s"
+		b := responseErrorFormat(http.StatusNotFound, w, msg, topic, namespace)
+		fmt.Fprintf(w, "%s", string(b))
+		return
+	}
+	filter, err := parseMessageFilter(r)
+	if err != nil {
+		msg := "SubscribeHandler %v"
+		b := responseErrorFormat(http.StatusBadRequest, w, msg, err)
+		fmt.Fprintf(w, "%s", string(b))
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		msg := "SubscribeHandler streaming is not supported"
+		b := responseErrorFormat(http.StatusInternalServerError, w, msg)
+		fmt.Fprintf(w, "%s", string(b))
+		return
+	}
+	pattern := r.URL.Query().Get("pattern") == "true"
+	messages, err := con.Subscribe(r.Context(), topic, pattern)
+	if err != nil {
+		msg := "SubscribeHandler %v"
+		con.Error(msg, err)
+		b := responseErrorFormat(http.StatusServiceUnavailable, w, msg, err)
+		fmt.Fprintf(w, "%s", string(b))
+		return
+	}
+
+	w.Header().Set(CONTENTTYPE, EVENTSTREAM)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, ": subscribed %s\n\n", topic)
+	flusher.Flush()
+	con.Info("SubscribeHandler %s subscribed (pattern %t)", topic, pattern)
+
+	interval := config.Get().SseHeartbeat
+	if interval <= 0 {
+		interval = SSEHEARTBEAT
+	}
+	heartbeat := time.NewTicker(interval)
+	defer heartbeat.Stop()
+	var id int64
+	for {
+		select {
+		case <-r.Context().Done():
+			con.Info("SubscribeHandler %s client disconnected after %d messages", topic, id)
+			return
+		case <-heartbeat.C:
+			fmt.Fprintf(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case msg, ok := <-messages:
+			if !ok {
+				con.Info("SubscribeHandler %s subscription closed after %d messages", topic, id)
+				return
+			}
+			// a pattern may match channels outside the namespace
+			if !topics.Match(namespace, msg.Channel) || !filter.match(msg) {
+				continue
+			}
+			id++
+			data, _ := json.Marshal(msg)
+			fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n\n", id, data)
+			flusher.Flush()
+		}
+	}
+}
+
+func parseMessageFilter(r *http.Request) (*messageFilter, error) {
+	q := r.URL.Query()
+	f := &messageFilter{contains: q["contains"], fields: map[string]string{}}
+	for _, field := range q["field"] {
+		path, value, ok := strings.Cut(field, "=")
+		if !ok || path == "" {
+			return nil, fmt.Errorf("field filter %q must be path=value", field)
+		}
+		f.fields[path] = value
+	}
+	return f, nil
+}
+
+func (f *messageFilter) match(msg *schema.PubSubMessage) bool {
+	for _, s := range f.contains {
+		if !strings.Contains(msg.Payload, s) {
+			return false
+		}
+	}
+	if len(f.fields) == 0 {
+		return true
+	}
+	// numbers are compared as written (1234567, not 1.234567e+06)
+	var doc interface{}
+	if err := docpath.Decode(strings.NewReader(msg.Payload), &doc); err != nil {
+		return false
+	}
+	for path, value := range f.fields {
+		if v, ok := docpath.Lookup(doc, path); !ok || fmt.Sprint(v) != value {
+			return false
+		}
+	}
+	return true
+}
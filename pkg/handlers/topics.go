@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/connectors"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/topics"
+)
+
+// ListTopicsHandler - lists the live channels of the namespace (PUBSUB CHANNELS / NUMSUB / NUMPAT)
+// together with the topics this instance published to, with subscriber counts and publish activity
+func ListTopicsHandler(w http.ResponseWriter, r *http.Request, con connectors.Clients) {
+	con = con.WithContext(r.Context())
+	addHeaders(w, r)
+	namespace := topics.Namespace()
+	counts, err := con.ListChannels(r.Context(), namespace, topics.Names(namespace)...)
+	if err != nil {
+		topicsError(w, con, "ListTopicsHandler", err)
+		return
+	}
+	patterns, err := con.CountPatterns(r.Context())
+	if err != nil {
+		topicsError(w, con, "ListTopicsHandler", err)
+		return
+	}
+	names := make([]string, 0, len(counts))
+	for topic := range counts {
+		names = append(names, topic)
+	}
+	sort.Strings(names)
+	response := &schema.Topics{Namespace: namespace, PatternSubscribers: patterns, Topics: []*schema.TopicStats{}}
+	for _, topic := range names {
+		response.Topics = append(response.Topics, topicStats(topic, counts[topic]))
+	}
+	w.WriteHeader(http.StatusOK)
+	b, _ := json.MarshalIndent(response, "", "	")
+	fmt.Fprintf(w, "%s", string(b))
+}
+
+// GetTopicHandler - subscriber count and publish activity of a single topic of the namespace
+func GetTopicHandler(w http.ResponseWriter, r *http.Request, con connectors.Clients) {
+	con = con.WithContext(r.Context())
+	addHeaders(w, r)
+	topic := mux.Vars(r)["topic"]
+	namespace := topics.Namespace()
+	if !topics.Match(namespace, topic) {
+		msg := "GetTopicHandler topic %s is not in the namespace %s"
+		b := responseErrorFormat(http.StatusNotFound, w, msg, topic, namespace)
+		fmt.Fprintf(w, "%s", string(b))
+		return
+	}
+	counts, err := con.ListChannels(r.Context(), topic, topic)
+	if err != nil {
+		topicsError(w, con, "GetTopicHandler", err)
+		return
+	}
+	if _, ok := topics.Get(topic); !ok && counts[topic] == 0 {
+		msg := "GetTopicHandler topic %s has no subscribers or publishes"
+		b := responseErrorFormat(http.StatusNotFound, w, msg, topic)
+		fmt.Fprintf(w, "%s", string(b))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	b, _ := json.MarshalIndent(topicStats(topic, counts[topic]), "", "	")
+	fmt.Fprintf(w, "%s", string(b))
+}
+
+// topicsError - redis is unavailable (503)
+func topicsError(w http.ResponseWriter, con connectors.Clients, name string, err error) {
+	msg := name + " %v"
+	con.Error(msg, err)
+	b := responseErrorFormat(http.StatusServiceUnavailable, w, msg, err)
+	fmt.Fprintf(w, "%s", string(b))
+}
+
+func topicStats(topic string, subscribers int64) *schema.TopicStats {
+	stats := &schema.TopicStats{Topic: topic, Subscribers: subscribers}
+	if c, ok := topics.Get(topic); ok {
+		stats.Published, stats.Errors = c.Published, c.Errors
+		stats.MessageRate, stats.ErrorRate = c.Rates(time.Now())
+		if !c.LastPublish.IsZero() {
+			stats.LastPublish = c.LastPublish.UnixMilli()
+		}
+	}
+	return stats
+}
@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/connectors"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/metrics"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/ratelimit"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/topics"
+)
+
+const (
+	WSPUBLISH     string = "publish"
+	WSSUBSCRIBE   string = "subscribe"
+	WSUNSUBSCRIBE string = "unsubscribe"
+	WSMESSAGE     string = "message"
+	WSACK         string = "ack"
+	WSERROR       string = "error"
+	// slow consumer policies (WS_SLOW_CONSUMER)
+	WSDISCONNECT string = "disconnect"
+	WSDROP       string = "drop"
+	// a connection that does not answer a ping within WSPONGWAIT is closed
+	WSPONGWAIT   time.Duration = 60 * time.Second
+	WSPINGPERIOD time.Duration = WSPONGWAIT * 9 / 10
+	WSWRITEWAIT  time.Duration = 10 * time.Second
+)
+
+// wsConn - a websocket connection, frames to the client are queued in send (WS_SEND_BUFFER)
+type wsConn struct {
+	ws      *websocket.Conn
+	con     connectors.Clients
+	r       *http.Request
+	ctx     context.Context
+	cancel  context.CancelFunc
+	send    chan *schema.WebSocketFrame
+	slow    string
+	dropped int64
+	// subscriptions are only changed by the read loop
+	subs map[string]context.CancelFunc
+}
+
+// WebSocketHandler - publish, subscribe and unsubscribe over one websocket (see schema.WebSocketFrame)
+// publish frames go through the same pipeline, client rate limit and dead letters as SendPayloadHandler,
+// subscriptions are limited to the topic namespace, the origin is checked against the cors policy
+// when the send buffer is full message frames are dropped or the client is disconnected (WS_SLOW_CONSUMER)
+func WebSocketHandler(w http.ResponseWriter, r *http.Request, con connectors.Clients) {
+	con = con.WithContext(r.Context())
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || configCorsPolicy().allowed(origin) != ""
+	}}
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already responded
+		con.Error("WebSocketHandler %v", err)
+		return
+	}
+	cfg := config.Get()
+	size := cfg.WsSendBuffer
+	if size < 1 {
+		size = 1
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	c := &wsConn{ws: ws, con: con, r: r, ctx: ctx, cancel: cancel, send: make(chan *schema.WebSocketFrame, size), slow: cfg.WsSlowConsumer, subs: map[string]context.CancelFunc{}}
+	con.Info("WebSocketHandler connected %s", ratelimit.ClientKey(r))
+	go c.writeLoop()
+	c.readLoop()
+	c.cancel()
+	ws.Close()
+	con.Info("WebSocketHandler disconnected %s (%d message frames dropped)", ratelimit.ClientKey(r), atomic.LoadInt64(&c.dropped))
+}
+
+func (c *wsConn) readLoop() {
+	c.ws.SetReadLimit(BodyLimit("/api/v1/ws"))
+	c.ws.SetReadDeadline(time.Now().Add(WSPONGWAIT))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(WSPONGWAIT))
+	})
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) && c.ctx.Err() == nil {
+				c.con.Error("WebSocketHandler read %v", err)
+			}
+			return
+		}
+		frame := &schema.WebSocketFrame{}
+		if err := json.Unmarshal(data, frame); err != nil {
+			c.reply(&schema.WebSocketFrame{Type: WSERROR, Code: http.StatusBadRequest, Error: "invalid frame " + err.Error()})
+			continue
+		}
+		switch frame.Type {
+		case WSPUBLISH:
+			c.publish(frame)
+		case WSSUBSCRIBE:
+			c.subscribe(frame)
+		case WSUNSUBSCRIBE:
+			c.unsubscribe(frame)
+		default:
+			c.reply(&schema.WebSocketFrame{Type: WSERROR, ID: frame.ID, Code: http.StatusBadRequest, Error: "unknown frame type " + frame.Type})
+		}
+	}
+}
+
+func (c *wsConn) writeLoop() {
+	ping := time.NewTicker(WSPINGPERIOD)
+	defer ping.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case frame := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(WSWRITEWAIT))
+			if err := c.ws.WriteJSON(frame); err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ping.C:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(WSWRITEWAIT)); err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		}
+	}
+}
+
+// publish - as SendPayloadHandler, the frame headers are added to the upgrade request headers
+func (c *wsConn) publish(frame *schema.WebSocketFrame) {
+	if err := ratelimit.Allow(c.ctx, c.con, ratelimit.SCOPECLIENT, ratelimit.ClientKey(c.r)); err != nil {
+		var lerr *ratelimit.LimitError
+		if errors.As(err, &lerr) {
+			metrics.Throttled.WithLabelValues(lerr.Scope).Inc()
+			c.reply(&schema.WebSocketFrame{Type: WSERROR, ID: frame.ID, Code: http.StatusTooManyRequests, Error: err.Error()})
+			return
+		}
+		// fail open as RateLimitMiddleware
+		c.con.Error("WebSocketHandler %v", err)
+	}
+	header := c.r.Header.Clone()
+	header.Set(CONTENTTYPE, APPLICATIONJSON)
+	for k, v := range frame.Headers {
+		header.Set(k, v)
+	}
+	meta, code, err := Publish(c.ctx, frame.Payload, header, c.con)
+	if err != nil {
+		c.reply(&schema.WebSocketFrame{Type: WSERROR, ID: frame.ID, Code: code, Error: err.Error(), Errors: errorDetails(err)})
+		return
+	}
+	c.reply(&schema.WebSocketFrame{Type: WSACK, ID: frame.ID, Meta: meta})
+}
+
+func (c *wsConn) subscribe(frame *schema.WebSocketFrame) {
+	namespace := topics.Namespace()
+	if !topics.Match(namespace, frame.Topic) {
+		c.reply(&schema.WebSocketFrame{Type: WSERROR, ID: frame.ID, Code: http.StatusNotFound, Error: "topic " + frame.Topic + " is not in the namespace " + namespace})
+		return
+	}
+	if _, exists := c.subs[frame.Topic]; exists {
+		c.reply(&schema.WebSocketFrame{Type: WSERROR, ID: frame.ID, Code: http.StatusConflict, Error: "already subscribed to " + frame.Topic})
+		return
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	messages, err := c.con.Subscribe(ctx, frame.Topic, frame.Pattern)
+	if err != nil {
+		cancel()
+		c.con.Error("WebSocketHandler %v", err)
+		c.reply(&schema.WebSocketFrame{Type: WSERROR, ID: frame.ID, Code: http.StatusServiceUnavailable, Error: err.Error()})
+		return
+	}
+	c.subs[frame.Topic] = cancel
+	go func(topic string) {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				if topics.Match(namespace, msg.Channel) {
+					c.deliver(&schema.WebSocketFrame{Type: WSMESSAGE, Topic: topic, Channel: msg.Channel, Payload: rawPayload(msg.Payload)})
+				}
+			}
+		}
+	}(frame.Topic)
+	c.reply(&schema.WebSocketFrame{Type: WSACK, ID: frame.ID, Topic: frame.Topic})
+}
+
+func (c *wsConn) unsubscribe(frame *schema.WebSocketFrame) {
+	cancel, ok := c.subs[frame.Topic]
+	delete(c.subs, frame.Topic)
+	if !ok {
+		c.reply(&schema.WebSocketFrame{Type: WSERROR, ID: frame.ID, Code: http.StatusNotFound, Error: "not subscribed to " + frame.Topic})
+		return
+	}
+	cancel()
+	c.reply(&schema.WebSocketFrame{Type: WSACK, ID: frame.ID, Topic: frame.Topic})
+}
+
+// reply - acks and errors wait for room in the send buffer
+func (c *wsConn) reply(frame *schema.WebSocketFrame) {
+	select {
+	case c.send <- frame:
+	case <-c.ctx.Done():
+	}
+}
+
+// deliver - queues a message frame, applying the slow consumer policy when the send buffer is full
+func (c *wsConn) deliver(frame *schema.WebSocketFrame) bool {
+	select {
+	case c.send <- frame:
+		return true
+	case <-c.ctx.Done():
+		return false
+	default:
+	}
+	if c.slow == WSDROP {
+		atomic.AddInt64(&c.dropped, 1)
+		return false
+	}
+	c.con.Error("WebSocketHandler slow consumer %s, %d frames queued, disconnecting", ratelimit.ClientKey(c.r), len(c.send))
+	c.close(websocket.ClosePolicyViolation, "slow consumer")
+	return false
+}
+
+// close - sends the close frame (WriteControl may be called concurrently) and ends both loops
+func (c *wsConn) close(code int, text string) {
+	if code != websocket.CloseAbnormalClosure {
+		c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(WSWRITEWAIT))
+	}
+	c.cancel()
+	c.ws.Close()
+}
+
+// rawPayload - json payloads are embedded as is, anything else as a json string
+func rawPayload(payload string) json.RawMessage {
+	if json.Valid([]byte(payload)) {
+		return json.RawMessage(payload)
+	}
+	b, _ := json.Marshal(payload)
+	return b
+}
@@ -0,0 +1,190 @@
+package metrics
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	NAMESPACE      string = "redis_publisher"
+	OUTCOMESUCCESS string = "success"
+	OUTCOMEERROR   string = "error"
+	OUTCOMECACHED  string = "cached"
+	// topic label of every topic after the first MAXTOPICLABELS (clients choose topics with CE_TYPE_TOPIC)
+	OTHERTOPIC     string = "other"
+	MAXTOPICLABELS int    = 100
+)
+
+var (
+	topicMu     sync.Mutex
+	topicLabels = map[string]bool{}
+)
+
+var (
+	// HttpDuration - request duration by route template
+	HttpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "http_duration_seconds",
+		Help:      "Duration of HTTP requests.",
+	}, []string{"path"})
+
+	// Publishes - publish attempts by topic and outcome (success or the pipeline stage that failed)
+	Publishes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "publishes_total",
+		Help:      "Publish attempts by topic and outcome.",
+	}, []string{"topic", "outcome"})
+
+	// PayloadSize - size of the incoming payload and of the published message
+	PayloadSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "payload_size_bytes",
+		Help:      "Size of incoming payloads and published messages.",
+		Buckets:   prometheus.ExponentialBuckets(64, 4, 8),
+	}, []string{"topic", "direction"})
+
+	// GrpcDuration - grpc call duration by method and status code
+	GrpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "grpc_duration_seconds",
+		Help:      "Duration of gRPC calls.",
+	}, []string{"method", "code"})
+
+	// TemplateDuration - time spent rendering the publish template
+	TemplateDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "template_render_seconds",
+		Help:      "Duration of template rendering.",
+		Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 8),
+	}, []string{"topic"})
+
+	// RedisDuration - redis round trip latency by command and outcome
+	RedisDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "redis_duration_seconds",
+		Help:      "Redis round trip latency.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 8),
+	}, []string{"command", "outcome"})
+
+	// Receivers - number of subscribers that received each published message
+	Receivers = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "receivers",
+		Help:      "Subscribers that received a published message.",
+		Buckets:   []float64{0, 1, 2, 5, 10, 25, 50, 100},
+	}, []string{"topic"})
+
+	// ValidationFailures - payloads rejected by the input or output json schema
+	ValidationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "validation_failures_total",
+		Help:      "Payloads rejected by schema validation.",
+	}, []string{"topic", "schema"})
+
+	// Throttled - requests rejected by the client or topic rate limit
+	Throttled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "throttled_total",
+		Help:      "Requests rejected by rate limiting.",
+	}, []string{"scope"})
+
+	// EnrichmentLookups - enrichment lookups by name and outcome (success, error or cached)
+	EnrichmentLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "enrichment_lookups_total",
+		Help:      "Enrichment lookups by name and outcome.",
+	}, []string{"lookup", "outcome"})
+
+	// OutputViolations - rendered messages rejected by the output schema
+	OutputViolations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "output_schema_violations_total",
+		Help:      "Rendered messages rejected by the output schema.",
+	}, []string{"topic"})
+)
+
+// Outcome - label value for an error (success when nil)
+func Outcome(err error) string {
+	if err != nil {
+		return OUTCOMEERROR
+	}
+	return OUTCOMESUCCESS
+}
+
+// Topic - the topic label value, topics seen after the first MAXTOPICLABELS share the OTHERTOPIC label
+// so the number of series stays bounded
+func Topic(topic string) string {
+	topicMu.Lock()
+	defer topicMu.Unlock()
+	if topicLabels[topic] {
+		return topic
+	}
+	if len(topicLabels) >= MAXTOPICLABELS {
+		return OTHERTOPIC
+	}
+	topicLabels[topic] = true
+	return topic
+}
+
+// poolCollector - exposes the redis connection pool stats at scrape time
+type poolCollector struct {
+	stats    func() *redis.PoolStats
+	hits     *prometheus.Desc
+	misses   *prometheus.Desc
+	timeouts *prometheus.Desc
+	total    *prometheus.Desc
+	idle     *prometheus.Desc
+	stale    *prometheus.Desc
+}
+
+// NewPoolCollector - stats is usually (*redis.Client).PoolStats
+func NewPoolCollector(stats func() *redis.PoolStats) prometheus.Collector {
+	desc := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(NAMESPACE, "redis_pool", name), help, nil, nil)
+	}
+	return &poolCollector{
+		stats:    stats,
+		hits:     desc("hits_total", "Free connections found in the pool."),
+		misses:   desc("misses_total", "Free connections not found in the pool."),
+		timeouts: desc("timeouts_total", "Waits for a connection that timed out."),
+		total:    desc("connections", "Connections in the pool."),
+		idle:     desc("idle_connections", "Idle connections in the pool."),
+		stale:    desc("stale_connections_total", "Stale connections removed from the pool."),
+	}
+}
+
+func (p *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.hits
+	ch <- p.misses
+	ch <- p.timeouts
+	ch <- p.total
+	ch <- p.idle
+	ch <- p.stale
+}
+
+func (p *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := p.stats()
+	ch <- prometheus.MustNewConstMetric(p.hits, prometheus.CounterValue, float64(s.Hits))
+	ch <- prometheus.MustNewConstMetric(p.misses, prometheus.CounterValue, float64(s.Misses))
+	ch <- prometheus.MustNewConstMetric(p.timeouts, prometheus.CounterValue, float64(s.Timeouts))
+	ch <- prometheus.MustNewConstMetric(p.total, prometheus.GaugeValue, float64(s.TotalConns))
+	ch <- prometheus.MustNewConstMetric(p.idle, prometheus.GaugeValue, float64(s.IdleConns))
+	ch <- prometheus.MustNewConstMetric(p.stale, prometheus.CounterValue, float64(s.StaleConns))
+}
+
+// RegisterPool - registers the pool collector with the default registry
+// registering again (a new client) replaces the previous collector
+func RegisterPool(stats func() *redis.PoolStats) error {
+	c := NewPoolCollector(stats)
+	err := prometheus.Register(c)
+	var are prometheus.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		prometheus.Unregister(are.ExistingCollector)
+		return prometheus.Register(c)
+	}
+	return err
+}
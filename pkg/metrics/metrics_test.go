@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestMetrics(t *testing.T) {
+
+	t.Run("Outcome : should pass", func(t *testing.T) {
+		if Outcome(nil) != OUTCOMESUCCESS || Outcome(errors.New("boom")) != OUTCOMEERROR {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect outcome", "Outcome"))
+		}
+	})
+
+	t.Run("Topic : should pass (topics after MAXTOPICLABELS share the other label)", func(t *testing.T) {
+		if Topic("orders") != "orders" {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect label", "Topic"))
+		}
+		for i := 0; i < MAXTOPICLABELS; i++ {
+			Topic(fmt.Sprintf("orders.%d", i))
+		}
+		if got := Topic("orders.new"); got != OTHERTOPIC {
+			t.Errorf(fmt.Sprintf("Function %s should collapse topics over the cap - got (%s)", "Topic", got))
+		}
+		if Topic("orders") != "orders" || Topic("orders.0") != "orders.0" {
+			t.Errorf(fmt.Sprintf("Function %s should keep the labels of earlier topics", "Topic"))
+		}
+	})
+
+	t.Run("RegisterPool : should pass (registered twice)", func(t *testing.T) {
+		stats := &redis.PoolStats{Hits: 3, TotalConns: 2, IdleConns: 1}
+		if err := RegisterPool(func() *redis.PoolStats { return stats }); err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned error %v", "RegisterPool", err))
+		}
+		if err := RegisterPool(func() *redis.PoolStats { return stats }); err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned error %v", "RegisterPool", err))
+		}
+		want := `
+# HELP redis_publisher_redis_pool_hits_total Free connections found in the pool.
+# TYPE redis_publisher_redis_pool_hits_total counter
+redis_publisher_redis_pool_hits_total 3
+# HELP redis_publisher_redis_pool_idle_connections Idle connections in the pool.
+# TYPE redis_publisher_redis_pool_idle_connections gauge
+redis_publisher_redis_pool_idle_connections 1
+`
+		c := NewPoolCollector(func() *redis.PoolStats { return stats })
+		if err := testutil.CollectAndCompare(c, strings.NewReader(want), "redis_publisher_redis_pool_hits_total", "redis_publisher_redis_pool_idle_connections"); err != nil {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect metrics %v", "NewPoolCollector", err))
+		}
+	})
+}
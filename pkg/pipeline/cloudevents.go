@@ -0,0 +1,175 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/connectors"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/topics"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/tracing"
+)
+
+const (
+	CLOUDEVENTSJSON string = "application/cloudevents+json"
+	CESPECVERSION   string = "1.0"
+	CEHEADERPREFIX  string = "Ce-"
+)
+
+var ceExtensionName = regexp.MustCompile(`^[a-z0-9]{1,20}$`)
+
+// isCloudEvent - structured mode is signalled by the content type, binary mode by the ce-specversion header
+func isCloudEvent(header http.Header) bool {
+	mt, _, _ := mime.ParseMediaType(header.Get(CONTENTTYPE))
+	return mt == CLOUDEVENTSJSON || header.Get(CEHEADERPREFIX+"Specversion") != ""
+}
+
+// parseCloudEvent - decodes a cloudevent in either structured or binary http mode and validates it
+func parseCloudEvent(body []byte, header http.Header) (*schema.CloudEvent, error) {
+	ce := &schema.CloudEvent{}
+	mt, _, _ := mime.ParseMediaType(header.Get(CONTENTTYPE))
+	if mt == CLOUDEVENTSJSON {
+		if err := json.Unmarshal(body, ce); err != nil {
+			return nil, fmt.Errorf("could not unmarshal structured cloudevent %v", err)
+		}
+	} else {
+		for k, v := range header {
+			if !strings.HasPrefix(k, CEHEADERPREFIX) || len(v) == 0 {
+				continue
+			}
+			name := strings.ToLower(strings.TrimPrefix(k, CEHEADERPREFIX))
+			value, err := url.PathUnescape(v[0])
+			if err != nil {
+				value = v[0]
+			}
+			switch name {
+			case "specversion":
+				ce.SpecVersion = value
+			case "id":
+				ce.ID = value
+			case "source":
+				ce.Source = value
+			case "type":
+				ce.Type = value
+			case "dataschema":
+				ce.DataSchema = value
+			case "subject":
+				ce.Subject = value
+			case "time":
+				ce.Time = value
+			default:
+				if ce.Extensions == nil {
+					ce.Extensions = map[string]interface{}{}
+				}
+				ce.Extensions[name] = value
+			}
+		}
+		ce.DataContentType = header.Get(CONTENTTYPE)
+		if len(body) > 0 {
+			if json.Valid(body) {
+				ce.Data = json.RawMessage(body)
+			} else {
+				ce.DataBase64 = base64.StdEncoding.EncodeToString(body)
+			}
+		}
+	}
+	return ce, validateCloudEvent(ce)
+}
+
+// validateCloudEvent - checks the required attributes and the format of the optional ones
+func validateCloudEvent(ce *schema.CloudEvent) error {
+	var errs []error
+	if ce.SpecVersion != CESPECVERSION {
+		errs = append(errs, fmt.Errorf("specversion must be %s (got %q)", CESPECVERSION, ce.SpecVersion))
+	}
+	if ce.ID == "" {
+		errs = append(errs, errors.New("id is required"))
+	}
+	if ce.Source == "" {
+		errs = append(errs, errors.New("source is required"))
+	} else if _, err := url.Parse(ce.Source); err != nil {
+		errs = append(errs, fmt.Errorf("source must be a uri-reference %v", err))
+	}
+	if ce.Type == "" {
+		errs = append(errs, errors.New("type is required"))
+	}
+	if ce.Time != "" {
+		if _, err := time.Parse(time.RFC3339, ce.Time); err != nil {
+			errs = append(errs, fmt.Errorf("time must be rfc3339 %v", err))
+		}
+	}
+	if ce.DataSchema != "" {
+		if u, err := url.Parse(ce.DataSchema); err != nil || !u.IsAbs() {
+			errs = append(errs, errors.New("dataschema must be an absolute uri"))
+		}
+	}
+	if len(ce.Data) > 0 && ce.DataBase64 != "" {
+		errs = append(errs, errors.New("data and data_base64 are mutually exclusive"))
+	}
+	for k := range ce.Extensions {
+		if !ceExtensionName.MatchString(k) {
+			errs = append(errs, fmt.Errorf("extension attribute %q must be lower case alphanumeric (max 20)", k))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// cloudEventData - returns the raw event data that is handed to the transform
+func cloudEventData(ce *schema.CloudEvent) ([]byte, error) {
+	if ce.DataBase64 != "" {
+		return base64.StdEncoding.DecodeString(ce.DataBase64)
+	}
+	return ce.Data, nil
+}
+
+// cloudEventTopic - the CE_TYPE_TOPIC envar routes events to a topic named after ce-type
+// only types within the topic namespace are routed, clients can not publish to other (internal) channels
+func cloudEventTopic(ce *schema.CloudEvent, topic string) (string, error) {
+	if !config.Get().CETypeTopic {
+		return topic, nil
+	}
+	if namespace := topics.Namespace(); !topics.Match(namespace, ce.Type) {
+		return "", fmt.Errorf("type %s is not in the topic namespace %s", ce.Type, namespace)
+	}
+	return ce.Type, nil
+}
+
+// wrapCloudEvent - the published message is always a structured mode json cloudevent
+// binary encodings are carried in data_base64, the schema version and request id are added as extensions
+// along with the trace context (distributed tracing extension)
+func wrapCloudEvent(ctx context.Context, ce *schema.CloudEvent, topic string, contentType string, data []byte) ([]byte, error) {
+	out := *ce
+	out.Extensions = map[string]interface{}{}
+	for k, v := range ce.Extensions {
+		out.Extensions[k] = v
+	}
+	out.Extensions["schemaversion"] = schemaVersion(topic)
+	if id := connectors.RequestId(ctx); id != "" {
+		out.Extensions["requestid"] = id
+	}
+	for k, v := range tracing.Inject(ctx) {
+		out.Extensions[k] = v
+	}
+	out.DataBase64 = ""
+	out.Data = nil
+	out.DataContentType = contentType
+	switch {
+	case contentType != APPLICATIONJSON:
+		out.DataBase64 = base64.StdEncoding.EncodeToString(data)
+	case json.Valid(data):
+		out.Data = json.RawMessage(data)
+	default:
+		out.Data, _ = json.Marshal(string(data))
+	}
+	return json.Marshal(&out)
+}
@@ -0,0 +1,104 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/connectors"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/tracing"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/validator"
+)
+
+const (
+	CORRELATIONID string = "X-Correlation-ID"
+)
+
+const (
+	// message ids count milliseconds from 2024-01-01 UTC
+	IDEPOCH int64 = 1704067200000
+)
+
+// message id layout : 41 bits millisecond timestamp, 6 bits node, 6 bits sequence
+// 53 bits so json consumers decoding numbers as float64 keep the id exact
+var (
+	idMutex    sync.Mutex
+	idNode     = randomNode()
+	idLastMs   int64
+	idSequence int64
+)
+
+// nextMessageId - returns an int64 id (below 2^53) that is unique per instance and sortable by time
+func nextMessageId() int64 {
+	idMutex.Lock()
+	defer idMutex.Unlock()
+	ms := time.Now().UnixMilli() - IDEPOCH
+	if ms <= idLastMs {
+		idSequence = (idSequence + 1) & 0x3f
+		if idSequence == 0 {
+			idLastMs++
+		}
+		ms = idLastMs
+	} else {
+		idSequence = 0
+	}
+	idLastMs = ms
+	return ms<<12 | idNode<<6 | idSequence
+}
+
+func randomNode() int64 {
+	var b [2]byte
+	_, _ = rand.Read(b[:])
+	return int64(binary.BigEndian.Uint16(b[:]) & 0x3f)
+}
+
+// envelopeEnabled - the ENVELOPE setting switches the envelope on
+func envelopeEnabled() bool {
+	return config.Get().Envelope
+}
+
+// schemaVersion - the schema registry version of the topic, SCHEMA_VERSION (default 1.0) when not registered
+func schemaVersion(topic string) string {
+	if v := validator.TopicVersion(topic); v > 0 {
+		return strconv.Itoa(v)
+	}
+	if v := config.Get().SchemaVersion; v != "" {
+		return v
+	}
+	return "1.0"
+}
+
+// wrapEnvelope - wraps the encoded message with provenance metadata
+// the request id and trace context are taken from ctx so subscribers can continue the trace
+func wrapEnvelope(ctx context.Context, topic string, correlationId string, contentType string, data []byte) (*schema.Envelope, []byte, error) {
+	trace := tracing.Inject(ctx)
+	version := schemaVersion(topic)
+	// json is embedded as is, anything else (binary encodings or invalid json) as a json (base64) string
+	raw := json.RawMessage(data)
+	if contentType != APPLICATIONJSON {
+		raw, _ = json.Marshal(data)
+	} else if !json.Valid(data) {
+		raw, _ = json.Marshal(string(data))
+	}
+	env := &schema.Envelope{
+		SchemaInterface: schema.SchemaInterface{ID: nextMessageId(), LastUpdate: time.Now().UnixMilli()},
+		Source:          config.Get().Name,
+		Version:         config.Get().Version,
+		Topic:           topic,
+		ContentType:     contentType,
+		CorrelationID:   correlationId,
+		RequestID:       connectors.RequestId(ctx),
+		TraceParent:     trace["traceparent"],
+		TraceState:      trace["tracestate"],
+		SchemaVersion:   version,
+		Data:            raw,
+	}
+	b, err := json.Marshal(env)
+	return env, b, err
+}
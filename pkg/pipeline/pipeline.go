@@ -0,0 +1,156 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/connectors"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/metrics"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/topics"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	CONTENTTYPE     string = "Content-Type"
+	APPLICATIONJSON string = "application/json"
+	// stages
+	STAGEDECODE    string = "decode"
+	STAGEVALIDATE  string = "validate"
+	STAGEENRICH    string = "enrich"
+	STAGETRANSFORM string = "transform"
+	STAGEENCODE    string = "encode"
+	STAGEROUTE     string = "route"
+	STAGEPUBLISH   string = "publish"
+	// finer grained failures reported by the stages (dead letters and the publishes_total outcome)
+	STAGEUNMARSHAL string = "unmarshal"
+	STAGELIMIT     string = "ratelimit"
+	STAGEOUTPUT    string = "output"
+	STAGEENCRYPT   string = "encrypt"
+)
+
+// Message - the payload as it moves through the stages
+type Message struct {
+	// the body and headers as received (dead letters keep these)
+	Body   []byte
+	Header http.Header
+	// resolved by decode (TOPIC or the cloudevent type with CE_TYPE_TOPIC)
+	Topic   string
+	Event   *schema.CloudEvent
+	Request *schema.CustomerPayload
+	// the payload of the current stage : the (cloudevent) data, the rendered template, the encoded
+	// message and finally the message published
+	Data        []byte
+	ContentType string
+	// the envelope or cloudevent metadata (nil when neither)
+	Meta *schema.SchemaInterface
+}
+
+// StageFunc - one step of the pipeline, it updates the message in place
+type StageFunc func(ctx context.Context, con connectors.Clients, msg *Message) error
+
+// Stage - a named step, the name is reported when the stage fails (unless it returns a StageError)
+type Stage struct {
+	Name string
+	Run  StageFunc
+}
+
+// Middleware - wraps every stage (i.e. tracing, timing, auditing)
+type Middleware func(name string, next StageFunc) StageFunc
+
+// StageError - lets a stage report a finer grained failure (i.e. unmarshal within decode)
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// Fail - the error reported as stage
+func Fail(stage string, err error) error {
+	return &StageError{Stage: stage, Err: err}
+}
+
+// Pipeline - the stages run in order until one fails
+type Pipeline struct {
+	stages     []Stage
+	middleware []Middleware
+}
+
+func New(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: stages}
+}
+
+// Use - adds middleware, the first one added is the outermost
+func (p *Pipeline) Use(mw ...Middleware) *Pipeline {
+	p.middleware = append(p.middleware, mw...)
+	return p
+}
+
+// Run - runs every stage on the message, returns the stage that failed along with the error
+func (p *Pipeline) Run(ctx context.Context, con connectors.Clients, msg *Message) (string, error) {
+	for _, s := range p.stages {
+		run := s.Run
+		for i := len(p.middleware) - 1; i >= 0; i-- {
+			run = p.middleware[i](s.Name, run)
+		}
+		if err := run(ctx, con, msg); err != nil {
+			var serr *StageError
+			if errors.As(err, &serr) {
+				return serr.Stage, serr.Err
+			}
+			return s.Name, err
+		}
+	}
+	return "", nil
+}
+
+// Default - decode, validate, enrich, transform, encode, route and publish, each stage traced
+func Default() *Pipeline {
+	return New(
+		Stage{STAGEDECODE, Decode},
+		Stage{STAGEVALIDATE, Validate},
+		Stage{STAGEENRICH, Enrich},
+		Stage{STAGETRANSFORM, Transform},
+		Stage{STAGEENCODE, Encode},
+		Stage{STAGEROUTE, Route},
+		Stage{STAGEPUBLISH, Publish},
+	).Use(Tracing)
+}
+
+// Process - runs the default pipeline on a raw body, the outcome is counted per topic
+// (publishes_total and the topic activity), returns the message metadata or the stage that failed along with the error
+func Process(ctx context.Context, con connectors.Clients, body []byte, header http.Header) (*schema.SchemaInterface, string, error) {
+	msg := &Message{Body: body, Header: header, Topic: config.Get().Topic}
+	stage, err := Default().Run(ctx, con, msg)
+	outcome := stage
+	if err == nil {
+		outcome = metrics.OUTCOMESUCCESS
+	}
+	metrics.Publishes.WithLabelValues(metrics.Topic(msg.Topic), outcome).Inc()
+	topics.Record(msg.Topic, err)
+	if err != nil {
+		return nil, stage, err
+	}
+	return msg.Meta, "", nil
+}
+
+// Tracing - one span per stage, the stage runs with the span context
+func Tracing(name string, next StageFunc) StageFunc {
+	return func(ctx context.Context, con connectors.Clients, msg *Message) (err error) {
+		ctx, span := tracing.Start(ctx, name, attribute.String("topic", msg.Topic))
+		defer func() {
+			tracing.End(span, err)
+		}()
+		return next(ctx, con, msg)
+	}
+}
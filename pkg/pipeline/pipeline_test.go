@@ -0,0 +1,212 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/connectors"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/enrich"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/routes"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+	"github.com/microlib/simple"
+)
+
+const (
+	PAYLOAD string = `{ "request":{"email":"abc@xyz.com", "number":"1234567"}}`
+)
+
+func TestPipeline(t *testing.T) {
+
+	logger := &simple.Logger{Level: "info"}
+	os.Setenv("TOPIC", "test")
+	defer config.Set(nil)
+	ctx := context.Background()
+
+	t.Run("Run : should pass (middleware wraps every stage, first added outermost)", func(t *testing.T) {
+		conn := connectors.NewTestConnectors("", 200, logger)
+		calls := []string{}
+		stage := func(name string) Stage {
+			return Stage{name, func(ctx context.Context, con connectors.Clients, msg *Message) error {
+				calls = append(calls, name)
+				return nil
+			}}
+		}
+		mw := func(id string) Middleware {
+			return func(name string, next StageFunc) StageFunc {
+				return func(ctx context.Context, con connectors.Clients, msg *Message) error {
+					calls = append(calls, id+":"+name)
+					return next(ctx, con, msg)
+				}
+			}
+		}
+		_, err := New(stage("a"), stage("b")).Use(mw("1"), mw("2")).Run(ctx, conn, &Message{})
+		want := "1:a,2:a,a,1:b,2:b,b"
+		if err != nil || strings.Join(calls, ",") != want {
+			t.Errorf(fmt.Sprintf("Function %s called incorrect stages - got (%s) wanted (%s)", "Run", strings.Join(calls, ","), want))
+		}
+	})
+
+	t.Run("Run : should fail (stage name or the StageError stage reported)", func(t *testing.T) {
+		conn := connectors.NewTestConnectors("", 200, logger)
+		failing := func(err error) StageFunc {
+			return func(ctx context.Context, con connectors.Clients, msg *Message) error {
+				return err
+			}
+		}
+		stage, err := New(Stage{"a", failing(nil)}, Stage{"b", failing(errors.New("b failed"))}).Run(ctx, conn, &Message{})
+		if stage != "b" || err == nil {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect stage - got (%s %v)", "Run", stage, err))
+		}
+		stage, err = New(Stage{"a", failing(Fail("inner", errors.New("inner failed")))}).Run(ctx, conn, &Message{})
+		var serr *StageError
+		if stage != "inner" || err == nil || errors.As(err, &serr) {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect stage - got (%s %v)", "Run", stage, err))
+		}
+	})
+
+	t.Run("Decode : should pass (plain payload)", func(t *testing.T) {
+		conn := connectors.NewTestConnectors("", 200, logger)
+		msg := &Message{Body: []byte(PAYLOAD), Header: http.Header{}, Topic: "test"}
+		if err := Decode(ctx, conn, msg); err != nil || string(msg.Data) != PAYLOAD || msg.Event != nil {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect message - got (%s %v)", "Decode", msg.Data, err))
+		}
+	})
+
+	t.Run("Decode : should pass (binary cloudevent routed by type)", func(t *testing.T) {
+		os.Setenv("CE_TYPE_TOPIC", "true")
+		defer os.Setenv("CE_TYPE_TOPIC", "false")
+		os.Setenv("TOPIC_NAMESPACE", "com.example.*")
+		defer os.Unsetenv("TOPIC_NAMESPACE")
+		conn := connectors.NewTestConnectors("", 200, logger)
+		header := http.Header{}
+		header.Set(CONTENTTYPE, APPLICATIONJSON)
+		header.Set("ce-specversion", "1.0")
+		header.Set("ce-id", "ce-1")
+		header.Set("ce-source", "/test")
+		header.Set("ce-type", "com.example.customer")
+		msg := &Message{Body: []byte(PAYLOAD), Header: header, Topic: "test"}
+		if err := Decode(ctx, conn, msg); err != nil || msg.Event == nil || msg.Topic != "com.example.customer" {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect message - got (%s %v)", "Decode", msg.Topic, err))
+		}
+	})
+
+	t.Run("Decode : should fail (cloudevent type outside the namespace)", func(t *testing.T) {
+		os.Setenv("CE_TYPE_TOPIC", "true")
+		defer os.Setenv("CE_TYPE_TOPIC", "false")
+		conn := connectors.NewTestConnectors("", 200, logger)
+		header := http.Header{}
+		header.Set(CONTENTTYPE, APPLICATIONJSON)
+		header.Set("ce-specversion", "1.0")
+		header.Set("ce-id", "ce-1")
+		header.Set("ce-source", "/test")
+		header.Set("ce-type", "dynamicconfig:changes")
+		msg := &Message{Body: []byte(PAYLOAD), Header: header, Topic: "test"}
+		if err := Decode(ctx, conn, msg); err == nil {
+			t.Errorf(fmt.Sprintf("Function %s should fail - got topic (%s)", "Decode", msg.Topic))
+		}
+	})
+
+	t.Run("Validate : should pass (no schema for the topic, request unmarshalled)", func(t *testing.T) {
+		conn := connectors.NewTestConnectors("", 200, logger)
+		msg := &Message{Data: []byte(PAYLOAD), Topic: "test"}
+		if err := Validate(ctx, conn, msg); err != nil || msg.Request == nil || msg.Request.Email != "abc@xyz.com" {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect request - got (%v %v)", "Validate", msg.Request, err))
+		}
+	})
+
+	t.Run("Validate : should fail (unmarshal)", func(t *testing.T) {
+		conn := connectors.NewTestConnectors("", 200, logger)
+		for _, body := range []string{`{ "request": `, `{ "request":{"number":1234567}}`, `null`} {
+			stage, err := New(Stage{STAGEDECODE, Decode}, Stage{STAGEVALIDATE, Validate}).Run(ctx, conn, &Message{Body: []byte(body), Header: http.Header{}, Topic: "test"})
+			if stage != STAGEUNMARSHAL || err == nil {
+				t.Errorf(fmt.Sprintf("Function %s returned incorrect stage for %s - got (%s %v)", "Validate", body, stage, err))
+			}
+		}
+	})
+
+	t.Run("Enrich : should pass (lookup merged into the request)", func(t *testing.T) {
+		if err := enrich.Load("../../tests/enrichment.json"); err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned error %v", "Load", err))
+		}
+		defer enrich.Load("")
+		conn := connectors.NewTestConnectors("../../tests/enrichment-response.json", 200, logger)
+		msg := &Message{Data: []byte(PAYLOAD), Topic: "enriched"}
+		if err := Enrich(ctx, conn, msg); err != nil || msg.Request.FirstName != "first" || msg.Request.Enriched["tier"] != "gold" {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect request - got (%s %v)", "Enrich", msg.Data, err))
+		}
+	})
+
+	t.Run("Transform and Encode : should pass (default template, json)", func(t *testing.T) {
+		conn := connectors.NewTestConnectors("", 200, logger)
+		msg := &Message{Topic: "test", Request: &schema.CustomerPayload{Email: "abc@xyz.com", Number: "1234567"}}
+		if err := Transform(ctx, conn, msg); err != nil || !json.Valid(msg.Data) {
+			t.Fatalf(fmt.Sprintf("Function %s returned incorrect data - got (%s %v)", "Transform", msg.Data, err))
+		}
+		if err := Encode(ctx, conn, msg); err != nil || msg.ContentType != APPLICATIONJSON {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect content type - got (%s %v)", "Encode", msg.ContentType, err))
+		}
+	})
+
+	t.Run("Route : should pass (envelope)", func(t *testing.T) {
+		os.Setenv("ENVELOPE", "true")
+		defer os.Setenv("ENVELOPE", "false")
+		conn := connectors.NewTestConnectors("", 200, logger)
+		header := http.Header{}
+		header.Set(CORRELATIONID, "abc-123")
+		msg := &Message{Topic: "test", Header: header, Data: []byte(`{"email":"abc@xyz.com"}`), ContentType: APPLICATIONJSON}
+		var env schema.Envelope
+		if err := Route(ctx, conn, msg); err != nil || json.Unmarshal(msg.Data, &env) != nil || env.CorrelationID != "abc-123" || msg.Meta == nil || msg.Meta.ID != env.ID {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect envelope - got (%s %v)", "Route", msg.Data, err))
+		}
+		if !strings.Contains(string(msg.Data), fmt.Sprintf(`"_id":%d`, env.ID)) || env.ID >= 1<<53 {
+			t.Errorf(fmt.Sprintf("Function %s should encode the id as an exact json number - got (%s)", "Route", msg.Data))
+		}
+	})
+
+	t.Run("Publish : should fail (forced publish error)", func(t *testing.T) {
+		conn := connectors.NewTestConnectors("", 200, logger)
+		mock := conn.(*connectors.MockConnectors)
+		mock.Meta("true")
+		defer mock.Meta("false")
+		if err := Publish(ctx, conn, &Message{Topic: "test", Data: []byte(PAYLOAD)}); err == nil {
+			t.Errorf(fmt.Sprintf("Function %s should fail", "Publish"))
+		}
+	})
+
+	t.Run("Publish : should pass (routed topic)", func(t *testing.T) {
+		conn := connectors.NewTestConnectors("", 200, logger)
+		table := routes.New()
+		table.Set("test", []string{"test.a", "test.b"})
+		routes.Install(table)
+		defer routes.Install(routes.New())
+		if err := Publish(ctx, conn, &Message{Topic: "test", Data: []byte(PAYLOAD)}); err != nil || strings.Join(conn.(*connectors.MockConnectors).Channels, ",") != "test.a,test.b" {
+			t.Errorf(fmt.Sprintf("Function %s published to incorrect channels - got (%v %v)", "Publish", conn.(*connectors.MockConnectors).Channels, err))
+		}
+	})
+
+	t.Run("Process : should pass (published)", func(t *testing.T) {
+		conn := connectors.NewTestConnectors("", 200, logger)
+		_, stage, err := Process(ctx, conn, []byte(PAYLOAD), http.Header{})
+		if err != nil || stage != "" || len(conn.(*connectors.MockConnectors).Published) != 1 {
+			t.Errorf(fmt.Sprintf("Function %s returned error %v (stage %s)", "Process", err, stage))
+		}
+	})
+
+	t.Run("nextMessageId : should be unique, increasing and below 2^53", func(t *testing.T) {
+		var last int64
+		for i := 0; i < 10000; i++ {
+			id := nextMessageId()
+			if id <= last || id >= 1<<53 {
+				t.Fatalf("nextMessageId returned id %d after %d", id, last)
+			}
+			last = id
+		}
+	})
+}
@@ -0,0 +1,161 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/connectors"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/encoders"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/enrich"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/fieldcrypt"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/metrics"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/ratelimit"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/routes"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/schema"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/templates"
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/validator"
+)
+
+// Decode - unwraps cloudevents (structured or binary mode), resolves the topic, applies the per topic
+// rate limit (throttled payloads are rejected before any other work)
+func Decode(ctx context.Context, con connectors.Clients, msg *Message) error {
+	msg.Data = msg.Body
+	if isCloudEvent(msg.Header) {
+		event, err := parseCloudEvent(msg.Body, msg.Header)
+		if err != nil {
+			return fmt.Errorf("invalid cloudevent %v", err)
+		}
+		msg.Data, err = cloudEventData(event)
+		if err != nil {
+			return fmt.Errorf("invalid cloudevent data %v", err)
+		}
+		msg.Event = event
+		if msg.Topic, err = cloudEventTopic(event, msg.Topic); err != nil {
+			return fmt.Errorf("invalid cloudevent %v", err)
+		}
+	}
+
+	// a failing redis counter lets the payload through
+	err := ratelimit.Allow(ctx, con, ratelimit.SCOPETOPIC, msg.Topic)
+	var lerr *ratelimit.LimitError
+	if errors.As(err, &lerr) {
+		return Fail(STAGELIMIT, err)
+	} else if err != nil {
+		con.Error("Decode topic rate limit %v", err)
+	}
+
+	metrics.PayloadSize.WithLabelValues(metrics.Topic(msg.Topic), "in").Observe(float64(len(msg.Data)))
+	return nil
+}
+
+// Validate - checks the payload against the json schema registered for the topic and only then
+// unmarshals the request, so malformed or wrongly typed payloads are reported with the schema violations
+func Validate(ctx context.Context, con connectors.Clients, msg *Message) error {
+	if err := validator.ValidateInput(msg.Topic, msg.Data); err != nil {
+		metrics.ValidationFailures.WithLabelValues(metrics.Topic(msg.Topic), "input").Inc()
+		return err
+	}
+	var cp *schema.GenericSchema
+	if err := json.Unmarshal(msg.Data, &cp); err != nil {
+		return Fail(STAGEUNMARSHAL, fmt.Errorf("could not unmarshal input data to schema %v", err))
+	}
+	if cp == nil {
+		return Fail(STAGEUNMARSHAL, errors.New("could not unmarshal input data to schema null payload"))
+	}
+	msg.Request = cp.Request
+	return nil
+}
+
+// Enrich - runs the lookups configured for the topic (ENRICHMENT_CONFIG) and merges their results into the request
+func Enrich(ctx context.Context, con connectors.Clients, msg *Message) error {
+	if !enrich.Enabled(msg.Topic) {
+		return nil
+	}
+	data, err := enrich.Topic(ctx, con, msg.Topic, msg.Data)
+	if err != nil {
+		return err
+	}
+	var cp *schema.GenericSchema
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return fmt.Errorf("could not unmarshal enriched data to schema %v", err)
+	}
+	msg.Data, msg.Request = data, cp.Request
+	return nil
+}
+
+// Transform - renders the topic template, validates the rendered message against the output schema
+// (subscribers are guarded against templates that render nonconforming messages) and encrypts the sensitive fields
+func Transform(ctx context.Context, con connectors.Clients, msg *Message) error {
+	con.Trace("Transform request %v", msg.Request)
+	var tpl bytes.Buffer
+	start := time.Now()
+	err := templates.ForTopic(msg.Topic).Execute(&tpl, msg.Request)
+	metrics.TemplateDuration.WithLabelValues(metrics.Topic(msg.Topic)).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return fmt.Errorf("parse template %v", err)
+	}
+
+	if err := validator.ValidateOutput(msg.Topic, tpl.Bytes()); err != nil {
+		metrics.ValidationFailures.WithLabelValues(metrics.Topic(msg.Topic), "output").Inc()
+		metrics.OutputViolations.WithLabelValues(metrics.Topic(msg.Topic)).Inc()
+		return Fail(STAGEOUTPUT, err)
+	}
+
+	// subscribers without the keyring must not see the sensitive fields
+	rendered, err := fieldcrypt.EncryptTopic(msg.Topic, tpl.Bytes())
+	if err != nil {
+		return Fail(STAGEENCRYPT, fmt.Errorf("encrypt %v", err))
+	}
+	msg.Data = rendered
+	return nil
+}
+
+// Encode - the topic encoding (json, avro, protobuf ...)
+func Encode(ctx context.Context, con connectors.Clients, msg *Message) error {
+	enc := encoders.ForTopic(msg.Topic)
+	data, err := enc.Encode(msg.Data)
+	if err != nil {
+		return fmt.Errorf("encode %s %v", enc.ContentType(), err)
+	}
+	msg.Data = data
+	msg.ContentType = enc.ContentType()
+	return nil
+}
+
+// Route - addresses the encoded message to the topic, wrapped as a cloudevent (cloudevent input)
+// or in the envelope (ENVELOPE), the trace context of ctx is handed to subscribers
+func Route(ctx context.Context, con connectors.Clients, msg *Message) error {
+	switch {
+	case msg.Event != nil:
+		b, err := wrapCloudEvent(ctx, msg.Event, msg.Topic, msg.ContentType, msg.Data)
+		if err != nil {
+			return fmt.Errorf("cloudevent %v", err)
+		}
+		msg.Data = b
+		msg.Meta = &schema.SchemaInterface{LastUpdate: time.Now().UnixMilli(), MetaInfo: msg.Event.ID}
+	case envelopeEnabled():
+		env, b, err := wrapEnvelope(ctx, msg.Topic, msg.Header.Get(CORRELATIONID), msg.ContentType, msg.Data)
+		if err != nil {
+			return fmt.Errorf("envelope %v", err)
+		}
+		msg.Data = b
+		msg.Meta = &env.SchemaInterface
+	}
+	return nil
+}
+
+// Publish - publishes the message to the channels the topic is routed to (the topic itself unless routed)
+func Publish(ctx context.Context, con connectors.Clients, msg *Message) error {
+	con.Trace("Publish payload %s", msg.Data)
+	metrics.PayloadSize.WithLabelValues(metrics.Topic(msg.Topic), "out").Observe(float64(len(msg.Data)))
+	for _, channel := range routes.Channels(msg.Topic) {
+		if err := con.Publish(ctx, channel, msg.Data); err != nil {
+			return fmt.Errorf("publish request %s %v", channel, err)
+		}
+	}
+	return nil
+}
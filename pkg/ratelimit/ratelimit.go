@@ -0,0 +1,265 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	SCOPECLIENT string = "client"
+	SCOPETOPIC  string = "topic"
+	// idle local buckets are dropped after this long
+	IDLETIMEOUT time.Duration = 10 * time.Minute
+)
+
+// Limit - token bucket, Rate tokens per second refilled up to Burst
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// Config - the file referenced by the RATELIMIT_CONFIG envar
+// Client and Topic are the defaults, Clients and Topics the overrides keyed by client key or topic name
+// client keys are the identity verified by authentication (see Verified, i.e. "apikey:<client>" or "sub:<jwt subject>")
+// or "ip:<address>"
+// Redis enforces the limits across replicas with a fixed window of Burst/Rate seconds allowing Burst requests
+type Config struct {
+	Client     *Limit            `json:"client,omitempty"`
+	Clients    map[string]*Limit `json:"clients,omitempty"`
+	Topic      *Limit            `json:"topic,omitempty"`
+	Topics     map[string]*Limit `json:"topics,omitempty"`
+	Redis      bool              `json:"redis,omitempty"`
+	TrustProxy bool              `json:"trustproxy,omitempty"`
+}
+
+// Counter - distributed fixed window counter (implemented by connectors.Clients)
+type Counter interface {
+	IncrWindow(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error)
+}
+
+// LimitError - the request was throttled, RetryAfter is the time until the next token is available
+type LimitError struct {
+	Scope      string
+	Key        string
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s rate limit exceeded for %s (retry after %s)", e.Scope, e.Key, e.RetryAfter)
+}
+
+type bucket struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+type limiters struct {
+	cfg     *Config
+	buckets map[string]*bucket
+	pruned  time.Time
+}
+
+var (
+	mu     sync.Mutex
+	active = &limiters{cfg: &Config{}, buckets: map[string]*bucket{}}
+)
+
+// Load - reads the rate limit config file and installs it (see Install)
+// an empty file name disables rate limiting
+func Load(file string) error {
+	cfg, err := Read(file)
+	if err != nil {
+		return err
+	}
+	Install(cfg)
+	return nil
+}
+
+// Read - reads and validates the rate limit config file without installing it
+func Read(file string) (*Config, error) {
+	cfg := &Config{}
+	if file == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("rate limit config %s %v", file, err)
+	}
+	for _, l := range append([]*Limit{cfg.Client, cfg.Topic}, limitList(cfg)...) {
+		if l != nil && !l.valid() {
+			return nil, fmt.Errorf("rate limit config %s rate and burst must be positive", file)
+		}
+	}
+	return cfg, nil
+}
+
+// ParseLimit - decodes and validates a single json limit ({"rate":1,"burst":2})
+func ParseLimit(data []byte) (*Limit, error) {
+	l := &Limit{}
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, err
+	}
+	if !l.valid() {
+		return nil, fmt.Errorf("rate and burst must be positive")
+	}
+	return l, nil
+}
+
+// SetTopic - overrides the limit of topic (replacing the one from the config file)
+func (c *Config) SetTopic(topic string, l *Limit) {
+	if c.Topics == nil {
+		c.Topics = map[string]*Limit{}
+	}
+	c.Topics[topic] = l
+}
+
+// Install - replaces the active limits, the buckets of keys whose limit did not change are kept (a reload
+// does not refill a throttled client), the others start full
+func Install(cfg *Config) {
+	mu.Lock()
+	defer mu.Unlock()
+	next := &limiters{cfg: cfg, buckets: map[string]*bucket{}, pruned: active.pruned}
+	for k, b := range active.buckets {
+		scope, key, _ := strings.Cut(k, ":")
+		before, after := active.limit(scope, key), next.limit(scope, key)
+		if before != nil && after != nil && *before == *after {
+			next.buckets[k] = b
+		}
+	}
+	active = next
+}
+
+func (l *Limit) valid() bool {
+	return l.Rate > 0 && l.Burst >= 1
+}
+
+// verifiedKey - the request context key of the identity set by Verified
+type verifiedKey struct{}
+
+// Verified - records the caller identity once an authentication layer has verified it (the api key or the
+// subject of a verified jwt), ClientKey only trusts identities set here
+func Verified(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, verifiedKey{}, key)
+}
+
+// ClientKey - identifies the caller by its verified identity (see Verified) or ip address
+// unverified api keys and jwt subjects are ignored, clients could rotate them or claim another client's limit
+func ClientKey(r *http.Request) string {
+	if key, ok := r.Context().Value(verifiedKey{}).(string); ok && key != "" {
+		return key
+	}
+	mu.Lock()
+	trust := active.cfg.TrustProxy
+	mu.Unlock()
+	if fwd := r.Header.Get("X-Forwarded-For"); trust && fwd != "" {
+		return "ip:" + strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// Allow - takes a token for key in the scope (client or topic)
+// returns a *LimitError when throttled, any other error comes from the distributed counter
+func Allow(ctx context.Context, counter Counter, scope string, key string) error {
+	mu.Lock()
+	l := active.limit(scope, key)
+	distributed := active.cfg.Redis
+	mu.Unlock()
+	if l == nil {
+		return nil
+	}
+
+	if distributed {
+		window := time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+		id := sha256.Sum256([]byte(scope + ":" + key))
+		count, ttl, err := counter.IncrWindow(ctx, scope+":"+hex.EncodeToString(id[:16]), window)
+		if err != nil {
+			return err
+		}
+		if count > int64(l.Burst) {
+			if ttl <= 0 {
+				ttl = window
+			}
+			return &LimitError{Scope: scope, Key: key, RetryAfter: ttl}
+		}
+		return nil
+	}
+
+	now := time.Now()
+	mu.Lock()
+	defer mu.Unlock()
+	b := active.bucket(scope+":"+key, l, now)
+	r := b.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return &LimitError{Scope: scope, Key: key, RetryAfter: delay}
+	}
+	return nil
+}
+
+// RetryAfterSeconds - the Retry-After header value (whole seconds, at least 1)
+func (e *LimitError) RetryAfterSeconds() int {
+	return int(math.Max(1, math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// limit - the override for key or the scope default (nil when unlimited)
+func (l *limiters) limit(scope string, key string) *Limit {
+	if scope == SCOPETOPIC {
+		if t, ok := l.cfg.Topics[key]; ok {
+			return t
+		}
+		return l.cfg.Topic
+	}
+	if c, ok := l.cfg.Clients[key]; ok {
+		return c
+	}
+	return l.cfg.Client
+}
+
+// bucket - the local token bucket for key, idle buckets are pruned once a minute
+func (l *limiters) bucket(key string, limit *Limit, now time.Time) *bucket {
+	if now.Sub(l.pruned) > time.Minute {
+		for k, b := range l.buckets {
+			if now.Sub(b.seen) > IDLETIMEOUT {
+				delete(l.buckets, k)
+			}
+		}
+		l.pruned = now
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)}
+		l.buckets[key] = b
+	}
+	b.seen = now
+	return b
+}
+
+func limitList(cfg *Config) []*Limit {
+	var list []*Limit
+	for _, l := range cfg.Clients {
+		list = append(list, l)
+	}
+	for _, l := range cfg.Topics {
+		list = append(list, l)
+	}
+	return list
+}
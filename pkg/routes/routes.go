@@ -0,0 +1,69 @@
+// Package routes - the channels each topic is published to, a topic without a route is published to itself
+//
+// Routes are set per topic through the dynamic config (DYNAMIC_CONFIG=redis), every channel has to be
+// in the topic namespace so a route can not publish to other (internal) channels.
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/topics"
+)
+
+// Table - the channels per routed topic
+type Table struct {
+	topics map[string][]string
+}
+
+var (
+	mu     sync.RWMutex
+	active = New()
+)
+
+// New - a table without routes
+func New() *Table {
+	return &Table{topics: map[string][]string{}}
+}
+
+// Parse - a json list of channels (["orders","orders.audit"]), every channel must be in the topic namespace
+func Parse(raw []byte) ([]string, error) {
+	var channels []string
+	if err := json.Unmarshal(raw, &channels); err != nil {
+		return nil, fmt.Errorf("route must be a json list of channels %v", err)
+	}
+	if len(channels) == 0 {
+		return nil, errors.New("route has no channels")
+	}
+	namespace := topics.Namespace()
+	for _, c := range channels {
+		if !topics.Match(namespace, c) {
+			return nil, fmt.Errorf("channel %q is not in the topic namespace %s", c, namespace)
+		}
+	}
+	return channels, nil
+}
+
+// Set - routes topic to channels (replacing any previous route)
+func (t *Table) Set(topic string, channels []string) {
+	t.topics[topic] = channels
+}
+
+// Install - replaces the active routes
+func Install(t *Table) {
+	mu.Lock()
+	active = t
+	mu.Unlock()
+}
+
+// Channels - the channels the messages of topic are published to
+func Channels(topic string) []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if channels, ok := active.topics[topic]; ok {
+		return channels
+	}
+	return []string{topic}
+}
@@ -0,0 +1,44 @@
+package routes
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+)
+
+func TestRoutes(t *testing.T) {
+
+	os.Setenv("TOPIC", "test")
+	defer config.Set(nil)
+
+	t.Run("Parse : should pass", func(t *testing.T) {
+		channels, err := Parse([]byte(`["test","test.audit"]`))
+		if err != nil || strings.Join(channels, ",") != "test,test.audit" {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect channels - got (%v %v)", "Parse", channels, err))
+		}
+	})
+
+	t.Run("Parse : should fail (empty, invalid or outside the namespace)", func(t *testing.T) {
+		for _, raw := range []string{`[]`, `"test"`, `["test","dynamicconfig:changes"]`} {
+			if _, err := Parse([]byte(raw)); err == nil {
+				t.Errorf(fmt.Sprintf("Function %s should fail for %s", "Parse", raw))
+			}
+		}
+	})
+
+	t.Run("Channels : should pass (routed and unrouted topics)", func(t *testing.T) {
+		table := New()
+		table.Set("test", []string{"test.a", "test.b"})
+		Install(table)
+		defer Install(New())
+		if got := strings.Join(Channels("test"), ","); got != "test.a,test.b" {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect channels - got (%s)", "Channels", got))
+		}
+		if got := strings.Join(Channels("other"), ","); got != "other" {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect channels - got (%s)", "Channels", got))
+		}
+	})
+}
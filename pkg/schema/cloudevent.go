@@ -0,0 +1,59 @@
+package schema
+
+import "encoding/json"
+
+// CloudEvent schema - CloudEvents 1.0 (structured json format)
+type CloudEvent struct {
+	SpecVersion     string                 `json:"specversion"`
+	ID              string                 `json:"id"`
+	Source          string                 `json:"source"`
+	Type            string                 `json:"type"`
+	DataContentType string                 `json:"datacontenttype,omitempty"`
+	DataSchema      string                 `json:"dataschema,omitempty"`
+	Subject         string                 `json:"subject,omitempty"`
+	Time            string                 `json:"time,omitempty"`
+	Data            json.RawMessage        `json:"data,omitempty"`
+	DataBase64      string                 `json:"data_base64,omitempty"`
+	Extensions      map[string]interface{} `json:"-"`
+}
+
+// cloudEvent - alias used to avoid recursion in the json methods
+type cloudEvent CloudEvent
+
+// MarshalJSON - extension attributes are serialized as top level attributes
+func (ce *CloudEvent) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal((*cloudEvent)(ce))
+	if err != nil || len(ce.Extensions) == 0 {
+		return b, err
+	}
+	m := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	for k, v := range ce.Extensions {
+		if _, ok := m[k]; !ok {
+			if m[k], err = json.Marshal(v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON - any attribute that is not part of the spec is kept as an extension
+func (ce *CloudEvent) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, (*cloudEvent)(ce)); err != nil {
+		return err
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	for _, k := range []string{"specversion", "id", "source", "type", "datacontenttype", "dataschema", "subject", "time", "data", "data_base64"} {
+		delete(m, k)
+	}
+	if len(m) > 0 {
+		ce.Extensions = m
+	}
+	return nil
+}
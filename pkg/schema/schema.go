@@ -1,11 +1,14 @@
 package schema
 
+import "encoding/json"
+
 // Response schema
 type Response struct {
 	Name       string           `json:"name"`
 	StatusCode string           `json:"statuscode"`
 	Status     string           `json:"status"`
 	Message    string           `json:"message"`
+	Errors     []string         `json:"errors,omitempty"`
 	Payload    *SchemaInterface `json:"payload,omitempty"`
 }
 
@@ -39,6 +42,8 @@ type CustomerPayload struct {
 	Address   string `json:"address,omitempty"`
 	Mobile    string `json:"mobile,omitempty"`
 	LastName  string `json:"lastName,omitempty"`
+	// lookup results without a field of their own (see the enrich package)
+	Enriched map[string]interface{} `json:"enriched,omitempty"`
 }
 
 // All the go microservices will using this schema
@@ -47,3 +52,92 @@ type SchemaInterface struct {
 	LastUpdate int64  `json:"lastupdate,omitempty"`
 	MetaInfo   string `json:"metainfo,omitempty"`
 }
+
+// DeadLetter schema - a payload that failed to transform or publish
+type DeadLetter struct {
+	ID        string              `json:"id"`
+	Stage     string              `json:"stage"`
+	Error     string              `json:"error"`
+	Body      string              `json:"body"`
+	Headers   map[string][]string `json:"headers,omitempty"`
+	Timestamp int64               `json:"timestamp"`
+}
+
+// Envelope schema - optional wrapper around every published message
+type Envelope struct {
+	SchemaInterface
+	Source        string          `json:"source"`
+	Version       string          `json:"version"`
+	Topic         string          `json:"topic"`
+	ContentType   string          `json:"contenttype"`
+	CorrelationID string          `json:"correlationid,omitempty"`
+	RequestID     string          `json:"requestid,omitempty"`
+	TraceParent   string          `json:"traceparent,omitempty"`
+	TraceState    string          `json:"tracestate,omitempty"`
+	SchemaVersion string          `json:"schemaversion"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// SchemaVersion schema - a versioned json schema held in the schema registry
+type SchemaVersion struct {
+	Topic         string          `json:"topic"`
+	Version       int             `json:"version"`
+	Compatibility string          `json:"compatibility"`
+	Schema        json.RawMessage `json:"schema"`
+	Created       int64           `json:"created"`
+}
+
+// DynamicConfig schema - the per topic settings held in redis (DYNAMIC_CONFIG=redis)
+// Templates are text/template sources, RateLimits json limits ({"rate":1,"burst":2}), Routes json channel
+// lists (["orders","orders.audit"]) and Policies the json encoding and encrypted fields
+// ({"encoding":{"encoding":"msgpack"},"encrypt":["email"]})
+type DynamicConfig struct {
+	Version    int64             `json:"version"`
+	Templates  map[string]string `json:"templates"`
+	RateLimits map[string]string `json:"ratelimits"`
+	Routes     map[string]string `json:"routes"`
+	Policies   map[string]string `json:"policies"`
+}
+
+// TopicStats schema - a channel in the publisher's namespace, subscribers from redis and the local publish counters
+// rates are per second over the last minute, LastPublish is unix milliseconds (0 when never published)
+type TopicStats struct {
+	Topic       string  `json:"topic"`
+	Subscribers int64   `json:"subscribers"`
+	Published   int64   `json:"published"`
+	Errors      int64   `json:"errors"`
+	LastPublish int64   `json:"lastpublish"`
+	MessageRate float64 `json:"messagerate"`
+	ErrorRate   float64 `json:"errorrate"`
+}
+
+// Topics schema - the topics of the namespace and the number of pattern subscriptions (PUBSUB NUMPAT)
+type Topics struct {
+	Namespace          string        `json:"namespace"`
+	PatternSubscribers int64         `json:"patternsubscribers"`
+	Topics             []*TopicStats `json:"topics"`
+}
+
+// PubSubMessage schema - a message received on a subscribed channel, Pattern is set for PSUBSCRIBE
+type PubSubMessage struct {
+	Channel string `json:"channel"`
+	Pattern string `json:"pattern,omitempty"`
+	Payload string `json:"payload"`
+}
+
+// WebSocketFrame schema - a websocket message in either direction
+// clients send publish (Payload as for /api/v1/publish, optional Headers), subscribe and unsubscribe (Topic, Pattern)
+// the server answers with ack or error (same ID) and delivers message frames (Topic as subscribed, Channel, Payload)
+type WebSocketFrame struct {
+	Type    string            `json:"type"`
+	ID      string            `json:"id,omitempty"`
+	Topic   string            `json:"topic,omitempty"`
+	Pattern bool              `json:"pattern,omitempty"`
+	Channel string            `json:"channel,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Payload json.RawMessage   `json:"payload,omitempty"`
+	Meta    *SchemaInterface  `json:"meta,omitempty"`
+	Code    int               `json:"code,omitempty"`
+	Error   string            `json:"error,omitempty"`
+	Errors  []string          `json:"errors,omitempty"`
+}
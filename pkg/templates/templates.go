@@ -0,0 +1,98 @@
+package templates
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"text/template"
+)
+
+// DEFAULT - the publish template used for topics without one
+const DEFAULT string = `{ "number":"{{ .Number }}", "email":"{{ .Email }}" }`
+
+// Config - the file referenced by the TEMPLATE_CONFIG envar (inline text/template sources)
+type Config struct {
+	Default string            `json:"default,omitempty"`
+	Topics  map[string]string `json:"topics"`
+}
+
+// Set - the parsed publish templates
+type Set struct {
+	def    *template.Template
+	topics map[string]*template.Template
+}
+
+var (
+	mu     sync.RWMutex
+	active = mustDefault()
+)
+
+// Load - reads the template config file and replaces the active templates
+// an empty file name resets every topic to DEFAULT
+func Load(file string) error {
+	set, err := Read(file)
+	if err != nil {
+		return err
+	}
+	Install(set)
+	return nil
+}
+
+// Read - parses every template of the config file without installing them
+func Read(file string) (*Set, error) {
+	set := mustDefault()
+	if file == "" {
+		return set, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("template config %s %v", file, err)
+	}
+	if cfg.Default != "" {
+		if set.def, err = template.New("default").Parse(cfg.Default); err != nil {
+			return nil, fmt.Errorf("default template %v", err)
+		}
+	}
+	for topic, src := range cfg.Topics {
+		if set.topics[topic], err = template.New(topic).Parse(src); err != nil {
+			return nil, fmt.Errorf("topic %s template %v", topic, err)
+		}
+	}
+	return set, nil
+}
+
+// Parse - parses src as the template of topic (replacing the one from the config file)
+func (s *Set) Parse(topic string, src string) error {
+	t, err := template.New(topic).Parse(src)
+	if err != nil {
+		return fmt.Errorf("topic %s template %v", topic, err)
+	}
+	s.topics[topic] = t
+	return nil
+}
+
+// Install - replaces the active templates
+func Install(set *Set) {
+	mu.Lock()
+	active = set
+	mu.Unlock()
+}
+
+// ForTopic - the template for the topic (or the default)
+func ForTopic(topic string) *template.Template {
+	mu.RLock()
+	defer mu.RUnlock()
+	if t, ok := active.topics[topic]; ok {
+		return t
+	}
+	return active.def
+}
+
+func mustDefault() *Set {
+	return &Set{def: template.Must(template.New("publish").Parse(DEFAULT)), topics: map[string]*template.Template{}}
+}
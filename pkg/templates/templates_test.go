@@ -0,0 +1,44 @@
+package templates
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestTemplates(t *testing.T) {
+
+	data := map[string]string{"Email": "abc@xyz.com", "Number": "1234567"}
+
+	t.Run("ForTopic : should pass (default template)", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := ForTopic("test").Execute(&buf, data); err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned error %v", "ForTopic", err))
+		}
+		if buf.String() != `{ "number":"1234567", "email":"abc@xyz.com" }` {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect output - got (%s)", "ForTopic", buf.String()))
+		}
+	})
+
+	t.Run("Load : should pass (per topic template)", func(t *testing.T) {
+		if err := Load("../../tests/templates.json"); err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned error %v", "Load", err))
+		}
+		defer Load("")
+		var buf bytes.Buffer
+		ForTopic("audit").Execute(&buf, data)
+		if buf.String() != `{ "audit":"abc@xyz.com" }` {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect output - got (%s)", "ForTopic", buf.String()))
+		}
+	})
+
+	t.Run("Read : should fail (template does not parse, active set kept)", func(t *testing.T) {
+		before := ForTopic("audit")
+		if _, err := Read("../../tests/badtemplates.json"); err == nil {
+			t.Errorf(fmt.Sprintf("Function %s should fail for an unparsable template", "Read"))
+		}
+		if ForTopic("audit") != before {
+			t.Errorf(fmt.Sprintf("Function %s should not change the active templates", "Read"))
+		}
+	})
+}
@@ -0,0 +1,209 @@
+package topics
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+)
+
+const (
+	// message and error rates are averaged over this many seconds
+	WINDOW int = 60
+	// counters kept at most, the least recently used topic is dropped for a new one
+	MAXTOPICS int = 1024
+)
+
+// Counter - the local publish activity of one topic
+// the rates use one bucket per second of the last WINDOW seconds
+type Counter struct {
+	Published   int64
+	Errors      int64
+	LastPublish time.Time
+	published   [WINDOW]int64
+	errors      [WINDOW]int64
+	seconds     [WINDOW]int64
+	used        time.Time
+}
+
+var (
+	mu       sync.Mutex
+	counters = map[string]*Counter{}
+	now      = time.Now
+)
+
+// Record - counts a publish attempt, err is the outcome (only successful attempts set LastPublish)
+func Record(topic string, err error) {
+	t := now()
+	mu.Lock()
+	defer mu.Unlock()
+	c, ok := counters[topic]
+	if !ok {
+		if len(counters) >= MAXTOPICS {
+			evict()
+		}
+		c = &Counter{}
+		counters[topic] = c
+	}
+	c.used = t
+	sec := t.Unix()
+	i := int(sec % int64(WINDOW))
+	if c.seconds[i] != sec {
+		c.seconds[i], c.published[i], c.errors[i] = sec, 0, 0
+	}
+	if err != nil {
+		c.Errors++
+		c.errors[i]++
+		return
+	}
+	c.Published++
+	c.published[i]++
+	c.LastPublish = t
+}
+
+// evict - drops the least recently used counter (mu held)
+func evict() {
+	var oldest string
+	var used time.Time
+	for topic, c := range counters {
+		if oldest == "" || c.used.Before(used) {
+			oldest, used = topic, c.used
+		}
+	}
+	delete(counters, oldest)
+}
+
+// Rates - messages and errors per second over the last WINDOW seconds
+func (c *Counter) Rates(at time.Time) (float64, float64) {
+	var published, errors int64
+	oldest := at.Unix() - int64(WINDOW)
+	for i := 0; i < WINDOW; i++ {
+		if c.seconds[i] > oldest {
+			published += c.published[i]
+			errors += c.errors[i]
+		}
+	}
+	return float64(published) / float64(WINDOW), float64(errors) / float64(WINDOW)
+}
+
+// Get - a copy of the topic counter (false when nothing was published to it)
+func Get(topic string) (Counter, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	c, ok := counters[topic]
+	if !ok {
+		return Counter{}, false
+	}
+	return *c, true
+}
+
+// Names - the topics with local activity matching the (redis glob style) pattern, sorted
+func Names(pattern string) []string {
+	mu.Lock()
+	defer mu.Unlock()
+	names := []string{}
+	for topic := range counters {
+		if Match(pattern, topic) {
+			names = append(names, topic)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Namespace - the TOPIC_NAMESPACE pattern or every channel starting with TOPIC
+func Namespace() string {
+	cfg := config.Get()
+	if cfg.TopicNamespace != "" {
+		return cfg.TopicNamespace
+	}
+	return cfg.Topic + "*"
+}
+
+// Match - reports whether topic matches the pattern with the glob rules of PUBSUB CHANNELS / PSUBSCRIBE
+// (* and ? also match /, [abc] [^abc] [a-z] classes and \ escapes)
+func Match(pattern string, topic string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(topic); i++ {
+				if Match(pattern[1:], topic[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(topic) == 0 {
+				return false
+			}
+			topic = topic[1:]
+		case '[':
+			if len(topic) == 0 {
+				return false
+			}
+			var ok bool
+			if ok, pattern = matchClass(pattern[1:], topic[0]); !ok {
+				return false
+			}
+			topic = topic[1:]
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(topic) == 0 || pattern[0] != topic[0] {
+				return false
+			}
+			topic = topic[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(topic) == 0
+}
+
+// matchClass - matches c against the class after the [ and returns the pattern after the ]
+// (an unterminated class ends with the pattern, as in redis)
+func matchClass(pattern string, c byte) (bool, string) {
+	not := len(pattern) > 0 && pattern[0] == '^'
+	if not {
+		pattern = pattern[1:]
+	}
+	match := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			pattern = pattern[1:]
+			match = match || pattern[0] == c
+		case len(pattern) > 2 && pattern[1] == '-':
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			match = match || (c >= lo && c <= hi)
+			pattern = pattern[2:]
+		default:
+			match = match || pattern[0] == c
+		}
+		pattern = pattern[1:]
+	}
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+	return match != not, pattern
+}
+
+// Reset - drops every counter
+func Reset() {
+	mu.Lock()
+	counters = map[string]*Counter{}
+	mu.Unlock()
+}
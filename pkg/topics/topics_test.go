@@ -0,0 +1,84 @@
+package topics
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTopics(t *testing.T) {
+
+	start := time.Unix(1700000000, 0)
+	now = func() time.Time { return start }
+	defer func() { now = time.Now }()
+	defer Reset()
+
+	t.Run("Record : should pass (counts and last publish)", func(t *testing.T) {
+		Record("orders", nil)
+		Record("orders", nil)
+		Record("orders", errors.New("forced error"))
+		c, ok := Get("orders")
+		if !ok || c.Published != 2 || c.Errors != 1 || !c.LastPublish.Equal(start) {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect counters - got (%d/%d %v)", "Record", c.Published, c.Errors, c.LastPublish))
+		}
+	})
+
+	t.Run("Rates : should pass (per second over the window, old buckets ignored)", func(t *testing.T) {
+		c, _ := Get("orders")
+		messages, errs := c.Rates(start)
+		if messages != 2.0/float64(WINDOW) || errs != 1.0/float64(WINDOW) {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect rates - got (%f/%f)", "Rates", messages, errs))
+		}
+		messages, _ = c.Rates(start.Add(time.Duration(WINDOW) * time.Second))
+		if messages != 0 {
+			t.Errorf(fmt.Sprintf("Function %s should ignore buckets older than the window - got (%f)", "Rates", messages))
+		}
+	})
+
+	t.Run("Names : should pass (namespace pattern)", func(t *testing.T) {
+		Record("other", nil)
+		names := Names("ord*")
+		if len(names) != 1 || names[0] != "orders" {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect topics - got (%v)", "Names", names))
+		}
+	})
+
+	t.Run("Match : should pass (redis glob rules)", func(t *testing.T) {
+		for _, c := range []struct {
+			pattern string
+			topic   string
+			want    bool
+		}{
+			{"orders*", "orders/eu/1", true},
+			{"orders.?", "orders./", true},
+			{"h[ae]llo", "hallo", true},
+			{"h[^e]llo", "hello", false},
+			{"h[a-c]llo", "hbllo", true},
+			{"h[a-c]llo", "hdllo", false},
+			{`h\*llo`, "h*llo", true},
+			{`h\*llo`, "hello", false},
+			{"h[", "h[", false},
+			{"orders", "orders.audit", false},
+			{"*.audit", "orders.audit", true},
+		} {
+			if got := Match(c.pattern, c.topic); got != c.want {
+				t.Errorf(fmt.Sprintf("Function %s returned incorrect match for %s %s - got (%v)", "Match", c.pattern, c.topic, got))
+			}
+		}
+	})
+
+	t.Run("Record : should pass (least recently used topic evicted)", func(t *testing.T) {
+		Reset()
+		for i := 0; i <= MAXTOPICS; i++ {
+			now = func() time.Time { return start.Add(time.Duration(i) * time.Millisecond) }
+			Record(fmt.Sprintf("topic.%d", i), nil)
+		}
+		if _, ok := Get("topic.0"); ok || len(Names("*")) != MAXTOPICS {
+			t.Errorf(fmt.Sprintf("Function %s should keep at most %d topics - got (%d)", "Record", MAXTOPICS, len(Names("*"))))
+		}
+		if _, ok := Get(fmt.Sprintf("topic.%d", MAXTOPICS)); !ok {
+			t.Errorf(fmt.Sprintf("Function %s should keep the newest topic", "Record"))
+		}
+	})
+}
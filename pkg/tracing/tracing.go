@@ -0,0 +1,178 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	TRACERNAME     string = "github.com/lmzuccarelli/golang-redis-publisher"
+	EXPORTERNONE   string = "none"
+	EXPORTEROTLP   string = "otlp"
+	EXPORTERSTDOUT string = "stdout"
+)
+
+// Init - installs the w3c trace context propagator and the exporter selected by OTEL_EXPORTER
+// (none, otlp or stdout). The otlp exporter is configured with the standard OTEL_EXPORTER_OTLP_* envars
+// the returned function flushes and stops the exporter
+func Init(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	cfg := config.Get()
+	switch cfg.OtelExporter {
+	case "", EXPORTERNONE:
+		return func(context.Context) error { return nil }, nil
+	case EXPORTEROTLP:
+		exporter, err = otlptracehttp.New(ctx)
+	case EXPORTERSTDOUT:
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown OTEL_EXPORTER %s", cfg.OtelExporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", cfg.Name),
+		attribute.String("service.version", cfg.Version),
+	))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Start - starts an internal span as a child of the span in the context
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(TRACERNAME).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartServer - continues the trace of an incoming request (traceparent/tracestate headers)
+func StartServer(r *http.Request, route string) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return otel.Tracer(TRACERNAME).Start(ctx, r.Method+" "+route,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("http.method", r.Method), attribute.String("http.route", route)))
+}
+
+// StartRPC - continues the trace of an incoming grpc call (traceparent/tracestate metadata)
+func StartRPC(ctx context.Context, carrier map[string]string, method string) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(carrier))
+	return otel.Tracer(TRACERNAME).Start(ctx, method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("rpc.system", "grpc"), attribute.String("rpc.method", method)))
+}
+
+// StartProducer - span for a message handed to the bus
+func StartProducer(ctx context.Context, system string, topic string) (context.Context, trace.Span) {
+	return otel.Tracer(TRACERNAME).Start(ctx, topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("messaging.system", system), attribute.String("messaging.destination.name", topic)))
+}
+
+// End - records the error (if any) on the span and ends it
+// only the error type is exported, error messages can carry payload values (i.e. schema validation errors)
+func End(span trace.Span, err error) {
+	if err != nil {
+		kind := ErrorType(err)
+		span.AddEvent("exception", trace.WithAttributes(attribute.String("exception.type", kind)))
+		span.SetStatus(codes.Error, kind)
+	}
+	span.End()
+}
+
+// EndServer - records the response status on a server span and ends it
+// only 5xx responses mark the span as failed, 4xx responses are errors of the client
+func EndServer(span trace.Span, status int) {
+	span.SetAttributes(attribute.Int("http.status_code", status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
+
+// StatusWriter - keeps the response status for EndServer (200 when the handler never calls WriteHeader)
+// Flush and Hijack are passed through for the server-sent events and websocket upgrades
+type StatusWriter struct {
+	http.ResponseWriter
+	Status int
+	wrote  bool
+}
+
+func NewStatusWriter(w http.ResponseWriter) *StatusWriter {
+	return &StatusWriter{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (w *StatusWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.Status = code
+		w.wrote = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *StatusWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *StatusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *StatusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil && !w.wrote {
+		// the websocket handshake response is written to the hijacked connection
+		w.Status = http.StatusSwitchingProtocols
+		w.wrote = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap - the wrapped writer for http.ResponseController
+func (w *StatusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// ErrorType - the type of the innermost wrapped error (i.e. *validator.PayloadError)
+func ErrorType(err error) string {
+	for next := errors.Unwrap(err); next != nil; next = errors.Unwrap(err) {
+		err = next
+	}
+	return fmt.Sprintf("%T", err)
+}
+
+// Inject - the trace context of ctx as propagation fields (traceparent, tracestate)
+// subscribers continue the trace by extracting them with the same propagator
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier
+}
@@ -0,0 +1,219 @@
+package validator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+const (
+	COMPATNONE     string = "none"
+	COMPATBACKWARD string = "backward"
+	COMPATFORWARD  string = "forward"
+	COMPATFULL     string = "full"
+)
+
+// CompatibilityError - the new schema breaks the requested compatibility, Details lists every breaking change
+type CompatibilityError struct {
+	Mode    string
+	Details []string
+}
+
+func (e *CompatibilityError) Error() string {
+	return fmt.Sprintf("schema is not %s compatible : %s", e.Mode, strings.Join(e.Details, "; "))
+}
+
+// CheckSchema - verifies the raw schema compiles as json schema draft 2020-12
+func CheckSchema(raw []byte) error {
+	_, err := compileRaw("mem://registry/check.json", raw)
+	return err
+}
+
+// CheckCompatibility - compares the next schema version with the previous one
+// backward : consumers using next can read data produced with previous
+// forward  : consumers using previous can read data produced with next
+// full     : both
+func CheckCompatibility(mode string, previous []byte, next []byte) error {
+	var prev, nxt map[string]interface{}
+	if err := json.Unmarshal(previous, &prev); err != nil {
+		return fmt.Errorf("previous schema %v", err)
+	}
+	if err := json.Unmarshal(next, &nxt); err != nil {
+		return fmt.Errorf("next schema %v", err)
+	}
+	var details []string
+	switch mode {
+	case COMPATNONE:
+		return nil
+	case COMPATBACKWARD, "":
+		mode = COMPATBACKWARD
+		details = canRead(nxt, prev, "")
+	case COMPATFORWARD:
+		details = canRead(prev, nxt, "")
+	case COMPATFULL:
+		details = append(canRead(nxt, prev, ""), canRead(prev, nxt, "")...)
+	default:
+		return errors.New("unknown compatibility " + mode)
+	}
+	if len(details) > 0 {
+		sort.Strings(details)
+		return &CompatibilityError{Mode: mode, Details: details}
+	}
+	return nil
+}
+
+// canRead - private function, lists the reasons data valid for the writer schema could be rejected by the reader schema
+// this is a structural check covering type, required, enum, properties, items and the common string/number bounds
+func canRead(reader map[string]interface{}, writer map[string]interface{}, path string) []string {
+	var issues []string
+	at := path
+	if at == "" {
+		at = "(root)"
+	}
+
+	rt, wt := typeSet(reader), typeSet(writer)
+	if len(rt) > 0 {
+		if len(wt) == 0 {
+			issues = append(issues, at+": type restricted to "+strings.Join(keys(rt), ","))
+		}
+		for t := range wt {
+			if !rt[t] && !(t == "integer" && rt["number"]) {
+				issues = append(issues, at+": type "+t+" no longer accepted")
+			}
+		}
+	}
+
+	wreq := map[string]bool{}
+	for _, r := range stringList(writer["required"]) {
+		wreq[r] = true
+	}
+	for _, r := range stringList(reader["required"]) {
+		if !wreq[r] {
+			issues = append(issues, at+": property "+r+" is now required")
+		}
+	}
+
+	if renum, ok := reader["enum"].([]interface{}); ok {
+		wenum, ok := writer["enum"].([]interface{})
+		if !ok {
+			issues = append(issues, at+": enum added")
+		}
+		for _, v := range wenum {
+			if !containsValue(renum, v) {
+				issues = append(issues, fmt.Sprintf("%s: enum value %v removed", at, v))
+			}
+		}
+	}
+
+	rprops, _ := reader["properties"].(map[string]interface{})
+	wprops, _ := writer["properties"].(map[string]interface{})
+	if reader["additionalProperties"] == false && writer["additionalProperties"] != false {
+		issues = append(issues, at+": additional properties no longer allowed")
+	}
+	for name, w := range wprops {
+		r, ok := rprops[name]
+		if !ok {
+			if reader["additionalProperties"] == false {
+				issues = append(issues, at+": property "+name+" no longer allowed")
+			}
+			continue
+		}
+		rm, rok := r.(map[string]interface{})
+		wm, wok := w.(map[string]interface{})
+		if rok && wok {
+			issues = append(issues, canRead(rm, wm, path+"/"+name)...)
+		}
+	}
+
+	if ri, ok := reader["items"].(map[string]interface{}); ok {
+		if wi, ok := writer["items"].(map[string]interface{}); ok {
+			issues = append(issues, canRead(ri, wi, path+"/items")...)
+		}
+	}
+
+	// lower bounds can't increase, upper bounds can't decrease
+	for _, k := range []string{"minLength", "minimum", "minItems"} {
+		if rv, ok := reader[k].(float64); ok {
+			if wv, ok := writer[k].(float64); !ok || rv > wv {
+				issues = append(issues, fmt.Sprintf("%s: %s raised to %v", at, k, rv))
+			}
+		}
+	}
+	for _, k := range []string{"maxLength", "maximum", "maxItems"} {
+		if rv, ok := reader[k].(float64); ok {
+			if wv, ok := writer[k].(float64); !ok || rv < wv {
+				issues = append(issues, fmt.Sprintf("%s: %s lowered to %v", at, k, rv))
+			}
+		}
+	}
+	if rp, ok := reader["pattern"].(string); ok && rp != writer["pattern"] {
+		issues = append(issues, at+": pattern changed to "+rp)
+	}
+	if rf, ok := reader["format"].(string); ok && rf != writer["format"] {
+		issues = append(issues, at+": format changed to "+rf)
+	}
+	return issues
+}
+
+func typeSet(s map[string]interface{}) map[string]bool {
+	set := map[string]bool{}
+	switch t := s["type"].(type) {
+	case string:
+		set[t] = true
+	case []interface{}:
+		for _, v := range t {
+			if str, ok := v.(string); ok {
+				set[str] = true
+			}
+		}
+	}
+	return set
+}
+
+func stringList(v interface{}) []string {
+	var list []string
+	if arr, ok := v.([]interface{}); ok {
+		for _, i := range arr {
+			if s, ok := i.(string); ok {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
+
+func containsValue(list []interface{}, v interface{}) bool {
+	b, _ := json.Marshal(v)
+	for _, i := range list {
+		ib, _ := json.Marshal(i)
+		if bytes.Equal(b, ib) {
+			return true
+		}
+	}
+	return false
+}
+
+func keys(m map[string]bool) []string {
+	list := make([]string, 0, len(m))
+	for k := range m {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// compileRaw - private function, compiles an in memory schema
+func compileRaw(url string, raw []byte) (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft2020
+	compiler.AssertFormat = true
+	if err := compiler.AddResource(url, bytes.NewReader(raw)); err != nil {
+		return nil, err
+	}
+	return compiler.Compile(url)
+}
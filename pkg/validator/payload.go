@@ -0,0 +1,248 @@
+package validator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// SchemaSpec - json schema (draft 2020-12) files for a single topic
+// Input is checked against the incoming payload, Output against the rendered message
+type SchemaSpec struct {
+	Input  string `json:"input,omitempty"`
+	Output string `json:"output,omitempty"`
+}
+
+// SchemaConfig - the file referenced by the SCHEMA_CONFIG envar
+type SchemaConfig struct {
+	Default *SchemaSpec            `json:"default,omitempty"`
+	Topics  map[string]*SchemaSpec `json:"topics"`
+}
+
+// PayloadError - a payload failed schema validation, Details holds one entry per failing path
+type PayloadError struct {
+	Topic   string
+	Details []string
+}
+
+func (e *PayloadError) Error() string {
+	return fmt.Sprintf("payload for topic %s does not match schema : %s", e.Topic, strings.Join(e.Details, "; "))
+}
+
+// SchemaSet - the compiled schemas of every topic (see ReadSchemas)
+type SchemaSet struct {
+	def    *compiledSchemas
+	topics map[string]*compiledSchemas
+}
+
+type compiledSchemas struct {
+	input   *jsonschema.Schema
+	output  *jsonschema.Schema
+	version int
+}
+
+var (
+	schemaMutex    sync.RWMutex
+	defaultSchemas = &compiledSchemas{}
+	topicSchemas   = map[string]*compiledSchemas{}
+)
+
+// LoadSchemas - reads the schema config file and compiles every referenced schema
+// an empty file name removes all schemas (no validation)
+func LoadSchemas(file string) error {
+	set, err := ReadSchemas(file)
+	if err != nil {
+		return err
+	}
+	InstallSchemas(set)
+	return nil
+}
+
+// ReadSchemas - compiles the schema config file without installing it
+func ReadSchemas(file string) (*SchemaSet, error) {
+	set := &SchemaSet{def: &compiledSchemas{}, topics: map[string]*compiledSchemas{}}
+	if file == "" {
+		return set, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &SchemaConfig{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("schema config %s %v", file, err)
+	}
+	if cfg.Default != nil {
+		if set.def, err = compileSpec(cfg.Default); err != nil {
+			return nil, fmt.Errorf("default schema %v", err)
+		}
+	}
+	for topic, spec := range cfg.Topics {
+		if set.topics[topic], err = compileSpec(spec); err != nil {
+			return nil, fmt.Errorf("topic %s schema %v", topic, err)
+		}
+	}
+	return set, nil
+}
+
+// SchemaFiles - the input and output schemas the schema config file references (sorted)
+func SchemaFiles(file string) ([]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &SchemaConfig{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("schema config %s %v", file, err)
+	}
+	specs := []*SchemaSpec{cfg.Default}
+	for _, spec := range cfg.Topics {
+		specs = append(specs, spec)
+	}
+	var files []string
+	for _, spec := range specs {
+		if spec != nil && spec.Input != "" {
+			files = append(files, spec.Input)
+		}
+		if spec != nil && spec.Output != "" {
+			files = append(files, spec.Output)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// InstallSchemas - replaces the active schemas
+func InstallSchemas(set *SchemaSet) {
+	schemaMutex.Lock()
+	defaultSchemas = set.def
+	topicSchemas = set.topics
+	schemaMutex.Unlock()
+}
+
+// RegisterInput - installs a schema registry version as the input schema of the topic
+func RegisterInput(topic string, version int, raw []byte) error {
+	schemaMutex.Lock()
+	defer schemaMutex.Unlock()
+	return (&SchemaSet{def: defaultSchemas, topics: topicSchemas}).RegisterInput(topic, version, raw)
+}
+
+// RegisterInput - as the package function for a set that is not installed yet
+// a topic without its own schemas starts from the default ones (the default output schema still applies)
+func (s *SchemaSet) RegisterInput(topic string, version int, raw []byte) error {
+	sch, err := compileRaw(fmt.Sprintf("mem://registry/%s/%d.json", url.PathEscape(topic), version), raw)
+	if err != nil {
+		return err
+	}
+	cs := &compiledSchemas{}
+	if current, ok := s.topics[topic]; ok {
+		*cs = *current
+	} else if s.def != nil {
+		*cs = *s.def
+	}
+	cs.input = sch
+	cs.version = version
+	s.topics[topic] = cs
+	return nil
+}
+
+// TopicVersion - the schema registry version active for the topic (0 when none)
+func TopicVersion(topic string) int {
+	schemaMutex.RLock()
+	defer schemaMutex.RUnlock()
+	if cs, ok := topicSchemas[topic]; ok {
+		return cs.version
+	}
+	return 0
+}
+
+// ValidateInput - validates the incoming payload against the input schema registered for the topic
+// returns a *PayloadError when the payload does not conform
+func ValidateInput(topic string, data []byte) error {
+	return validate(topic, data, func(cs *compiledSchemas) *jsonschema.Schema { return cs.input })
+}
+
+// ValidateOutput - validates the rendered message against the output schema registered for the topic
+// returns a *PayloadError when the message does not conform
+func ValidateOutput(topic string, data []byte) error {
+	return validate(topic, data, func(cs *compiledSchemas) *jsonschema.Schema { return cs.output })
+}
+
+// validate - private function, shared by the input and output checks
+func validate(topic string, data []byte, pick func(*compiledSchemas) *jsonschema.Schema) error {
+	schemaMutex.RLock()
+	cs, ok := topicSchemas[topic]
+	if !ok {
+		cs = defaultSchemas
+	}
+	schemaMutex.RUnlock()
+
+	sch := pick(cs)
+	if sch == nil {
+		return nil
+	}
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return &PayloadError{Topic: topic, Details: []string{"(root): invalid json " + err.Error()}}
+	}
+	err := sch.Validate(v)
+	var ve *jsonschema.ValidationError
+	if errors.As(err, &ve) {
+		return &PayloadError{Topic: topic, Details: validationDetails(ve)}
+	}
+	return err
+}
+
+// validationDetails - flattens the validation error tree into "path: message" entries (leaf errors only)
+func validationDetails(ve *jsonschema.ValidationError) []string {
+	var details []string
+	var walk func(*jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			path := e.InstanceLocation
+			if path == "" {
+				path = "(root)"
+			}
+			details = append(details, path+": "+e.Message)
+		}
+		for _, c := range e.Causes {
+			walk(c)
+		}
+	}
+	walk(ve)
+	sort.Strings(details)
+	return details
+}
+
+func compileSpec(spec *SchemaSpec) (*compiledSchemas, error) {
+	cs := &compiledSchemas{}
+	var err error
+	if spec.Input != "" {
+		if cs.input, err = compileSchema(spec.Input); err != nil {
+			return nil, err
+		}
+	}
+	if spec.Output != "" {
+		if cs.output, err = compileSchema(spec.Output); err != nil {
+			return nil, err
+		}
+	}
+	return cs, nil
+}
+
+func compileSchema(file string) (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft2020
+	compiler.AssertFormat = true
+	return compiler.Compile(file)
+}
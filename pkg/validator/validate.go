@@ -1,44 +1,215 @@
 package validator
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
 	"github.com/microlib/simple"
 )
 
-// checkEnvars - private function, iterates through each item and checks the required field
-func checkEnvar(item string, logger *simple.Logger) error {
-	name := strings.Split(item, ",")[0]
-	required, _ := strconv.ParseBool(strings.Split(item, ",")[1])
-	logger.Trace(fmt.Sprintf("Input parameters -> name %s : required %t", name, required))
-	if os.Getenv(name) == "" {
-		if required {
-			logger.Error(fmt.Sprintf("%s envar is mandatory please set it", name))
-			return fmt.Errorf(fmt.Sprintf("%s envar is mandatory please set it", name))
-		}
+const (
+	TYPESTRING   string = "string"
+	TYPEBOOL     string = "bool"
+	TYPEINT      string = "int"
+	TYPEPORT     string = "port"
+	TYPEDURATION string = "duration"
+	TYPEURL      string = "url"
+	TYPEHOSTPORT string = "hostport"
+	TYPEENUM     string = "enum"
+	TYPEFILE     string = "file"
+)
 
-		logger.Error(fmt.Sprintf("%s envar is empty please set it", name))
+// EnvarSpec - declares a single envar, its type and constraints
+// the default is the one applied by the config package (config.Default)
+type EnvarSpec struct {
+	Name        string
+	Type        string
+	Required    bool
+	Enum        []string
+	Min         int64
+	Max         int64
+	Description string
+}
+
+// Envars - every envar the service reads
+// These envars are set via the openshift template
+var Envars = []EnvarSpec{
+	{Name: "NAME", Type: TYPESTRING, Required: true, Description: "service name (responses, envelope source, logs)"},
+	{Name: "VERSION", Type: TYPESTRING, Required: true, Description: "service version"},
+	{Name: "SERVER_PORT", Type: TYPEPORT, Required: true, Description: "http listen port"},
+	{Name: "GRPC_PORT", Type: TYPEPORT, Description: "grpc listen port (disabled when empty)"},
+	{Name: "TOPIC", Type: TYPESTRING, Required: true, Description: "redis topic messages are published to"},
+	{Name: "TOPIC_NAMESPACE", Type: TYPESTRING, Description: "channel pattern listed by the topic admin api (default <TOPIC>*)"},
+	{Name: "ADMIN_TOKEN", Type: TYPESTRING, Description: "bearer token of the admin api (disabled when empty)"},
+	{Name: "SUBSCRIBE_TOKEN", Type: TYPESTRING, Description: "read only token of the subscribe streams (also as ?access_token=)"},
+	{Name: "SSE_HEARTBEAT", Type: TYPEDURATION, Description: "interval of the heartbeat comments on subscribe streams"},
+	{Name: "WS_SEND_BUFFER", Type: TYPEINT, Min: 1, Description: "frames queued per websocket connection"},
+	{Name: "WS_SLOW_CONSUMER", Type: TYPEENUM, Enum: []string{"disconnect", "drop"}, Description: "websocket policy when the send buffer is full"},
+	{Name: "LOG_LEVEL", Type: TYPEENUM, Enum: []string{"error", "warn", "info", "debug", "trace"}, Description: "log level"},
+	{Name: "LOG_FORMAT", Type: TYPEENUM, Enum: []string{"text", "json"}, Description: "log line format"},
+	{Name: "REDIS_ADDR", Type: TYPEHOSTPORT, Description: "redis host:port"},
+	{Name: "REDIS_PASSWORD", Type: TYPESTRING, Description: "redis password"},
+	{Name: "JWT_SECRETKEY", Type: TYPESTRING, Description: "jwt signing key"},
+	{Name: "API_KEYS", Type: TYPESTRING, Description: "comma separated client=key pairs, the X-Api-Key identifies the rate limited client"},
+	{Name: "ENVELOPE", Type: TYPEBOOL, Description: "wrap messages in the provenance envelope"},
+	{Name: "SCHEMA_VERSION", Type: TYPESTRING, Description: "schema version stamped when the topic has no registered schema"},
+	{Name: "CE_TYPE_TOPIC", Type: TYPEBOOL, Description: "publish cloudevents to a topic named after ce-type"},
+	{Name: "DEADLETTER", Type: TYPEENUM, Enum: []string{"redis", "file"}, Description: "dead letter store (none when empty)"},
+	{Name: "DEADLETTER_KEY", Type: TYPESTRING, Description: "redis list for dead letters"},
+	{Name: "DEADLETTER_FILE", Type: TYPESTRING, Description: "jsonl file for dead letters"},
+	{Name: "DEADLETTER_MAX", Type: TYPEINT, Min: 1, Description: "dead letters kept, the oldest are dropped"},
+	{Name: "ENCODING_CONFIG", Type: TYPEFILE, Description: "per topic encodings (json)"},
+	{Name: "SCHEMA_CONFIG", Type: TYPEFILE, Description: "per topic json schemas (json)"},
+	{Name: "ENCRYPTION_CONFIG", Type: TYPEFILE, Description: "per topic field encryption (json)"},
+	{Name: "RATELIMIT_CONFIG", Type: TYPEFILE, Description: "client and topic rate limits (json)"},
+	{Name: "TEMPLATE_CONFIG", Type: TYPEFILE, Description: "per topic publish templates (json)"},
+	{Name: "ENRICHMENT_CONFIG", Type: TYPEFILE, Description: "per topic enrichment lookups (json)"},
+	{Name: "RELOAD_INTERVAL", Type: TYPEDURATION, Description: "config files are checked for changes at this interval (0s disables)"},
+	{Name: "DYNAMIC_CONFIG", Type: TYPEENUM, Enum: []string{"redis"}, Description: "per topic templates and rate limits store (none when empty)"},
+	{Name: "DYNAMIC_CONFIG_KEY", Type: TYPESTRING, Description: "redis key prefix of the dynamic config"},
+	{Name: "SCHEMA_REGISTRY", Type: TYPEENUM, Enum: []string{"redis", "file"}, Description: "schema registry store (none when empty)"},
+	{Name: "SCHEMA_REGISTRY_KEY", Type: TYPESTRING, Description: "redis key prefix of the schema registry"},
+	{Name: "SCHEMA_REGISTRY_DIR", Type: TYPESTRING, Description: "directory of the file schema registry"},
+	{Name: "SCHEMA_COMPATIBILITY", Type: TYPEENUM, Enum: []string{COMPATNONE, COMPATBACKWARD, COMPATFORWARD, COMPATFULL}, Description: "default schema registry compatibility"},
+	{Name: "REDACT", Type: TYPEBOOL, Description: "mask pii in log messages"},
+	{Name: "REDACT_FIELDS", Type: TYPESTRING, Description: "comma separated fields (or dotted paths) to mask"},
+	{Name: "REDACT_DETECTORS", Type: TYPESTRING, Description: "comma separated detectors (email, phone, jwt)"},
+	{Name: "OTEL_EXPORTER", Type: TYPEENUM, Enum: []string{"none", "otlp", "stdout"}, Description: "trace exporter"},
+	{Name: "OTEL_EXPORTER_OTLP_ENDPOINT", Type: TYPEURL, Description: "otlp collector endpoint"},
+	{Name: "MAX_BODY_SIZE", Type: TYPEINT, Min: 1, Description: "request body limit in bytes"},
+	{Name: "MAX_BODY_SIZE_ROUTES", Type: TYPESTRING, Description: "per route body limits (route=bytes,...)"},
+	{Name: "CORS_ALLOWED_ORIGINS", Type: TYPESTRING, Description: "comma separated origins (* or https://*.example.com)"},
+	{Name: "CORS_ALLOWED_METHODS", Type: TYPESTRING, Description: "comma separated methods"},
+	{Name: "CORS_ALLOWED_HEADERS", Type: TYPESTRING, Description: "comma separated request headers"},
+	{Name: "CORS_EXPOSED_HEADERS", Type: TYPESTRING, Description: "comma separated response headers"},
+	{Name: "CORS_ALLOW_CREDENTIALS", Type: TYPEBOOL, Description: "allow credentialed cors requests"},
+	{Name: "CORS_MAX_AGE", Type: TYPEINT, Min: 0, Description: "preflight cache in seconds"},
+}
+
+// check - validates a single value against the spec, empty values are only checked for required envars
+func (s EnvarSpec) check(value string) error {
+	if value == "" {
+		if s.Required {
+			return fmt.Errorf("%s envar is mandatory please set it", s.Name)
+		}
+		return nil
+	}
+	switch s.Type {
+	case TYPEBOOL:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s must be a boolean (got %q)", s.Name, value)
+		}
+	case TYPEINT, TYPEPORT:
+		min, max := s.Min, s.Max
+		if s.Type == TYPEPORT {
+			min, max = 1, 65535
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s must be an integer (got %q)", s.Name, value)
+		}
+		if n < min || (max > 0 && n > max) {
+			if max > 0 {
+				return fmt.Errorf("%s must be between %d and %d (got %d)", s.Name, min, max, n)
+			}
+			return fmt.Errorf("%s must be at least %d (got %d)", s.Name, min, n)
+		}
+	case TYPEDURATION:
+		if _, err := time.ParseDuration(value); err != nil {
+			return fmt.Errorf("%s must be a duration such as 30s (got %q)", s.Name, value)
+		}
+	case TYPEURL:
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%s must be an absolute url (got %q)", s.Name, value)
+		}
+	case TYPEHOSTPORT:
+		if _, port, err := net.SplitHostPort(value); err != nil || port == "" {
+			return fmt.Errorf("%s must be host:port (got %q)", s.Name, value)
+		}
+	case TYPEENUM:
+		for _, e := range s.Enum {
+			if value == e {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s must be one of %s (got %q)", s.Name, strings.Join(s.Enum, ", "), value)
+	case TYPEFILE:
+		if fi, err := os.Stat(value); err != nil || fi.IsDir() {
+			return fmt.Errorf("%s file %s is not readable", s.Name, value)
+		}
 	}
 	return nil
 }
 
-// ValidateEnvars : public call that groups all envar validations
-// These envars are set via the openshift template
+// envarValue - the layered configuration value (as set, before conversion), envars the config package doesn't own
+// are read directly
+func envarValue(name string) string {
+	for _, n := range config.Names() {
+		if n == name {
+			return config.Get().Raw(name)
+		}
+	}
+	return os.Getenv(name)
+}
+
+// ValidateEnvars : public call that validates every envar in the spec
+// all problems (including the values config.Load could not convert) are logged and returned together, unset
+// optional envars are only logged at debug
 func ValidateEnvars(logger *simple.Logger) error {
-	items := []string{
-		"LOG_LEVEL,false",
-		"SERVER_PORT,true",
-		"VERSION,true",
-		"NAME,true",
-		"TOPIC,true",
+	var errs []error
+	for _, s := range Envars {
+		value := envarValue(s.Name)
+		logger.Trace(fmt.Sprintf("Input parameters -> name %s : required %t : type %s", s.Name, s.Required, s.Type))
+		if value == "" && !s.Required {
+			logger.Debug(fmt.Sprintf("%s envar is not set", s.Name))
+			continue
+		}
+		if err := s.check(value); err != nil {
+			logger.Error(err.Error())
+			errs = append(errs, err)
+		}
 	}
-	for x := range items {
-		if err := checkEnvar(items[x], logger); err != nil {
-			return err
+	if err := checkCors(); err != nil {
+		logger.Error(err.Error())
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// checkCors - credentialed requests can not be allowed from any origin ("*", also the default origins)
+func checkCors() error {
+	cfg := config.Get()
+	if !cfg.CorsAllowCredentials {
+		return nil
+	}
+	origins := strings.Split(cfg.CorsAllowedOrigins, ",")
+	for _, o := range origins {
+		if o = strings.TrimSpace(o); o == "*" || (o == "" && len(origins) == 1) {
+			return errors.New("CORS_ALLOW_CREDENTIALS needs CORS_ALLOWED_ORIGINS to list the origins (not *)")
 		}
 	}
 	return nil
 }
+
+// HelpEnv - writes the envar reference (--help-env) generated from the spec
+func HelpEnv(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTYPE\tREQUIRED\tDEFAULT\tDESCRIPTION")
+	for _, s := range Envars {
+		typ := s.Type
+		if s.Type == TYPEENUM {
+			typ = strings.Join(s.Enum, "|")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%t\t%s\t%s\n", s.Name, typ, s.Required, config.Default(s.Name), s.Description)
+	}
+	tw.Flush()
+}
@@ -3,8 +3,13 @@ package validator
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/lmzuccarelli/golang-redis-publisher/pkg/config"
 	"github.com/microlib/simple"
 )
 
@@ -27,9 +32,268 @@ func TestEnvars(t *testing.T) {
 		os.Setenv("JWT_SECRETKEY", "key1")
 		os.Setenv("VERSION", "1.0.3")
 		os.Setenv("NAME", "test")
+		os.Setenv("TOPIC", "test")
+		os.Setenv("DEADLETTER", "file")
 		err := ValidateEnvars(logger)
 		if err != nil {
 			t.Errorf(fmt.Sprintf("Handler %s returned with error - got (%v) wanted (%v)", "ValidateEnvars", err, nil))
 		}
 	})
 }
+
+func TestEnvarSpec(t *testing.T) {
+	logger := &simple.Logger{Level: "info"}
+
+	t.Run("ValidateEnvars : should fail (every problem reported)", func(t *testing.T) {
+		os.Setenv("SERVER_PORT", "70000")
+		os.Setenv("LOG_LEVEL", "verbose")
+		os.Setenv("ENCODING_CONFIG", "../../tests/nothere.json")
+		os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "collector:4318")
+		defer os.Setenv("SERVER_PORT", "9000")
+		defer os.Setenv("LOG_LEVEL", "info")
+		defer os.Unsetenv("ENCODING_CONFIG")
+		defer os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		err := ValidateEnvars(logger)
+		for _, name := range []string{"SERVER_PORT", "LOG_LEVEL", "ENCODING_CONFIG", "OTEL_EXPORTER_OTLP_ENDPOINT"} {
+			if err == nil || !strings.Contains(err.Error(), name) {
+				t.Errorf(fmt.Sprintf("Function %s did not report %s - got (%v)", "ValidateEnvars", name, err))
+			}
+		}
+	})
+
+	t.Run("ValidateEnvars : should fail (values config.Load can not convert reported together)", func(t *testing.T) {
+		os.Setenv("MAX_BODY_SIZE", "big")
+		os.Setenv("LOG_LEVEL", "verbose")
+		os.Setenv("SSE_HEARTBEAT", "15")
+		defer os.Setenv("LOG_LEVEL", "info")
+		defer os.Unsetenv("MAX_BODY_SIZE")
+		defer os.Unsetenv("SSE_HEARTBEAT")
+		cfg, err := config.Load(nil)
+		if cfg == nil || err == nil {
+			t.Fatalf(fmt.Sprintf("Function %s should return the config with an error - got (%v)", "Load", err))
+		}
+		config.Set(cfg)
+		defer config.Set(nil)
+		err = ValidateEnvars(logger)
+		for _, name := range []string{"MAX_BODY_SIZE", "LOG_LEVEL", "SSE_HEARTBEAT"} {
+			if err == nil || !strings.Contains(err.Error(), name) {
+				t.Errorf(fmt.Sprintf("Function %s did not report %s - got (%v)", "ValidateEnvars", name, err))
+			}
+		}
+	})
+
+	t.Run("Envars : should pass (one spec of a matching type per config setting)", func(t *testing.T) {
+		specs := map[string]EnvarSpec{}
+		for _, s := range Envars {
+			if _, ok := specs[s.Name]; ok {
+				t.Errorf(fmt.Sprintf("Spec %s is declared twice", s.Name))
+			}
+			specs[s.Name] = s
+		}
+		ct := reflect.TypeOf(config.Config{})
+		for i := 0; i < ct.NumField(); i++ {
+			f := ct.Field(i)
+			name := f.Tag.Get("env")
+			if name == "" {
+				continue
+			}
+			s, ok := specs[name]
+			if !ok {
+				t.Errorf(fmt.Sprintf("Config setting %s has no spec", name))
+				continue
+			}
+			delete(specs, name)
+			want := map[reflect.Kind][]string{reflect.Bool: {TYPEBOOL}, reflect.Int: {TYPEINT}, reflect.Int64: {TYPEINT}}[f.Type.Kind()]
+			if f.Type == reflect.TypeOf(time.Duration(0)) {
+				want = []string{TYPEDURATION}
+			}
+			if f.Type.Kind() == reflect.String {
+				want = []string{TYPESTRING, TYPEPORT, TYPEURL, TYPEHOSTPORT, TYPEENUM, TYPEFILE}
+			}
+			matches := false
+			for _, typ := range want {
+				matches = matches || typ == s.Type
+			}
+			if !matches {
+				t.Errorf(fmt.Sprintf("Spec %s has type %s, the config field is %s", name, s.Type, f.Type))
+			}
+		}
+		// read by the otel exporter, not the config package
+		delete(specs, "OTEL_EXPORTER_OTLP_ENDPOINT")
+		for name := range specs {
+			t.Errorf(fmt.Sprintf("Spec %s is not a config setting", name))
+		}
+	})
+
+	t.Run("ValidateEnvars : should fail (cors credentials with any origin)", func(t *testing.T) {
+		os.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+		defer os.Unsetenv("CORS_ALLOW_CREDENTIALS")
+		for _, origins := range []string{"", "https://app.example.com, *"} {
+			os.Setenv("CORS_ALLOWED_ORIGINS", origins)
+			err := ValidateEnvars(logger)
+			if err == nil || !strings.Contains(err.Error(), "CORS_ALLOW_CREDENTIALS") {
+				t.Errorf(fmt.Sprintf("Function %s did not report %s - got (%v)", "ValidateEnvars", "CORS_ALLOW_CREDENTIALS", err))
+			}
+		}
+		os.Unsetenv("CORS_ALLOWED_ORIGINS")
+	})
+
+	t.Run("check : should pass (types)", func(t *testing.T) {
+		valid := map[string]string{
+			TYPEDURATION: "30s",
+			TYPEURL:      "http://collector:4318",
+			TYPEHOSTPORT: "localhost:6379",
+			TYPEBOOL:     "true",
+			TYPEFILE:     "../../tests/schemas.json",
+		}
+		for typ, v := range valid {
+			if err := (EnvarSpec{Name: "A", Type: typ}).check(v); err != nil {
+				t.Errorf(fmt.Sprintf("Function %s returned error %v", "check", err))
+			}
+		}
+		if err := (EnvarSpec{Name: "F", Type: TYPEDURATION}).check("30"); err == nil {
+			t.Errorf(fmt.Sprintf("Function %s should fail for a duration without unit", "check"))
+		}
+	})
+
+	t.Run("HelpEnv : should pass (every setting documented)", func(t *testing.T) {
+		var out strings.Builder
+		HelpEnv(&out)
+		for _, name := range config.Names() {
+			if !strings.Contains(out.String(), name) {
+				t.Errorf(fmt.Sprintf("Function %s does not document %s", "HelpEnv", name))
+			}
+		}
+		if !strings.Contains(out.String(), "localhost:6379") {
+			t.Errorf(fmt.Sprintf("Function %s does not show the defaults", "HelpEnv"))
+		}
+	})
+}
+
+func TestPayload(t *testing.T) {
+
+	t.Run("LoadSchemas : should fail (missing schema file)", func(t *testing.T) {
+		err := LoadSchemas("../../tests/nothere.json")
+		if err == nil {
+			t.Errorf(fmt.Sprintf("Function %s returned with no error - got (%v)", "LoadSchemas", err))
+		}
+	})
+
+	t.Run("ValidateInput : should pass", func(t *testing.T) {
+		if err := LoadSchemas("../../tests/schemas.json"); err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned with error - got (%v) wanted (%v)", "LoadSchemas", err, nil))
+		}
+		err := ValidateInput("test", []byte(`{ "request":{"email":"abc@xyz.com", "number":"1234567"}}`))
+		if err != nil {
+			t.Errorf(fmt.Sprintf("Function %s returned with error - got (%v) wanted (%v)", "ValidateInput", err, nil))
+		}
+		// topics without a schema are not validated
+		err = ValidateInput("other", []byte(`{}`))
+		if err != nil {
+			t.Errorf(fmt.Sprintf("Function %s returned with error - got (%v) wanted (%v)", "ValidateInput", err, nil))
+		}
+	})
+
+	t.Run("ValidateInput : should fail (detailed paths)", func(t *testing.T) {
+		err := ValidateInput("test", []byte(`{ "request":{"email":"abc.xyz.com", "number": 1234567}}`))
+		perr, ok := err.(*PayloadError)
+		if !ok || len(perr.Details) != 2 {
+			t.Fatalf(fmt.Sprintf("Function %s returned incorrect error - got (%v)", "ValidateInput", err))
+		}
+		if !strings.HasPrefix(perr.Details[0], "/request/email:") || !strings.HasPrefix(perr.Details[1], "/request/number:") {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect paths - got (%v)", "ValidateInput", perr.Details))
+		}
+		err = ValidateInput("test", []byte(`{ "request":{"email":"abc@xyz.com"}}`))
+		if err == nil || !strings.Contains(err.Error(), "missing properties: 'number'") {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect error - got (%v)", "ValidateInput", err))
+		}
+	})
+
+	t.Run("ValidateOutput : should fail", func(t *testing.T) {
+		err := ValidateOutput("output", []byte(`{ "number":"", "email":"abc@xyz.com", "extra": 1 }`))
+		perr, ok := err.(*PayloadError)
+		if !ok || len(perr.Details) != 2 {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect error - got (%v)", "ValidateOutput", err))
+		}
+		// the input schema of a topic does not apply to its output
+		if err := ValidateOutput("test", []byte(`{}`)); err != nil {
+			t.Errorf(fmt.Sprintf("Function %s returned with error - got (%v) wanted (%v)", "ValidateOutput", err, nil))
+		}
+		_ = LoadSchemas("")
+	})
+}
+
+func TestCompatibility(t *testing.T) {
+	v1 := []byte(`{ "type":"object", "required":["number"], "properties": { "number": { "type":"string" }, "email": { "type":"string" } } }`)
+
+	t.Run("CheckCompatibility : should pass (optional field added)", func(t *testing.T) {
+		v2 := []byte(`{ "type":"object", "required":["number"], "properties": { "number": { "type":"string" }, "email": { "type":"string" }, "mobile": { "type":"string" } } }`)
+		for _, mode := range []string{COMPATBACKWARD, COMPATFORWARD, COMPATFULL} {
+			if err := CheckCompatibility(mode, v1, v2); err != nil {
+				t.Errorf(fmt.Sprintf("Function %s (%s) returned with error - got (%v) wanted (%v)", "CheckCompatibility", mode, err, nil))
+			}
+		}
+	})
+
+	t.Run("CheckCompatibility : should fail backward (new required field)", func(t *testing.T) {
+		v2 := []byte(`{ "type":"object", "required":["number","email"], "properties": { "number": { "type":"string" }, "email": { "type":"string" } } }`)
+		err := CheckCompatibility(COMPATBACKWARD, v1, v2)
+		cerr, ok := err.(*CompatibilityError)
+		if !ok || len(cerr.Details) != 1 || cerr.Details[0] != "(root): property email is now required" {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect error - got (%v)", "CheckCompatibility", err))
+		}
+		// dropping the requirement again is only a forward break
+		if err := CheckCompatibility(COMPATFORWARD, v2, v1); err == nil {
+			t.Errorf(fmt.Sprintf("Function %s returned with no error", "CheckCompatibility"))
+		}
+		if err := CheckCompatibility(COMPATBACKWARD, v2, v1); err != nil {
+			t.Errorf(fmt.Sprintf("Function %s returned with error - got (%v) wanted (%v)", "CheckCompatibility", err, nil))
+		}
+	})
+
+	t.Run("CheckCompatibility : should fail full (type change)", func(t *testing.T) {
+		v2 := []byte(`{ "type":"object", "required":["number"], "properties": { "number": { "type":"integer" }, "email": { "type":"string" } } }`)
+		err := CheckCompatibility(COMPATFULL, v1, v2)
+		cerr, ok := err.(*CompatibilityError)
+		if !ok || len(cerr.Details) != 2 || !strings.HasPrefix(cerr.Details[0], "/number: type") {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect error - got (%v)", "CheckCompatibility", err))
+		}
+		if err := CheckCompatibility(COMPATNONE, v1, v2); err != nil {
+			t.Errorf(fmt.Sprintf("Function %s returned with error - got (%v) wanted (%v)", "CheckCompatibility", err, nil))
+		}
+	})
+
+	t.Run("CheckSchema : should fail", func(t *testing.T) {
+		if err := CheckSchema([]byte(`{ "type": 12 }`)); err == nil {
+			t.Errorf(fmt.Sprintf("Function %s returned with no error", "CheckSchema"))
+		}
+	})
+
+	t.Run("RegisterInput : should pass", func(t *testing.T) {
+		if err := RegisterInput("registry", 3, v1); err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned with error - got (%v) wanted (%v)", "RegisterInput", err, nil))
+		}
+		if TopicVersion("registry") != 3 {
+			t.Errorf(fmt.Sprintf("Function %s returned incorrect version - got (%d) wanted (%d)", "TopicVersion", TopicVersion("registry"), 3))
+		}
+		if err := ValidateInput("registry", []byte(`{ "email":"abc@xyz.com" }`)); err == nil {
+			t.Errorf(fmt.Sprintf("Function %s returned with no error", "ValidateInput"))
+		}
+		_ = LoadSchemas("")
+	})
+
+	t.Run("RegisterInput : should pass (default output schema kept)", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "schemas.json")
+		os.WriteFile(file, []byte(`{ "default": { "output": "../../tests/message.schema.json" }, "topics": {} }`), 0644)
+		if err := LoadSchemas(file); err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned with error - got (%v) wanted (%v)", "LoadSchemas", err, nil))
+		}
+		defer LoadSchemas("")
+		if err := RegisterInput("registry", 1, v1); err != nil {
+			t.Fatalf(fmt.Sprintf("Function %s returned with error - got (%v) wanted (%v)", "RegisterInput", err, nil))
+		}
+		if err := ValidateOutput("registry", []byte(`{ "email":"abc@xyz.com" }`)); err == nil {
+			t.Errorf(fmt.Sprintf("Function %s returned with no error", "ValidateOutput"))
+		}
+	})
+}